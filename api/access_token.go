@@ -0,0 +1,84 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /user/tokens
+func (ah apiHandler) userAccessTokenRoot(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.userAccessTokenList(w, r)
+		case "POST":
+			return ah.userAccessTokenCreate(w, r)
+		}
+	} else {
+		switch r.Method {
+		case "DELETE":
+			return ah.userAccessTokenDelete(w, r, head)
+		}
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type userAccessTokenListResponse struct {
+	Tokens []*models.AccessToken `json:"tokens"`
+}
+
+// GET /user/tokens
+func (ah apiHandler) userAccessTokenList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	ats, err := u.GetAccessTokens(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userAccessTokenListResponse{ats})
+}
+
+type userAccessTokenCreateRequest struct {
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	ReadOnly  bool      `json:"read_only"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type userAccessTokenCreateResponse struct {
+	*models.AccessToken
+	Token      string `json:"token"`
+	StoreToken string `json:"store_token"`
+	PublicKeys []byte `json:"public_key"`
+	SecretKeys []byte `json:"secret_key"`
+}
+
+// POST /user/tokens
+func (ah apiHandler) userAccessTokenCreate(w http.ResponseWriter, r *http.Request) error {
+	uatcr := &userAccessTokenCreateRequest{}
+	if err := jsonDecode(w, r, 4096, uatcr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	at, bearer, err := u.NewAccessToken(ctx, uatcr.Name, uatcr.Scopes, uatcr.ReadOnly, uatcr.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userAccessTokenCreateResponse{at, bearer, at.StoreToken, u.PublicKey, u.Key})
+}
+
+// DELETE /user/tokens/:id
+func (ah apiHandler) userAccessTokenDelete(w http.ResponseWriter, r *http.Request, id string) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := u.DeleteAccessToken(ctx, id); err != nil {
+		return err
+	}
+	return ah.userAccessTokenList(w, r)
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestAccessTokens(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vaults, err := team.GetVaultsForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vaults[0]
+	req := userAccessTokenCreateRequest{"ci", []string{team.Id + "/nope"}, true, time.Now().Add(time.Hour)}
+	r, err := PostRequest("/user/tokens", req)
+	CheckErrorAndResponse(t, r, err, 400)
+	req.Scopes = []string{team.Id + "/" + v.Id}
+	r, err = PostRequest("/user/tokens", req)
+	CheckErrorAndResponse(t, r, err, 200)
+	atr := &userAccessTokenCreateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(atr); err != nil {
+		t.Fatal(err)
+	}
+	if len(atr.Token) == 0 || len(atr.StoreToken) == 0 || len(atr.SecretKeys) == 0 {
+		t.Fatalf("Missing credentials in the access token response %#v", atr)
+	}
+	sessionToken := activeSessionToken
+	activeSessionToken = atr.Token
+	r, err = GetRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id), vaultCreateSecretRequest{Data: a32b})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = GetRequest(fmt.Sprintf("/team/%s/secret", team.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = GetRequest("/team")
+	CheckErrorAndResponse(t, r, err, 200)
+	tr := &teamGetAllResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tr); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.Teams) != 1 || tr.Teams[0].Id != team.Id {
+		t.Fatalf("Access token sees teams out of its scope: %#v", tr.Teams)
+	}
+	activeSessionToken = sessionToken
+	r, err = DeleteRequest("/user/tokens/" + atr.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	atl := &userAccessTokenListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(atl); err != nil {
+		t.Fatal(err)
+	}
+	if len(atl.Tokens) != 0 {
+		t.Fatalf("Expected no tokens and got %d", len(atl.Tokens))
+	}
+	activeSessionToken = atr.Token
+	r, err = GetRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	activeSessionToken = sessionToken
+	if _, err = models.FindAccessToken(ctx, atr.Token); err == nil {
+		t.Fatalf("Deleted access token is still valid")
+	}
+}
+
+func TestAccessTokenCannotManageVaults(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vaults, err := team.GetVaultsForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vaults[0]
+	req := userAccessTokenCreateRequest{"ci", []string{team.Id + "/" + v.Id}, false, time.Now().Add(time.Hour)}
+	r, err := PostRequest("/user/tokens", req)
+	CheckErrorAndResponse(t, r, err, 200)
+	atr := &userAccessTokenCreateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(atr); err != nil {
+		t.Fatal(err)
+	}
+	sessionToken := activeSessionToken
+	activeSessionToken = atr.Token
+	defer func() { activeSessionToken = sessionToken }()
+	path := fmt.Sprintf("/team/%s/vault/%s", team.Id, v.Id)
+	r, err = PatchRequest(path+"/retention", vaultRetentionRequest{KeepVersions: 1})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PatchRequest(path+"/user/"+u.Id, vaultSetUserPermissionRequest{models.VAULT_PERMISSION_READ})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(path)
+	CheckErrorAndResponse(t, r, err, 401)
+	if _, err = team.GetVaultForUser(ctx, v.Id, u); err != nil {
+		t.Fatalf("Access token deleted the vault: %s", err)
+	}
+}
+
+func TestAccessTokenCannotManageTeam(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	member := getDummyUser()
+	if _, err = team.AddOrInviteUserByEmail(ctx, u, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	req := userAccessTokenCreateRequest{"ci", []string{team.Id}, false, time.Now().Add(time.Hour)}
+	r, err := PostRequest("/user/tokens", req)
+	CheckErrorAndResponse(t, r, err, 200)
+	atr := &userAccessTokenCreateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(atr); err != nil {
+		t.Fatal(err)
+	}
+	sessionToken := activeSessionToken
+	activeSessionToken = atr.Token
+	defer func() { activeSessionToken = sessionToken }()
+	path := fmt.Sprintf("/team/%s", team.Id)
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(path+"/user", teamInviteUserRequest{Invite: "someone@nowhere.net"})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PatchRequest(path+"/user/"+member.Id, teamModifyUserRequest{Admin: true})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(path + "/user/" + member.Id)
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PatchRequest(path, teamRenameRequest{Name: "renamed"})
+	CheckErrorAndResponse(t, r, err, 401)
+	users, err := team.GetUsersAfiliationFull(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, tu := range users {
+		if tu.User == member.Id {
+			found = true
+			if tu.Admin {
+				t.Fatal("Access token promoted a member")
+			}
+		}
+	}
+	if !found {
+		t.Fatal("Access token removed a member")
+	}
+}
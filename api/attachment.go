@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// /team/:tid/vault/:vid/secret/:sid/attachment
+func (ah apiHandler) validSecretAttachmentRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.attachmentList(w, r, v, sid)
+		case "POST":
+			return ah.attachmentCreate(w, r, v, sid)
+		}
+	} else {
+		var action string
+		action, r.URL.Path = shiftPath(r.URL.Path)
+		switch {
+		case len(action) == 0 && r.Method == "GET":
+			return ah.attachmentDownload(w, r, v, sid, head)
+		case len(action) == 0 && r.Method == "DELETE":
+			return ah.attachmentDelete(w, r, v, sid, head)
+		case action == "chunk" && r.Method == "PUT":
+			return ah.attachmentUploadChunk(w, r, t, v, sid, head)
+		case action == "complete" && r.Method == "POST":
+			return ah.attachmentComplete(w, r, v, sid, head)
+		}
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type attachmentListResponse struct {
+	Attachments []*models.Attachment `json:"attachments"`
+}
+
+// GET /team/:tid/vault/:vid/secret/:sid/attachment
+func (ah apiHandler) attachmentList(w http.ResponseWriter, r *http.Request, v *models.Vault, sid string) error {
+	atts, err := v.GetAttachments(r.Context(), sid)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, attachmentListResponse{atts})
+}
+
+type attachmentCreateRequest struct {
+	Meta []byte `json:"meta"`
+}
+
+// POST /team/:tid/vault/:vid/secret/:sid/attachment
+func (ah apiHandler) attachmentCreate(w http.ResponseWriter, r *http.Request, v *models.Vault, sid string) error {
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	acr := &attachmentCreateRequest{}
+	if err := jsonDecode(w, r, 16*1024, acr); err != nil {
+		return err
+	}
+	a, err := v.AddAttachment(ctx, sid, acr.Meta)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, a)
+}
+
+// PUT /team/:tid/vault/:vid/secret/:sid/attachment/:aid/chunk/:index
+func (ah apiHandler) attachmentUploadChunk(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid, aid string) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	index, err := strconv.Atoi(head)
+	if err != nil || index < 0 {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	a, err := v.GetAttachment(ctx, sid, aid)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, models.MAX_ATTACHMENT_CHUNK_SIZE))
+	if err != nil {
+		return util.NewErrorf("Invalid chunk: %s", err)
+	}
+	key := a.NewChunkKey(index)
+	if err := ah.blobs.Put(key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+	if a, err = v.AddAttachmentChunk(ctx, sid, aid, index, key, int64(len(data))); err != nil {
+		if derr := ah.blobs.Delete(key); derr != nil {
+			log.Printf("Could not delete rejected attachment chunk %s: %s", key, derr)
+		}
+		return ah.notifyPlanLimit(r, t, err)
+	}
+	return jsonResponse(w, a)
+}
+
+// POST /team/:tid/vault/:vid/secret/:sid/attachment/:aid/complete
+func (ah apiHandler) attachmentComplete(w http.ResponseWriter, r *http.Request, v *models.Vault, sid, aid string) error {
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	a, err := v.CompleteAttachment(ctx, sid, aid)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, a)
+}
+
+// GET /team/:tid/vault/:vid/secret/:sid/attachment/:aid
+// Streams the chunks one after the other. Clients split them with the chunk sizes of the attachment.
+func (ah apiHandler) attachmentDownload(w http.ResponseWriter, r *http.Request, v *models.Vault, sid, aid string) error {
+	a, err := v.GetAttachment(r.Context(), sid, aid)
+	if err != nil {
+		return err
+	}
+	if !a.Complete {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	for i, key := range a.ChunkKeys {
+		rc, err := ah.blobs.Get(key)
+		if err != nil {
+			if i == 0 {
+				w.Header().Del("Content-Length")
+				return err
+			}
+			log.Printf("Could not read chunk %d of attachment %s: %s", i, a.Id, err)
+			return nil
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// DELETE /team/:tid/vault/:vid/secret/:sid/attachment/:aid
+func (ah apiHandler) attachmentDelete(w http.ResponseWriter, r *http.Request, v *models.Vault, sid, aid string) error {
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	a, err := v.GetAttachment(ctx, sid, aid)
+	if err != nil {
+		return err
+	}
+	//Remove the chunks first so a failure leaves the attachment around to be deleted again
+	if err := ah.deleteAttachmentChunks(a); err != nil {
+		return err
+	}
+	if _, err := v.DeleteAttachment(ctx, sid, aid); err != nil {
+		return err
+	}
+	return ah.attachmentList(w, r, v, sid)
+}
+
+func (ah apiHandler) deleteAttachmentChunks(a *models.Attachment) error {
+	for _, key := range a.ChunkKeys {
+		if err := ah.blobs.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
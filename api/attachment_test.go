@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestAttachments(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	path := fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id)
+	r, err := PostRequest(path, &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	path = path + "/" + s.Id + "/attachment"
+	r, err = PostRequest(path, attachmentCreateRequest{Meta: []byte("encrypted name")})
+	CheckErrorAndResponse(t, r, err, 200)
+	a := &models.Attachment{}
+	if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+		t.Fatal(err)
+	}
+	chunks := [][]byte{util.GenerateRandomByteArray(1024), util.GenerateRandomByteArray(512)}
+	r, err = PutRequestRaw(fmt.Sprintf("%s/%s/chunk/1", path, a.Id), chunks[1])
+	CheckErrorAndResponse(t, r, err, 400)
+	for i, chunk := range chunks {
+		r, err = PutRequestRaw(fmt.Sprintf("%s/%s/chunk/%d", path, a.Id, i), chunk)
+		CheckErrorAndResponse(t, r, err, 200)
+	}
+	r, err = GetRequest(path + "/" + a.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest(path+"/"+a.Id+"/complete", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	alr := &attachmentListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(alr); err != nil {
+		t.Fatal(err)
+	}
+	if len(alr.Attachments) != 1 || alr.Attachments[0].Size != 1536 || len(alr.Attachments[0].ChunkSizes) != 2 {
+		t.Fatalf("Unexpected attachments %#v", alr.Attachments)
+	}
+	r, err = GetRequest(path + "/" + a.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, append(chunks[0], chunks[1]...)) {
+		t.Fatal("Downloaded attachment does not match the uploaded chunks")
+	}
+	r, err = DeleteRequest(path + "/" + a.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path + "/" + a.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+}
+
+func TestPurgeOrphanAttachments(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := teams[0].GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	s := &models.Secret{Data: signAndPack(unsealVaultKey(&v.Vault, v.Key), a32b)}
+	if err = v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	a, err := v.AddAttachment(ctx, s.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := a.NewChunkKey(0)
+	if err = apiH.blobs.Put(key, bytes.NewReader(a32b), int64(len(a32b))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.AddAttachmentChunk(ctx, s.Id, a.Id, 0, key, int64(len(a32b))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.DeleteSecret(ctx, s.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.PurgeSecret(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = apiH.purgeOrphanAttachments(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = apiH.blobs.Get(key); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("Chunk of an orphan attachment was not removed: %s", err)
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/keydotcat/keycatd/managers"
@@ -11,22 +12,33 @@ import (
 	"github.com/tomasen/realip"
 )
 
-func (ah apiHandler) getSessionFromHeader(r *http.Request) *managers.Session {
+const (
+	invalidAuthorizationMsg = "Invalid authorization header"
+	sessionExpiredMsg       = "Session expired"
+)
+
+// Returns the message to send back with the 401 if there is no valid session. Unknown tokens
+// are reported as expired since expired sessions may have already been purged.
+func (ah apiHandler) getSessionFromHeader(r *http.Request) (*managers.Session, string) {
 	authHdr := strings.Split(r.Header.Get("Authorization"), " ")
 	if len(authHdr) < 2 || authHdr[0] != "Bearer" {
-		return nil
+		return nil, invalidAuthorizationMsg
 	}
 	s, err := ah.sm.UpdateSession(authHdr[1], realip.FromRequest(r), r.UserAgent())
 	if err != nil {
-		return nil
+		return nil, sessionExpiredMsg
 	}
-	return s
+	return s, ""
 }
 
 func (ah apiHandler) authorizeRequest(w http.ResponseWriter, r *http.Request) *http.Request {
-	s := ah.getSessionFromHeader(r)
+	authHdr := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(authHdr) == 2 && authHdr[0] == "Bearer" && models.IsAccessToken(authHdr[1]) {
+		return ah.authorizeAccessToken(w, r, authHdr[1])
+	}
+	s, msg := ah.getSessionFromHeader(r)
 	if s == nil {
-		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+		http.Error(w, msg, http.StatusUnauthorized)
 		return nil
 	}
 	if s.RequiresCSRF {
@@ -39,7 +51,7 @@ func (ah apiHandler) authorizeRequest(w http.ResponseWriter, r *http.Request) *h
 	}
 	u, err := models.FindUser(r.Context(), s.User)
 	if util.CheckErr(err, models.ErrDoesntExist) {
-		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+		http.Error(w, invalidAuthorizationMsg, http.StatusUnauthorized)
 		//ah.sm.DeleteAllSessions(u.Id)
 		return nil
 	} else if err != nil {
@@ -48,6 +60,34 @@ func (ah apiHandler) authorizeRequest(w http.ResponseWriter, r *http.Request) *h
 	return r.WithContext(ctxAddUser(ctxAddSession(r.Context(), s), u))
 }
 
+// Access tokens get a session that only lives for the request so the handlers can treat them as any other session
+func (ah apiHandler) authorizeAccessToken(w http.ResponseWriter, r *http.Request, bearer string) *http.Request {
+	at, err := models.FindAccessToken(r.Context(), bearer)
+	if util.CheckErr(err, models.ErrUnauthorized) {
+		http.Error(w, sessionExpiredMsg, http.StatusUnauthorized)
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	u, err := models.FindUser(r.Context(), at.User)
+	if util.CheckErr(err, models.ErrDoesntExist) {
+		http.Error(w, invalidAuthorizationMsg, http.StatusUnauthorized)
+		return nil
+	} else if err != nil {
+		panic(err)
+	}
+	s := &managers.Session{
+		Id:         at.Id,
+		User:       at.User,
+		Agent:      r.UserAgent(),
+		LastAccess: at.LastUsedAt.Time,
+		StoreToken: at.StoreToken,
+		LastIp:     realip.FromRequest(r),
+		CreatedAt:  at.CreatedAt,
+	}
+	return r.WithContext(ctxAddAccessToken(ctxAddUser(ctxAddSession(r.Context(), s), u), at))
+}
+
 type authRegisterRequest struct {
 	Username       string `json:"id"`
 	Email          string `json:"email"`
@@ -58,9 +98,19 @@ type authRegisterRequest struct {
 	VaultKey       []byte `json:"vault_keys"`
 }
 
+type authRegisterResponse struct {
+	//Teams that invited the user but are at their members limit. Their invites stay pending
+	PendingTeams []string `json:"pending_teams"`
+}
+
 func (ah apiHandler) authRoot(w http.ResponseWriter, r *http.Request) error {
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
+	if head != "session" {
+		if err := ah.checkIpRateLimit(w, realip.FromRequest(r)); err != nil {
+			return err
+		}
+	}
 	switch head {
 	case "register":
 		return ah.authRegister(w, r)
@@ -68,8 +118,20 @@ func (ah apiHandler) authRoot(w http.ResponseWriter, r *http.Request) error {
 		return ah.authConfirmEmail(w, r)
 	case "request_confirmation_token":
 		return ah.authRequestConfirmationToken(w, r)
+	case "request_password_reset":
+		return ah.authRequestPasswordReset(w, r)
+	case "reset_password":
+		return ah.authResetPassword(w, r)
+	case "request_unlock":
+		return ah.authRequestUnlock(w, r)
+	case "unlock":
+		return ah.authUnlock(w, r)
 	case "login":
 		return ah.authLogin(w, r)
+	case "2fa":
+		return ah.authSecondFactor(w, r)
+	case "oidc":
+		return ah.authOidcRoot(w, r)
 	case "session":
 		return ah.authGetSession(w, r)
 	}
@@ -82,6 +144,9 @@ func (ah apiHandler) authRegister(w http.ResponseWriter, r *http.Request) error
 	if err := jsonDecode(w, r, 1024*5, apr); err != nil {
 		return err
 	}
+	if err := ah.checkAccountRateLimit(w, apr.Username); err != nil {
+		return err
+	}
 	ctx := r.Context()
 	if ah.options.onlyInvited {
 		invs, err := models.FindInvitesForEmail(ctx, apr.Email)
@@ -92,7 +157,7 @@ func (ah apiHandler) authRegister(w http.ResponseWriter, r *http.Request) error
 			return util.NewErrorFrom(models.ErrUnauthorized)
 		}
 	}
-	u, t, err := models.NewUser(
+	u, t, fullTeams, err := models.NewUser(
 		ctx,
 		apr.Username,
 		apr.Fullname,
@@ -110,8 +175,11 @@ func (ah apiHandler) authRegister(w http.ResponseWriter, r *http.Request) error
 	if err := ah.mail.sendConfirmationMail(u, t, r.Header.Get("X-Locale")); err != nil {
 		panic(err)
 	}
-	w.WriteHeader(http.StatusOK)
-	return nil
+	arr := authRegisterResponse{PendingTeams: []string{}}
+	for _, ft := range fullTeams {
+		arr.PendingTeams = append(arr.PendingTeams, ft.Name)
+	}
+	return jsonResponse(w, arr)
 }
 
 // /auth/confirm_email/:token
@@ -120,10 +188,14 @@ func (ah apiHandler) authConfirmEmail(w http.ResponseWriter, r *http.Request) er
 	if len(token) == 0 {
 		return util.NewErrorFrom(models.ErrDoesntExist)
 	}
+	//Guessed tokens belong to no account so only the ip limit applies to them
 	tok, err := models.FindToken(r.Context(), token)
 	if err != nil {
 		return err
 	}
+	if err := ah.checkAccountRateLimit(w, tok.User); err != nil {
+		return err
+	}
 	u, err := tok.ConfirmEmail(r.Context())
 	if err != nil {
 		return util.NewErrorFrom(models.ErrDoesntExist)
@@ -144,6 +216,9 @@ func (ah apiHandler) authRequestConfirmationToken(w http.ResponseWriter, r *http
 	if err := jsonDecode(w, r, 1024, aer); err != nil {
 		return err
 	}
+	if err := ah.checkAccountRateLimit(w, aer.Email); err != nil {
+		return err
+	}
 	u, err := models.FindUserByEmail(r.Context(), aer.Email)
 	if err != nil {
 		if util.CheckErr(err, models.ErrDoesntExist) {
@@ -164,6 +239,127 @@ func (ah apiHandler) authRequestConfirmationToken(w http.ResponseWriter, r *http
 	return nil
 }
 
+// /auth/request_password_reset
+func (ah apiHandler) authRequestPasswordReset(w http.ResponseWriter, r *http.Request) error {
+	aer := &authRequest{}
+	if err := jsonDecode(w, r, 1024, aer); err != nil {
+		return err
+	}
+	if err := ah.checkAccountRateLimit(w, aer.Email); err != nil {
+		return err
+	}
+	u, err := models.FindUserByEmail(r.Context(), aer.Email)
+	if err != nil {
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		return err
+	}
+	if !u.ConfirmedAt.Valid {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	t, err := u.GetPasswordResetToken(r.Context())
+	if err != nil {
+		return err
+	}
+	if err := ah.mail.sendPasswordResetMail(u, t, r.Header.Get("X-Locale")); err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// /auth/request_unlock
+func (ah apiHandler) authRequestUnlock(w http.ResponseWriter, r *http.Request) error {
+	aer := &authRequest{}
+	if err := jsonDecode(w, r, 1024, aer); err != nil {
+		return err
+	}
+	if err := ah.checkAccountRateLimit(w, aer.Email); err != nil {
+		return err
+	}
+	u, err := models.FindUserByEmail(r.Context(), aer.Email)
+	if err != nil {
+		if util.CheckErr(err, models.ErrDoesntExist) {
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+		return err
+	}
+	if !u.IsLocked() {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	t, err := u.GetUnlockToken(r.Context())
+	if err != nil {
+		return err
+	}
+	if err := ah.mail.sendUnlockMail(u, t, r.Header.Get("X-Locale")); err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// /auth/unlock/:token
+func (ah apiHandler) authUnlock(w http.ResponseWriter, r *http.Request) error {
+	token, _ := shiftPath(r.URL.Path)
+	if len(token) == 0 {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	tok, err := models.FindToken(r.Context(), token)
+	if err != nil {
+		return err
+	}
+	if err := ah.checkAccountRateLimit(w, tok.User); err != nil {
+		return err
+	}
+	u, err := tok.UnlockAccount(r.Context())
+	if err != nil {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	return jsonResponse(w, u)
+}
+
+type authResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+	KeyPack  []byte `json:"user_keys"`
+}
+
+type authResetPasswordResponse struct {
+	Username   string                 `json:"user_id"`
+	LostVaults []*models.LostVaultKey `json:"lost_vaults"`
+}
+
+// /auth/reset_password
+func (ah apiHandler) authResetPassword(w http.ResponseWriter, r *http.Request) error {
+	arpr := &authResetPasswordRequest{}
+	if err := jsonDecode(w, r, 8192, arpr); err != nil {
+		return err
+	}
+	if len(arpr.Token) == 0 {
+		return util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	tok, err := models.FindToken(r.Context(), arpr.Token)
+	if err != nil {
+		return err
+	}
+	if err := ah.checkAccountRateLimit(w, tok.User); err != nil {
+		return err
+	}
+	u, lost, err := tok.ResetPassword(r.Context(), arpr.Password, arpr.KeyPack)
+	if err != nil {
+		return err
+	}
+	if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+		return err
+	}
+	return jsonResponse(w, authResetPasswordResponse{u.Id, lost})
+}
+
 type authLoginResponse struct {
 	Username     string `json:"user_id"`
 	Token        string `json:"session_token"`
@@ -180,6 +376,9 @@ func (ah apiHandler) authLogin(w http.ResponseWriter, r *http.Request) error {
 	if err := jsonDecode(w, r, 1024, aer); err != nil {
 		return err
 	}
+	if err := ah.checkAccountRateLimit(w, aer.Id); err != nil {
+		return err
+	}
 	u, err := models.FindUser(r.Context(), aer.Id)
 	if util.CheckErr(err, models.ErrDoesntExist) {
 		return util.NewErrorFrom(models.ErrUnauthorized)
@@ -189,10 +388,109 @@ func (ah apiHandler) authLogin(w http.ResponseWriter, r *http.Request) error {
 	if !u.ConfirmedAt.Valid {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	}
-	if err := u.CheckPassword(aer.Password); err != nil {
+	unlock, err := u.CheckLoginPassword(r.Context(), aer.Password)
+	if unlock != nil {
+		if err := ah.mail.sendUnlockMail(u, unlock, r.Header.Get("X-Locale")); err != nil {
+			panic(err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	methods, err := u.SecondFactorMethods(r.Context())
+	if err != nil {
+		return err
+	}
+	if len(methods) > 0 {
+		return ah.authLoginChallenge(w, r, u, methods, aer.RequireCSRF)
+	}
+	return ah.newLoginSession(w, r, u, aer.RequireCSRF)
+}
+
+type authLoginChallengeResponse struct {
+	Error          string                  `json:"error"`
+	ChallengeToken string                  `json:"challenge_token"`
+	Methods        []string                `json:"methods"`
+	Webauthn       *webauthnRequestOptions `json:"webauthn,omitempty"`
+}
+
+func (ah apiHandler) authLoginChallenge(w http.ResponseWriter, r *http.Request, u *models.User, methods []string, requireCSRF bool) error {
+	ctx := r.Context()
+	t, err := u.NewLoginChallenge(ctx, strconv.FormatBool(requireCSRF))
+	if err != nil {
+		return err
+	}
+	resp := authLoginChallengeResponse{"2fa_required", t.Id, methods, nil}
+	for _, method := range methods {
+		if method == "webauthn" {
+			if resp.Webauthn, err = ah.webauthnRequestOptions(ctx, u, t); err != nil {
+				return err
+			}
+		}
+	}
+	return jsonResponseWithCode(w, http.StatusUnauthorized, resp)
+}
+
+type authWebauthnAssertion struct {
+	Id                string `json:"id"`
+	ClientDataJSON    []byte `json:"client_data_json"`
+	AuthenticatorData []byte `json:"authenticator_data"`
+	Signature         []byte `json:"signature"`
+}
+
+type authSecondFactorRequest struct {
+	ChallengeToken string                 `json:"challenge_token"`
+	Totp           string                 `json:"totp"`
+	Webauthn       *authWebauthnAssertion `json:"webauthn"`
+}
+
+// /auth/2fa
+func (ah apiHandler) authSecondFactor(w http.ResponseWriter, r *http.Request) error {
+	asfr := &authSecondFactorRequest{}
+	if err := jsonDecode(w, r, 8192, asfr); err != nil {
+		return err
+	}
+	if len(asfr.ChallengeToken) == 0 {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	tok, err := models.FindToken(r.Context(), asfr.ChallengeToken)
+	if util.CheckErr(err, models.ErrDoesntExist) {
 		return util.NewErrorFrom(models.ErrUnauthorized)
+	} else if err != nil {
+		return err
+	}
+	if err := ah.checkAccountRateLimit(w, tok.User); err != nil {
+		return err
+	}
+	var u *models.User
+	var unlock *models.Token
+	if asfr.Webauthn != nil {
+		wa := asfr.Webauthn
+		u, unlock, err = tok.CompleteLoginChallengeWithWebauthn(r.Context(), ah.options.webauthn, wa.Id, wa.ClientDataJSON, wa.AuthenticatorData, wa.Signature)
+	} else {
+		u, unlock, err = tok.CompleteLoginChallengeWithTOTP(r.Context(), asfr.Totp)
+	}
+	if unlock != nil {
+		locked, ferr := models.FindUser(r.Context(), tok.User)
+		if ferr != nil {
+			return ferr
+		}
+		if err := ah.mail.sendUnlockMail(locked, unlock, r.Header.Get("X-Locale")); err != nil {
+			panic(err)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	requireCSRF, _ := strconv.ParseBool(tok.Extra)
+	return ah.newLoginSession(w, r, u, requireCSRF)
+}
+
+func (ah apiHandler) newLoginSession(w http.ResponseWriter, r *http.Request, u *models.User, requireCSRF bool) error {
+	if err := u.RegisterSignIn(r.Context()); err != nil {
+		return err
 	}
-	s, err := ah.sm.NewSession(u.Id, realip.FromRequest(r), r.UserAgent(), aer.RequireCSRF)
+	s, err := ah.sm.NewSession(u.Id, realip.FromRequest(r), r.UserAgent(), requireCSRF)
 	if err != nil {
 		panic(err)
 	}
@@ -215,9 +513,9 @@ type authGetSessionResponse struct {
 
 // GET /auth/session/:token
 func (ah apiHandler) authGetSession(w http.ResponseWriter, r *http.Request) error {
-	currentSession := ah.getSessionFromHeader(r)
+	currentSession, msg := ah.getSessionFromHeader(r)
 	if currentSession == nil {
-		http.Error(w, "Invalid authorization header", http.StatusUnauthorized)
+		http.Error(w, msg, http.StatusUnauthorized)
 		return nil
 	}
 	csrf, _ := ah.csrf.getToken(w, r)
@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"strconv"
 	"testing"
 
 	"github.com/keydotcat/keycatd/models"
@@ -67,3 +68,114 @@ func TestLogin(t *testing.T) {
 	}
 	activeCsrfToken = s.Csrf
 }
+
+func TestResetPassword(t *testing.T) {
+	activeSessionToken = ""
+	u := getDummyUser()
+	r, err := PostRequest("/auth/request_password_reset", authRequest{Email: u.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	tokens := models.FindTokensForUser(getCtx(), u.Id)
+	var tok *models.Token
+	for _, token := range tokens {
+		if token.Type == models.TOKEN_PASSWORD_RESET {
+			tok = token
+		}
+	}
+	if tok == nil {
+		t.Fatalf("Could not find the password reset token")
+	}
+	_, _, fullpack := generateNewKeys()
+	arpr := authResetPasswordRequest{Token: tok.Id, Password: "newpass", KeyPack: fullpack}
+	r, err = PostRequest("/auth/reset_password", arpr)
+	CheckErrorAndResponse(t, r, err, 200)
+	rpr := &authResetPasswordResponse{}
+	if err := json.NewDecoder(r.Body).Decode(rpr); err != nil {
+		t.Fatal(err)
+	}
+	if len(rpr.LostVaults) != 1 || rpr.LostVaults[0].Recoverable {
+		t.Fatalf("Expected one unrecoverable vault and got %v", rpr.LostVaults)
+	}
+	r, err = PostRequest("/auth/reset_password", arpr)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: "newpass"})
+	CheckErrorAndResponse(t, r, err, 200)
+}
+
+func TestAccountLockout(t *testing.T) {
+	activeSessionToken = ""
+	u := getDummyUser()
+	ar := authRequest{Id: u.Id, Password: "nope"}
+	for i := 1; i < models.LOCKOUT_ATTEMPTS; i++ {
+		r, err := PostRequest("/auth/login", ar)
+		CheckErrorAndResponse(t, r, err, 401)
+	}
+	r, err := PostRequest("/auth/login", ar)
+	CheckErrorAndResponse(t, r, err, 423)
+	ar.Password = u.Id
+	r, err = PostRequest("/auth/login", ar)
+	CheckErrorAndResponse(t, r, err, 423)
+	r, err = PostRequest("/auth/request_unlock", authRequest{Email: u.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	var tok *models.Token
+	for _, token := range models.FindTokensForUser(getCtx(), u.Id) {
+		if token.Type == models.TOKEN_UNLOCK {
+			tok = token
+		}
+	}
+	if tok == nil {
+		t.Fatalf("No unlock token was generated")
+	}
+	r, err = GetRequest("/auth/unlock/" + tok.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest("/auth/login", ar)
+	CheckErrorAndResponse(t, r, err, 200)
+	nu, err := models.FindUser(getCtx(), u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nu.SignInCount != u.SignInCount+1 || nu.FailedAttempts != 0 {
+		t.Fatalf("Unexpected user state after login: %#v", nu)
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	activeSessionToken = ""
+	ar := authRequest{Id: util.GenerateRandomToken(8), Password: "nope"}
+	for i := 0; i < apiH.options.rateLimit.PerAccount; i++ {
+		r, err := PostRequest("/auth/login", ar)
+		CheckErrorAndResponse(t, r, err, 401)
+	}
+	r, err := PostRequest("/auth/login", ar)
+	CheckErrorAndResponse(t, r, err, 429)
+	if wait, err := strconv.Atoi(r.Header.Get("Retry-After")); err != nil || wait < 1 {
+		t.Fatalf("Invalid Retry-After header '%s'", r.Header.Get("Retry-After"))
+	}
+	ar.Id = util.GenerateRandomToken(8)
+	r, err = PostRequest("/auth/login", ar)
+	CheckErrorAndResponse(t, r, err, 401)
+}
+
+func TestAuthTokenRateLimit(t *testing.T) {
+	activeSessionToken = ""
+	u := getDummyUser()
+	r, err := PostRequest("/auth/request_password_reset", authRequest{Email: u.Email})
+	CheckErrorAndResponse(t, r, err, 200)
+	var tok *models.Token
+	for _, token := range models.FindTokensForUser(getCtx(), u.Id) {
+		if token.Type == models.TOKEN_PASSWORD_RESET {
+			tok = token
+		}
+	}
+	if tok == nil {
+		t.Fatalf("Could not find the password reset token")
+	}
+	arpr := authResetPasswordRequest{Token: tok.Id, Password: "newpass", KeyPack: []byte("nope")}
+	for i := 0; i < apiH.options.rateLimit.PerAccount; i++ {
+		r, err = PostRequest("/auth/reset_password", arpr)
+		CheckErrorAndResponse(t, r, err, 400)
+	}
+	r, err = PostRequest("/auth/reset_password", arpr)
+	CheckErrorAndResponse(t, r, err, 429)
+}
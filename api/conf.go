@@ -2,6 +2,7 @@ package api
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -22,24 +23,94 @@ type ConfSessionRedis struct {
 	DBId   int
 }
 
+// Sessions expire after IdleTTL without being used or AbsoluteTTL after the login. 0 disables the limit
+type ConfSession struct {
+	IdleTTL     time.Duration
+	AbsoluteTTL time.Duration
+}
+
+type ConfLockout struct {
+	Attempts int
+	Duration time.Duration
+}
+
+// Requests allowed per client ip and per target account on /auth in each period. 0 disables the limit
+type ConfRateLimit struct {
+	PerIp      int
+	PerAccount int
+	Period     time.Duration
+}
+
+// OpenID Connect provider users can sign in with. RedirectUrl is where the web client gets the code back
+type ConfOIDC struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+}
+
+// Limits applied to every team. 0 disables the limit
+type ConfTeamLimits struct {
+	Members     int
+	Vaults      int
+	Secrets     int
+	SecretBytes int64
+	Shares      int
+}
+
+// Deleted secrets stay in the trash of their vault for Retention before being purged. 0 keeps them forever
+type ConfTrash struct {
+	Retention time.Duration
+}
+
+// How many versions of each secret are kept and for how many days. Vaults can tighten it. 0 keeps them all
+type ConfSecretVersions struct {
+	Keep int
+	Days int
+}
+
+// Where the encrypted attachment chunks are kept. Either a local directory or an S3 compatible bucket
+type ConfBlobStoreFS struct {
+	Dir string
+}
+
+type ConfBlobStoreS3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+}
+
 type ConfCsrf struct {
 	HashKey  string
 	BlockKey string
 }
 
 type Conf struct {
-	Url           string
-	Port          int
-	DB            string
-	DBMaxConns    int
-	DBType        string
-	OnlyInvited   bool
-	ProxyMode     bool
-	MailSMTP      *ConfMailSMTP
-	MailSparkpost *ConfMailSparkpost
-	MailFrom      string
-	SessionRedis  *ConfSessionRedis
-	Csrf          ConfCsrf
+	Url            string
+	Port           int
+	DB             string
+	DBMaxConns     int
+	DBType         string
+	OnlyInvited    bool
+	Admins         []string
+	ProxyMode      bool
+	MailSMTP       *ConfMailSMTP
+	MailSparkpost  *ConfMailSparkpost
+	MailFrom       string
+	SessionRedis   *ConfSessionRedis
+	Session        ConfSession
+	Csrf           ConfCsrf
+	Lockout        ConfLockout
+	RateLimit      ConfRateLimit
+	OIDC           *ConfOIDC
+	TeamLimits     ConfTeamLimits
+	Trash          ConfTrash
+	SecretVersions ConfSecretVersions
+	BlobStoreFS    *ConfBlobStoreFS
+	BlobStoreS3    *ConfBlobStoreS3
 }
 
 func (c Conf) validate() error {
@@ -78,6 +149,50 @@ func (c Conf) validate() error {
 			return util.NewErrorf("Invalid mail.sparkpost.key")
 		}
 	}
+	if c.Lockout.Attempts < 0 {
+		return util.NewErrorf("Invalid lockout.attempts. Set it to 0 to disable account lockout")
+	}
+	if c.Lockout.Attempts > 0 && c.Lockout.Duration <= 0 {
+		return util.NewErrorf("Invalid lockout.duration")
+	}
+	if c.RateLimit.PerIp < 0 || c.RateLimit.PerAccount < 0 {
+		return util.NewErrorf("Invalid ratelimit. Set it to 0 to disable rate limiting")
+	}
+	if (c.RateLimit.PerIp > 0 || c.RateLimit.PerAccount > 0) && c.RateLimit.Period <= 0 {
+		return util.NewErrorf("Invalid ratelimit.period")
+	}
+	if c.Session.IdleTTL < 0 || c.Session.AbsoluteTTL < 0 {
+		return util.NewErrorf("Invalid session ttl. Set it to 0 to never expire sessions")
+	}
+	tl := c.TeamLimits
+	if tl.Members < 0 || tl.Vaults < 0 || tl.Secrets < 0 || tl.SecretBytes < 0 || tl.Shares < 0 {
+		return util.NewErrorf("Invalid team_limits. Set them to 0 to disable the limits")
+	}
+	if c.Trash.Retention < 0 {
+		return util.NewErrorf("Invalid trash.retention. Set it to 0 to keep deleted secrets forever")
+	}
+	if c.SecretVersions.Keep < 0 || c.SecretVersions.Days < 0 {
+		return util.NewErrorf("Invalid secret_versions. Set them to 0 to keep every version")
+	}
+	fs := c.BlobStoreFS != nil
+	s3 := c.BlobStoreS3 != nil
+	if fs == s3 {
+		return util.NewErrorf("Either configure blob_store.fs (%t) or blob_store.s3 (%t)", fs, s3)
+	}
+	if fs && len(c.BlobStoreFS.Dir) == 0 {
+		return util.NewErrorf("Invalid blob_store.fs.dir")
+	}
+	if s3 && (len(c.BlobStoreS3.Endpoint) == 0 || len(c.BlobStoreS3.Bucket) == 0) {
+		return util.NewErrorf("Invalid blob_store.s3. Both blob_store.s3.endpoint and blob_store.s3.bucket are required")
+	}
+	if c.OIDC != nil {
+		if len(c.OIDC.Issuer) == 0 || len(c.OIDC.ClientId) == 0 {
+			return util.NewErrorf("Invalid oidc. Both oidc.issuer and oidc.client_id are required")
+		}
+		if len(c.OIDC.RedirectUrl) == 0 {
+			return util.NewErrorf("Invalid oidc.redirect_url")
+		}
+	}
 	if c.SessionRedis != nil && len(c.SessionRedis.Server) == 0 {
 		return util.NewErrorf("Invalid session.redis.server")
 	}
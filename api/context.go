@@ -15,6 +15,7 @@ const (
 	contextVaultKey   = contextType(iota)
 	contextSessionKey = contextType(iota)
 	contextCsrfKey    = contextType(iota)
+	contextAccessKey  = contextType(iota)
 )
 
 func ctxAddUser(ctx context.Context, u *models.User) context.Context {
@@ -76,3 +77,13 @@ func ctxGetCsrf(ctx context.Context) string {
 	}
 	return d
 }
+
+func ctxAddAccessToken(ctx context.Context, at *models.AccessToken) context.Context {
+	return context.WithValue(ctx, contextAccessKey, at)
+}
+
+// Returns nil if the request was not authorized with an access token
+func ctxGetAccessToken(ctx context.Context) *models.AccessToken {
+	d, _ := ctx.Value(contextAccessKey).(*models.AccessToken)
+	return d
+}
@@ -16,16 +16,23 @@ var TEST_MODE = false
 
 type apiOptions struct {
 	onlyInvited bool
+	admins      map[string]bool
+	webauthn    util.WebauthnRelyingParty
+	rateLimit   ConfRateLimit
+	trash       ConfTrash
 }
 
 type apiHandler struct {
 	db            *sql.DB
 	sm            managers.SessionMgr
+	rl            managers.RateLimitMgr
 	mail          *mailer
 	csrf          csrf
+	oidc          *oidcLogin
 	staticHandler *StaticHandler
 	options       apiOptions
 	bcast         managers.BroadcasterMgr
+	blobs         managers.BlobStore
 }
 
 func NewAPIHandler(c Conf) (http.Handler, error) {
@@ -36,6 +43,25 @@ func NewAPIHandler(c Conf) (http.Handler, error) {
 	ah := apiHandler{}
 	ah.bcast = managers.NewInternalBroadcasterMgr()
 	ah.options.onlyInvited = c.OnlyInvited
+	ah.options.admins = map[string]bool{}
+	for _, uid := range c.Admins {
+		ah.options.admins[uid] = true
+	}
+	ah.options.rateLimit = c.RateLimit
+	ah.options.trash = c.Trash
+	models.LOCKOUT_ATTEMPTS = c.Lockout.Attempts
+	models.LOCKOUT_DURATION = c.Lockout.Duration
+	models.TEAM_MAX_MEMBERS = c.TeamLimits.Members
+	models.TEAM_MAX_VAULTS = c.TeamLimits.Vaults
+	models.TEAM_MAX_SECRETS = c.TeamLimits.Secrets
+	models.TEAM_MAX_SECRET_BYTES = c.TeamLimits.SecretBytes
+	models.TEAM_MAX_SHARES = c.TeamLimits.Shares
+	models.SECRET_KEEP_VERSIONS = c.SecretVersions.Keep
+	models.SECRET_KEEP_VERSIONS_DAYS = c.SecretVersions.Days
+	ah.options.webauthn, err = util.NewWebauthnRelyingParty("Key.cat", c.Url)
+	if err != nil {
+		return nil, err
+	}
 	ah.db, err = sql.Open("postgres", c.DB)
 	if err != nil {
 		return nil, util.NewErrorf("Could not connect to db '%s': %s", c.DB, err)
@@ -63,20 +89,37 @@ func NewAPIHandler(c Conf) (http.Handler, error) {
 	if err != nil {
 		return nil, util.NewErrorf("Could not create mailer: %s", err)
 	}
+	sessionTTL := managers.SessionTTL{Idle: c.Session.IdleTTL, Absolute: c.Session.AbsoluteTTL}
 	if c.SessionRedis != nil {
-		ah.sm, err = managers.NewSessionMgrRedis(c.SessionRedis.Server, c.SessionRedis.DBId)
+		pool, err := managers.NewRedisPool(c.SessionRedis.Server)
 		if err != nil {
 			return nil, util.NewErrorf("Could not connect to redis at %s: %s", c.SessionRedis.Server, err)
 		}
+		ah.sm = managers.NewSessionMgrRedis(pool, c.SessionRedis.DBId, sessionTTL)
+		ah.rl = managers.NewRateLimitMgrRedis(pool, c.SessionRedis.DBId)
 	} else {
-		ah.sm = managers.NewSessionMgrDB(ah.db)
+		ah.sm = managers.NewSessionMgrDB(ah.db, sessionTTL)
+		ah.rl = managers.NewRateLimitMgrMemory()
+	}
+	if c.BlobStoreS3 != nil {
+		s3 := c.BlobStoreS3
+		ah.blobs, err = managers.NewBlobStoreS3(s3.Endpoint, s3.Region, s3.Bucket, s3.AccessKey, s3.SecretKey, s3.PathStyle)
+	} else {
+		ah.blobs, err = managers.NewBlobStoreFS(c.BlobStoreFS.Dir)
+	}
+	if err != nil {
+		return nil, util.NewErrorf("Could not create blob store: %s", err)
 	}
 	var blockKey []byte
 	if len(c.Csrf.BlockKey) > 0 {
 		blockKey = []byte(c.Csrf.BlockKey)
 	}
 	ah.csrf = newCsrf([]byte(c.Csrf.HashKey), blockKey)
+	if c.OIDC != nil {
+		ah.oidc = newOidcLogin(c.OIDC, []byte(c.Csrf.HashKey), blockKey)
+	}
 	ah.staticHandler = NewStaticHandler()
+	go ah.runPeriodicJobs(periodicJobsInterval)
 	return ah, nil
 }
 
@@ -102,6 +145,8 @@ func (ah apiHandler) apiRoot(w http.ResponseWriter, r *http.Request) {
 		err = ah.authRoot(w, r)
 	case "version":
 		err = ah.versionRoot(w, r)
+	case "share":
+		err = ah.shareRoot(w, r)
 	default:
 		err = ah.authenticatedRoot(w, r, head)
 	}
@@ -110,6 +155,12 @@ func (ah apiHandler) apiRoot(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Server admins can step into teams they do not belong to. Never through an access token.
+func (ah apiHandler) isServerAdmin(r *http.Request) bool {
+	ctx := r.Context()
+	return ah.options.admins[ctxGetUser(ctx).Id] && ctxGetAccessToken(ctx) == nil
+}
+
 func (ah apiHandler) authenticatedRoot(w http.ResponseWriter, r *http.Request, head string) error {
 	//From here on you need to be authenticated
 	err := util.NewErrorFrom(ErrNotFound)
@@ -117,6 +168,12 @@ func (ah apiHandler) authenticatedRoot(w http.ResponseWriter, r *http.Request, h
 	if r == nil {
 		return nil
 	}
+	//Access tokens are meant for automation and can only reach the teams and vaults in their scope
+	if at := ctxGetAccessToken(r.Context()); at != nil {
+		if head != "team" || (at.ReadOnly && r.Method != "GET") {
+			return util.NewErrorFrom(models.ErrUnauthorized)
+		}
+	}
 	switch head {
 	case "session":
 		err = ah.sessionRoot(w, r)
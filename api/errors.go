@@ -2,4 +2,7 @@ package api
 
 import "errors"
 
-var ErrNotFound = errors.New("Not found")
+var (
+	ErrNotFound        = errors.New("Not found")
+	ErrTooManyRequests = errors.New("Too many requests")
+)
@@ -38,6 +38,14 @@ func httpErr(w http.ResponseWriter, err error) bool {
 		w.WriteHeader(http.StatusNotFound)
 	} else if util.CheckErr(err, models.ErrUnauthorized) {
 		w.WriteHeader(http.StatusUnauthorized)
+	} else if util.CheckErr(err, models.ErrAccountLocked) {
+		w.WriteHeader(http.StatusLocked)
+	} else if util.CheckErr(err, models.ErrPlanLimitReached) {
+		w.WriteHeader(http.StatusForbidden)
+	} else if util.CheckErr(err, models.ErrResyncRequired) {
+		w.WriteHeader(http.StatusGone)
+	} else if util.CheckErr(err, ErrTooManyRequests) {
+		w.WriteHeader(http.StatusTooManyRequests)
 	} else if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -46,6 +54,10 @@ func httpErr(w http.ResponseWriter, err error) bool {
 }
 
 func jsonResponse(w http.ResponseWriter, obj interface{}) error {
+	return jsonResponseWithCode(w, http.StatusOK, obj)
+}
+
+func jsonResponseWithCode(w http.ResponseWriter, code int, obj interface{}) error {
 	b := util.BufPool.Get()
 	defer util.BufPool.Put(b)
 	if err := json.NewEncoder(b).Encode(obj); err != nil {
@@ -53,7 +65,7 @@ func jsonResponse(w http.ResponseWriter, obj interface{}) error {
 	}
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Content-Length", strconv.Itoa(len(b.Bytes())))
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(code)
 	b.WriteTo(w)
 	return nil
 }
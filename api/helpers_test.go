@@ -117,6 +117,16 @@ func PostRequest(path string, obj interface{}) (*http.Response, error) {
 	return httpDo(req)
 }
 
+func PutRequestRaw(path string, data []byte) (*http.Response, error) {
+	req, err := http.NewRequest("PUT", srv.URL+path, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = http.Header{}
+	req.Header.Add("Content-Type", "application/octet-stream")
+	return httpDo(req)
+}
+
 func PatchRequest(path string, obj interface{}) (*http.Response, error) {
 	body, err := json.Marshal(obj)
 	if err != nil {
@@ -2,11 +2,13 @@ package api
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
@@ -19,6 +21,7 @@ import (
 
 var srv httptest.Server
 var apiH apiHandler
+var idp *thelpers.StubIdP
 
 func init() {
 	TEST_MODE = true
@@ -31,6 +34,11 @@ func initSRV() {
 	if err != nil {
 		panic(err)
 	}
+	idp = thelpers.NewStubIdP("keycatd", "s3cr3t")
+	blobDir, err := ioutil.TempDir("", "keycat-blobs")
+	if err != nil {
+		panic(err)
+	}
 	c := Conf{
 		Port:     1, //Not used
 		Url:      "http://" + ln.Addr().String(),
@@ -41,6 +49,23 @@ func initSRV() {
 			Server: "localhost:6379",
 			DBId:   10,
 		},
+		RateLimit: ConfRateLimit{
+			PerAccount: 10,
+			Period:     time.Minute,
+		},
+		Lockout: ConfLockout{
+			Attempts: 3,
+			Duration: time.Minute,
+		},
+		OIDC: &ConfOIDC{
+			Issuer:       idp.URL,
+			ClientId:     "keycatd",
+			ClientSecret: "s3cr3t",
+			RedirectUrl:  "http://" + ln.Addr().String() + "/oidc/callback",
+		},
+		BlobStoreFS: &ConfBlobStoreFS{
+			Dir: blobDir,
+		},
 		Csrf: ConfCsrf{
 			HashKey:  "4d018d7e070ca9d5da7e767001bdaf90",
 			BlockKey: "4e3797182c94f05b384c81ed0246f6b4",
@@ -88,7 +113,7 @@ func getDummyUser() *models.User {
 	uid := util.GenerateRandomToken(5)
 	_, priv, fullpack := generateNewKeys()
 	vkp := getDummyVaultKeyPair(priv, uid)
-	u, t, err := models.NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, fullpack, vkp)
+	u, t, _, err := models.NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, fullpack, vkp)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+const periodicJobsInterval = 10 * time.Minute
+
+// Uploads that have not been completed after this long are removed
+const staleAttachmentTTL = 24 * time.Hour
+
+func (ah apiHandler) runPeriodicJobs(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := ah.sm.PurgeExpiredSessions(); err != nil {
+			log.Printf("Could not purge expired sessions: %s", err)
+		}
+		ctx := models.AddDBToContext(context.Background(), ah.db)
+		if ah.options.trash.Retention > 0 {
+			if _, err := models.PurgeDeletedSecrets(ctx, time.Now().UTC().Add(-ah.options.trash.Retention)); err != nil {
+				log.Printf("Could not purge deleted secrets: %s", err)
+			}
+		}
+		if err := models.PurgeExpiredShares(ctx); err != nil {
+			log.Printf("Could not purge expired shares: %s", err)
+		}
+		if err := models.PruneSecretVersions(ctx); err != nil {
+			log.Printf("Could not prune old secret versions: %s", err)
+		}
+		if err := ah.purgeOrphanAttachments(ctx); err != nil {
+			log.Printf("Could not purge orphan attachments: %s", err)
+		}
+	}
+}
+
+// Removes the attachments of secrets, vaults and teams that no longer exist from the blob store
+func (ah apiHandler) purgeOrphanAttachments(ctx context.Context) error {
+	atts, err := models.GetOrphanAttachments(ctx, time.Now().UTC().Add(-staleAttachmentTTL))
+	if err != nil {
+		return err
+	}
+	for _, a := range atts {
+		if err := ah.deleteAttachmentChunks(a); err != nil {
+			return err
+		}
+		if err := a.Purge(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
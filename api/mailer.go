@@ -61,6 +61,7 @@ type mailUserTeamTokenData struct {
 	Token    string
 	Email    string
 	Username string
+	Limit    string
 }
 
 func (mm *mailer) send(muttd mailUserTeamTokenData, locale, templateName, subject string) error {
@@ -92,11 +93,49 @@ func (mm *mailer) sendConfirmationMail(u *models.User, token *models.Token, loca
 	return mm.send(muttd, locale, "confirm_account", "Confirm your email")
 }
 
+func (mm *mailer) sendPasswordResetMail(u *models.User, token *models.Token, locale string) error {
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Token: token.Id, Username: u.Id, Email: u.Email}
+	return mm.send(muttd, locale, "forgotten_password", "Reset your password")
+}
+
+func (mm *mailer) sendUnlockMail(u *models.User, token *models.Token, locale string) error {
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Token: token.Id, Username: u.Id, Email: u.Email}
+	return mm.send(muttd, locale, "unlock_account", "Your account has been locked")
+}
+
+func (mm *mailer) sendAccountDeletedMail(u *models.User, locale string) error {
+	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Username: u.Id, Email: u.Email}
+	return mm.send(muttd, locale, "account_deleted", "Your account has been deleted")
+}
+
 func (mm *mailer) sendInvitationMail(t *models.Team, u *models.User, i *models.Invite, locale string) error {
 	muttd := mailUserTeamTokenData{FullName: u.FullName, HostUrl: mm.rootUrl, Email: i.Email, Team: t.Name}
 	return mm.send(muttd, locale, "invite_user", fmt.Sprintf("%s has invited you to join key.cat", u.FullName))
 }
 
+func (mm *mailer) sendVaultAccessRequestMail(t *models.Team, requester *models.User, admin *models.User, locale string) error {
+	muttd := mailUserTeamTokenData{FullName: requester.FullName, HostUrl: mm.rootUrl, Email: admin.Email, Team: t.Name, Username: requester.Id}
+	return mm.send(muttd, locale, "vault_access_requested", fmt.Sprintf("%s is asking for access to a vault", requester.FullName))
+}
+
+// Names of the plan limits as shown in the mails
+var planLimitNames = map[string]string{
+	"team_members":      "members",
+	"team_vaults":       "vaults",
+	"team_secrets":      "secrets",
+	"team_secret_bytes": "storage",
+	"team_shares":       "shared secrets",
+}
+
+func (mm *mailer) sendPlanLimitReachedMail(t *models.Team, admin *models.User, limit, locale string) error {
+	name, ok := planLimitNames[limit]
+	if !ok {
+		name = limit
+	}
+	muttd := mailUserTeamTokenData{FullName: admin.FullName, HostUrl: mm.rootUrl, Email: admin.Email, Team: t.Name, Username: admin.Id, Limit: name}
+	return mm.send(muttd, locale, "plan_limit_reached", fmt.Sprintf("%s has reached its plan limit of %s", t.Name, name))
+}
+
 func (mm *mailer) sendTestEmail(to string) error {
 	muttd := mailUserTeamTokenData{Email: to}
 	return mm.send(muttd, "en", "test_email", "KeyCat test email")
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	oidcStateName = "oidc_state"
+	oidcStateTTL  = 10 * time.Minute
+)
+
+// The state sent to the IdP is sealed with the csrf keys so the server does not need to keep
+// track of the sign ins in progress. It holds the nonce the id token has to carry.
+type oidcState struct {
+	Nonce       string
+	Link        string
+	RequireCSRF bool
+}
+
+type oidcLogin struct {
+	provider *util.OIDCProvider
+	states   *securecookie.SecureCookie
+}
+
+func newOidcLogin(c *ConfOIDC, hKey, bKey []byte) *oidcLogin {
+	states := securecookie.New(hKey, bKey)
+	states.MaxAge(int(oidcStateTTL / time.Second))
+	return &oidcLogin{util.NewOIDCProvider(c.Issuer, c.ClientId, c.ClientSecret, c.RedirectUrl), states}
+}
+
+type oidcAuthorizationResponse struct {
+	AuthorizationUrl string `json:"authorization_url"`
+	State            string `json:"state"`
+}
+
+func (ol *oidcLogin) authorize(w http.ResponseWriter, link string, requireCSRF bool) error {
+	st := oidcState{util.GenerateRandomToken(16), link, requireCSRF}
+	state, err := ol.states.Encode(oidcStateName, st)
+	if err != nil {
+		panic(err)
+	}
+	authUrl, err := ol.provider.AuthorizationUrl(state, st.Nonce)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, oidcAuthorizationResponse{authUrl, state})
+}
+
+type oidcCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// Checks that the state was issued by us for the same purpose and exchanges the code
+func (ol *oidcLogin) callback(w http.ResponseWriter, r *http.Request, link string) (*util.OIDCClaims, *oidcState, error) {
+	ocr := &oidcCallbackRequest{}
+	if err := jsonDecode(w, r, 8192, ocr); err != nil {
+		return nil, nil, err
+	}
+	st := &oidcState{}
+	if err := ol.states.Decode(oidcStateName, ocr.State, st); err != nil || st.Link != link {
+		return nil, nil, util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	claims, err := ol.provider.Exchange(ocr.Code, st.Nonce)
+	if util.CheckErr(err, util.ErrInvalidOIDCResponse) {
+		return nil, nil, util.NewErrorFrom(models.ErrUnauthorized)
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return claims, st, nil
+}
+
+// /auth/oidc
+func (ah apiHandler) authOidcRoot(w http.ResponseWriter, r *http.Request) error {
+	if ah.oidc == nil {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(head) == 0 && r.Method == "GET":
+		return ah.authOidcInfo(w, r)
+	case head == "login" && r.Method == "POST":
+		return ah.authOidcLogin(w, r)
+	case head == "callback" && r.Method == "POST":
+		return ah.authOidcCallback(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type authOidcInfoResponse struct {
+	Issuer string `json:"issuer"`
+}
+
+// GET /auth/oidc
+func (ah apiHandler) authOidcInfo(w http.ResponseWriter, r *http.Request) error {
+	return jsonResponse(w, authOidcInfoResponse{ah.oidc.provider.Issuer})
+}
+
+type authOidcLoginRequest struct {
+	RequireCSRF bool `json:"want_csrf"`
+}
+
+// POST /auth/oidc/login
+func (ah apiHandler) authOidcLogin(w http.ResponseWriter, r *http.Request) error {
+	aolr := &authOidcLoginRequest{}
+	if err := jsonDecode(w, r, 1024, aolr); err != nil {
+		return err
+	}
+	return ah.oidc.authorize(w, "", aolr.RequireCSRF)
+}
+
+type authOidcNotLinkedResponse struct {
+	Error string `json:"error"`
+	Email string `json:"email"`
+}
+
+// POST /auth/oidc/callback
+func (ah apiHandler) authOidcCallback(w http.ResponseWriter, r *http.Request) error {
+	claims, st, err := ah.oidc.callback(w, r, "")
+	if err != nil {
+		return err
+	}
+	u, err := models.FindUserByOidcIdentity(r.Context(), claims.Issuer, claims.Subject)
+	if util.CheckErr(err, models.ErrDoesntExist) {
+		return jsonResponseWithCode(w, http.StatusUnauthorized, authOidcNotLinkedResponse{"oidc_not_linked", claims.Email})
+	} else if err != nil {
+		return err
+	}
+	if !u.ConfirmedAt.Valid {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := ah.checkAccountRateLimit(w, u.Id); err != nil {
+		return err
+	}
+	if u.IsLocked() {
+		return util.NewErrorFrom(models.ErrAccountLocked)
+	}
+	//The IdP only stands in for the password. Users with a second factor still have to pass it
+	methods, err := u.SecondFactorMethods(r.Context())
+	if err != nil {
+		return err
+	}
+	if len(methods) > 0 {
+		return ah.authLoginChallenge(w, r, u, methods, st.RequireCSRF)
+	}
+	return ah.newLoginSession(w, r, u, st.RequireCSRF)
+}
+
+// /user/oidc
+func (ah apiHandler) userOidcRoot(w http.ResponseWriter, r *http.Request) error {
+	if ah.oidc == nil {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(head) == 0 && r.Method == "GET":
+		return ah.userOidcList(w, r)
+	case len(head) == 0 && r.Method == "POST":
+		return ah.userOidcBeginLink(w, r)
+	case len(head) == 0 && r.Method == "DELETE":
+		return ah.userOidcUnlink(w, r)
+	case head == "callback" && r.Method == "POST":
+		return ah.userOidcFinishLink(w, r)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type userOidcListResponse struct {
+	Identities []*models.OidcIdentity `json:"identities"`
+}
+
+// GET /user/oidc
+func (ah apiHandler) userOidcList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	ois, err := ctxGetUser(ctx).GetOidcIdentities(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userOidcListResponse{ois})
+}
+
+// POST /user/oidc
+func (ah apiHandler) userOidcBeginLink(w http.ResponseWriter, r *http.Request) error {
+	return ah.oidc.authorize(w, ctxGetUser(r.Context()).Id, false)
+}
+
+// POST /user/oidc/callback
+func (ah apiHandler) userOidcFinishLink(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	claims, _, err := ah.oidc.callback(w, r, u.Id)
+	if err != nil {
+		return err
+	}
+	oi, err := u.LinkOidcIdentity(ctx, claims.Issuer, claims.Subject, claims.Email)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, oi)
+}
+
+// DELETE /user/oidc
+func (ah apiHandler) userOidcUnlink(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := u.UnlinkOidcIdentity(ctx, ah.oidc.provider.Issuer); err != nil {
+		return err
+	}
+	return ah.userOidcList(w, r)
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func oidcAuthorize(t *testing.T, path, subject string) oidcCallbackRequest {
+	r, err := PostRequest(path, authOidcLoginRequest{})
+	CheckErrorAndResponse(t, r, err, 200)
+	oar := &oidcAuthorizationResponse{}
+	if err := json.NewDecoder(r.Body).Decode(oar); err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := idp.Authorize(oar.AuthorizationUrl, subject, subject+"@idp.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != oar.State {
+		t.Fatalf("The IdP returned a different state")
+	}
+	return oidcCallbackRequest{code, state}
+}
+
+func TestOidcLinkAndLogin(t *testing.T) {
+	u := loginDummyUser()
+	sessionToken := activeSessionToken
+	r, err := GetRequest("/auth/oidc")
+	CheckErrorAndResponse(t, r, err, 200)
+	subject := "sub-" + u.Id
+	loginState := oidcAuthorize(t, "/auth/oidc/login", subject)
+	r, err = PostRequest("/user/oidc/callback", loginState)
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/user/oidc/callback", oidcAuthorize(t, "/user/oidc", subject))
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/oidc/callback", oidcAuthorize(t, "/auth/oidc/login", "unknown-"+u.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	nlr := &authOidcNotLinkedResponse{}
+	if err := json.NewDecoder(r.Body).Decode(nlr); err != nil {
+		t.Fatal(err)
+	}
+	if nlr.Error != "oidc_not_linked" {
+		t.Fatalf("Unexpected response for an unlinked subject %#v", nlr)
+	}
+	r, err = PostRequest("/auth/oidc/callback", oidcCallbackRequest{"invalid", loginState.State})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/oidc/callback", oidcAuthorize(t, "/auth/oidc/login", subject))
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &authLoginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Username != u.Id || len(s.Token) == 0 || len(s.SecretKeys) == 0 {
+		t.Fatalf("Unexpected login response %#v", s)
+	}
+	activeSessionToken = sessionToken
+	r, err = DeleteRequest("/user/oidc")
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/oidc/callback", oidcAuthorize(t, "/auth/oidc/login", subject))
+	CheckErrorAndResponse(t, r, err, 401)
+	activeSessionToken = sessionToken
+}
+
+func TestOidcLoginSecondFactor(t *testing.T) {
+	u := loginDummyUser()
+	r, err := PostRequest("/user/2fa", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	tgr := &userTOTPGenerateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tgr); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(tgr.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	r, err = PostRequest("/user/2fa/confirm", userTOTPCodeRequest{util.TOTPCode(secret, now.Add(-util.TOTP_PERIOD*time.Second))})
+	CheckErrorAndResponse(t, r, err, 200)
+	subject := "sub-" + u.Id
+	r, err = PostRequest("/user/oidc/callback", oidcAuthorize(t, "/user/oidc", subject))
+	CheckErrorAndResponse(t, r, err, 200)
+	sessionToken := activeSessionToken
+	activeSessionToken = ""
+	defer func() { activeSessionToken = sessionToken }()
+	r, err = PostRequest("/auth/oidc/callback", oidcAuthorize(t, "/auth/oidc/login", subject))
+	CheckErrorAndResponse(t, r, err, 401)
+	lcr := &authLoginChallengeResponse{}
+	if err := json.NewDecoder(r.Body).Decode(lcr); err != nil {
+		t.Fatal(err)
+	}
+	if lcr.Error != "2fa_required" || len(lcr.ChallengeToken) == 0 {
+		t.Fatalf("Expected a second factor challenge and got %#v", lcr)
+	}
+	r, err = PostRequest("/auth/2fa", authSecondFactorRequest{ChallengeToken: lcr.ChallengeToken, Totp: util.TOTPCode(secret, now)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &authLoginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Username != u.Id || len(s.Token) == 0 {
+		t.Fatalf("Unexpected login response %#v", s)
+	}
+}
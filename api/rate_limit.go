@@ -0,0 +1,36 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/keydotcat/keycatd/managers"
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Takes a token from the bucket for scope and key. When the bucket is empty the Retry-After header
+// is set and ErrTooManyRequests is returned so httpErr answers with a 429.
+func (ah apiHandler) checkRateLimit(w http.ResponseWriter, scope, key string, requests int) error {
+	if requests < 1 || len(key) == 0 {
+		return nil
+	}
+	limit := managers.RateLimit{Requests: requests, Period: ah.options.rateLimit.Period}
+	ok, wait, err := ah.rl.Allow(scope+":"+key, limit)
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return util.NewErrorFrom(ErrTooManyRequests)
+	}
+	return nil
+}
+
+func (ah apiHandler) checkIpRateLimit(w http.ResponseWriter, ip string) error {
+	return ah.checkRateLimit(w, "ip", ip, ah.options.rateLimit.PerIp)
+}
+
+func (ah apiHandler) checkAccountRateLimit(w http.ResponseWriter, account string) error {
+	return ah.checkRateLimit(w, "account", account, ah.options.rateLimit.PerAccount)
+}
@@ -2,6 +2,8 @@ package api
 
 import (
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
@@ -48,19 +50,39 @@ func (ah apiHandler) validVaultSecretRoot(w http.ResponseWriter, r *http.Request
 			return ah.vaultCreateSecret(w, r, t, v)
 		}
 	} else {
-		switch r.Method {
-		case "DELETE":
+		var action string
+		action, r.URL.Path = shiftPath(r.URL.Path)
+		switch {
+		case len(action) == 0 && r.Method == "DELETE":
 			return ah.vaultDeleteSecret(w, r, t, v, head)
-		case "PATCH", "PUT":
+		case len(action) == 0 && (r.Method == "PATCH" || r.Method == "PUT"):
 			return ah.vaultUpdateSecret(w, r, t, v, head)
+		case action == "versions" && r.Method == "GET":
+			return ah.vaultGetSecretVersions(w, r, t, v, head)
+		case action == "restore" && r.Method == "POST":
+			return ah.vaultRestoreSecretVersion(w, r, t, v, head)
+		case action == "attachment":
+			return ah.validSecretAttachmentRoot(w, r, t, v, head)
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+// GET /team/:tid/vault/:vid/secret[?since=:vault_version]
+// Answers 410 when changes after since were already purged. Clients have to fetch the whole vault then.
 func (ah apiHandler) vaultGetSecrets(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
 	ctx := r.Context()
-	secrets, err := v.GetSecrets(ctx)
+	var secrets []*models.Secret
+	var err error
+	if since := r.URL.Query().Get("since"); len(since) > 0 {
+		version, perr := strconv.ParseUint(since, 10, 32)
+		if perr != nil {
+			return util.NewErrorf("Invalid since vault version")
+		}
+		secrets, err = v.GetSecretsSince(ctx, uint32(version))
+	} else {
+		secrets, err = v.GetSecrets(ctx)
+	}
 	if err != nil {
 		return err
 	}
@@ -69,20 +91,52 @@ func (ah apiHandler) vaultGetSecrets(w http.ResponseWriter, r *http.Request, t *
 }
 
 type vaultCreateSecretRequest struct {
-	Team  string `json:"team"`
-	Vault string `json:"vault"`
-	Data  []byte `json:"data"`
+	Team    string `json:"team"`
+	Vault   string `json:"vault"`
+	Data    []byte `json:"data"`
+	Version uint32 `json:"version,omitempty"`
+}
+
+type vaultSecretConflictResponse struct {
+	Error  string         `json:"error"`
+	Secret *models.Secret `json:"secret"`
+}
+
+// Version of the secret the client expects to replace. The If-Match header takes precedence over the
+// version sent in the body. 0 means that any version can be replaced.
+func expectedSecretVersion(r *http.Request, version uint32) (uint32, error) {
+	etag := strings.TrimSpace(r.Header.Get("If-Match"))
+	if len(etag) == 0 || etag == "*" {
+		return version, nil
+	}
+	expected, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(etag, "W/"), `"`), 10, 32)
+	if err != nil {
+		return 0, util.NewErrorf("Invalid If-Match secret version")
+	}
+	return uint32(expected), nil
+}
+
+// Replies with the current version of the secret so the client can merge its changes
+func (ah apiHandler) secretConflict(w http.ResponseWriter, r *http.Request, v *models.Vault, sid string) error {
+	s, err := v.GetSecret(r.Context(), sid)
+	if err != nil {
+		return err
+	}
+	return jsonResponseWithCode(w, http.StatusConflict, vaultSecretConflictResponse{"version_conflict", s})
 }
 
 func (ah apiHandler) vaultCreateSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
 	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
 	vscr := &vaultCreateSecretRequest{}
 	if err := jsonDecode(w, r, 16*1024, vscr); err != nil {
 		return err
 	}
 	s := &models.Secret{Data: vscr.Data}
 	if err := v.AddSecret(ctx, s); err != nil {
-		return err
+		return ah.notifyPlanLimit(r, t, err)
 	}
 	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_NEW, s)
 	return jsonResponse(w, s)
@@ -90,25 +144,45 @@ func (ah apiHandler) vaultCreateSecret(w http.ResponseWriter, r *http.Request, t
 
 func (ah apiHandler) vaultDeleteSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
 	ctx := r.Context()
-	if err := v.DeleteSecret(ctx, sid); err != nil {
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	version, err := expectedSecretVersion(r, 0)
+	if err != nil {
 		return err
 	}
-	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_REMOVE, &models.Secret{Id: sid})
+	s, err := v.DeleteSecret(ctx, sid, version)
+	if util.CheckErr(err, models.ErrVersionConflict) {
+		return ah.secretConflict(w, r, v, sid)
+	} else if err != nil {
+		return err
+	}
+	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_REMOVE, s)
 	return jsonResponse(w, v)
 }
 
 func (ah apiHandler) vaultUpdateSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
 	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
 	vscr := &vaultCreateSecretRequest{}
 	if err := jsonDecode(w, r, 16*1024, vscr); err != nil {
 		return err
 	}
-	s := &models.Secret{Id: sid, Data: vscr.Data}
+	version, err := expectedSecretVersion(r, vscr.Version)
+	if err != nil {
+		return err
+	}
+	s := &models.Secret{Id: sid, Data: vscr.Data, Version: version}
 	if len(vscr.Vault) == 0 || (t.Id == vscr.Team && v.Id == vscr.Vault) {
 		//Modify secret
 		if len(vscr.Data) > 0 {
-			if err := v.UpdateSecret(ctx, s); err != nil {
-				return err
+			err := v.UpdateSecret(ctx, s)
+			if util.CheckErr(err, models.ErrVersionConflict) {
+				return ah.secretConflict(w, r, v, sid)
+			} else if err != nil {
+				return ah.notifyPlanLimit(r, t, err)
 			}
 			ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_CHANGE, s)
 		}
@@ -128,15 +202,107 @@ func (ah apiHandler) vaultUpdateSecret(w http.ResponseWriter, r *http.Request, t
 		if err != nil {
 			return err
 		}
-		if err := models.MoveSecretToVault(ctx, s, v, targetVault); err != nil {
+		if err := targetVault.CheckPermission(ctx, u, models.VAULT_PERMISSION_WRITE); err != nil {
 			return err
 		}
+		err = models.MoveSecretToVault(ctx, s, v, targetVault)
+		if util.CheckErr(err, models.ErrVersionConflict) {
+			return ah.secretConflict(w, r, v, sid)
+		} else if err != nil {
+			return ah.notifyPlanLimit(r, targetTeam, err)
+		}
 		ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_REMOVE, &models.Secret{Id: sid})
 		ah.bcast.Send(targetTeam.Id, targetVault.Id, managers.BCAST_ACTION_SECRET_NEW, s)
 		return jsonResponse(w, s)
 	}
 }
 
+// GET /team/:tid/vault/:vid/secret/:sid/versions
+func (ah apiHandler) vaultGetSecretVersions(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
+	secrets, err := v.GetSecretVersions(r.Context(), sid)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamSecretListWrap{secrets})
+}
+
+// POST /team/:tid/vault/:vid/secret/:sid/restore/:version
+func (ah apiHandler) vaultRestoreSecretVersion(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	version, err := strconv.ParseUint(head, 10, 32)
+	if err != nil {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	s, err := v.RestoreSecretVersion(ctx, sid, uint32(version))
+	if err != nil {
+		return ah.notifyPlanLimit(r, t, err)
+	}
+	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_CHANGE, s)
+	return jsonResponse(w, s)
+}
+
+// /team/:tid/vault/:vid/trash
+func (ah apiHandler) validVaultTrashRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.vaultGetTrash(w, r, t, v)
+		}
+	} else {
+		var action string
+		action, r.URL.Path = shiftPath(r.URL.Path)
+		switch {
+		case len(action) == 0 && r.Method == "DELETE":
+			return ah.vaultPurgeSecret(w, r, t, v, head)
+		case action == "restore" && r.Method == "POST":
+			return ah.vaultRestoreSecret(w, r, t, v, head)
+		}
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+// GET /team/:tid/vault/:vid/trash
+func (ah apiHandler) vaultGetTrash(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	secrets, err := v.GetTrash(r.Context())
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamSecretListWrap{secrets})
+}
+
+// POST /team/:tid/vault/:vid/trash/:sid/restore
+func (ah apiHandler) vaultRestoreSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	s, err := v.RestoreSecret(ctx, sid)
+	if err != nil {
+		return err
+	}
+	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_RESTORE, s)
+	return jsonResponse(w, s)
+}
+
+// DELETE /team/:tid/vault/:vid/trash/:sid
+func (ah apiHandler) vaultPurgeSecret(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, sid string) error {
+	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
+	if err := v.PurgeSecret(ctx, sid); err != nil {
+		return err
+	}
+	return jsonResponse(w, v)
+}
+
 // /team/:tid/vault/:vid/secrets
 func (ah apiHandler) validVaultSecretsRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
 	var head string
@@ -152,6 +318,9 @@ func (ah apiHandler) validVaultSecretsRoot(w http.ResponseWriter, r *http.Reques
 
 func (ah apiHandler) vaultCreateSecretList(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
 	ctx := r.Context()
+	if err := v.CheckPermission(ctx, ctxGetUser(ctx), models.VAULT_PERMISSION_WRITE); err != nil {
+		return err
+	}
 	vl := &teamSecretListWrap{}
 	if err := jsonDecode(w, r, 1024*1024, &vl); err != nil {
 		return err
@@ -161,7 +330,7 @@ func (ah apiHandler) vaultCreateSecretList(w http.ResponseWriter, r *http.Reques
 		sl[i] = &models.Secret{Data: vc.Data}
 	}
 	if err := v.AddSecretList(ctx, sl); err != nil {
-		return err
+		return ah.notifyPlanLimit(r, t, err)
 	}
 	for _, s := range sl {
 		ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_SECRET_NEW, s)
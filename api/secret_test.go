@@ -3,9 +3,11 @@ package api
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"testing"
 
 	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
 )
 
 func TestGetAllSecrets(t *testing.T) {
@@ -97,3 +99,247 @@ func TestAddSecretList(t *testing.T) {
 	}
 
 }
+
+func TestReadOnlyVaultUser(t *testing.T) {
+	ctx := getCtx()
+	owner := loginDummyUser()
+	ownerToken := activeSessionToken
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := team.GetVaultsFullForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	auditor := loginDummyUser()
+	auditorToken := activeSessionToken
+	if _, err = team.AddOrInviteUserByEmail(ctx, owner, auditor.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Vault.AddUsers(ctx, map[string][]byte{auditor.Id: v.Key}); err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/team/%s/vault/%s", team.Id, v.Id)
+	r, err := PatchRequest(path+"/user/"+auditor.Id, vaultSetUserPermissionRequest{models.VAULT_PERMISSION_READ})
+	CheckErrorAndResponse(t, r, err, 401)
+	activeSessionToken = ownerToken
+	r, err = PatchRequest(path+"/user/"+auditor.Id, vaultSetUserPermissionRequest{models.VAULT_PERMISSION_READ})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(path+"/secret", &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = auditorToken
+	r, err = GetRequest(path + "/secret")
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(path+"/secret", &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PatchRequest(path+"/secret/"+s.Id, &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(path + "/secret/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 401)
+}
+
+func TestSecretVersionsAndRestore(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	path := fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id)
+	first := signAndPack(vPriv, a32b)
+	r, err := PostRequest(path, &vaultCreateSecretRequest{Data: first})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PatchRequest(path+"/"+s.Id, &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path + "/" + s.Id + "/versions")
+	CheckErrorAndResponse(t, r, err, 200)
+	sl := &teamSecretListWrap{}
+	if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+		t.Fatal(err)
+	}
+	if len(sl.Secrets) != 2 {
+		t.Fatalf("Expected 2 versions and got %d", len(sl.Secrets))
+	}
+	r, err = PostRequest(path+"/"+s.Id+"/restore/7", nil)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest(path+"/"+s.Id+"/restore/1", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	restored := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 3 || string(restored.Data) != string(first) {
+		t.Fatalf("Unexpected restored secret %#v", restored)
+	}
+}
+
+func TestSecretTrash(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	path := fmt.Sprintf("/team/%s/vault/%s", team.Id, v.Id)
+	r, err := PostRequest(path+"/secret", &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = DeleteRequest(path + "/secret/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path + "/trash")
+	CheckErrorAndResponse(t, r, err, 200)
+	sl := &teamSecretListWrap{}
+	if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+		t.Fatal(err)
+	}
+	if len(sl.Secrets) != 1 || sl.Secrets[0].Id != s.Id {
+		t.Fatalf("Unexpected trash %#v", sl.Secrets)
+	}
+	r, err = PostRequest(path+"/trash/"+s.Id+"/restore", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(path + "/secret")
+	CheckErrorAndResponse(t, r, err, 200)
+	sl = &teamSecretListWrap{}
+	if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, ls := range sl.Secrets {
+		found = found || ls.Id == s.Id
+	}
+	if !found {
+		t.Fatal("Restored secret is not listed")
+	}
+	r, err = DeleteRequest(path + "/trash/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = DeleteRequest(path + "/secret/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = DeleteRequest(path + "/trash/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PostRequest(path+"/trash/"+s.Id+"/restore", nil)
+	CheckErrorAndResponse(t, r, err, 404)
+}
+
+func TestGetSecretsSince(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	path := fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id)
+	r, err := PostRequest(path, &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(fmt.Sprintf("%s?since=%d", path, s.VaultVersion))
+	CheckErrorAndResponse(t, r, err, 200)
+	sl := &teamSecretListWrap{}
+	if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+		t.Fatal(err)
+	}
+	if len(sl.Secrets) != 0 {
+		t.Fatalf("Expected no changes and got %d", len(sl.Secrets))
+	}
+	r, err = DeleteRequest(path + "/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest(fmt.Sprintf("%s?since=%d", path, s.VaultVersion))
+	CheckErrorAndResponse(t, r, err, 200)
+	sl = &teamSecretListWrap{}
+	if err := json.NewDecoder(r.Body).Decode(sl); err != nil {
+		t.Fatal(err)
+	}
+	if len(sl.Secrets) != 1 || sl.Secrets[0].Id != s.Id || !sl.Secrets[0].DeletedAt.Valid {
+		t.Fatalf("Expected the tombstone of %s and got %#v", s.Id, sl.Secrets)
+	}
+	if err = v.PurgeSecret(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(fmt.Sprintf("%s?since=%d", path, s.VaultVersion))
+	CheckErrorAndResponse(t, r, err, 410)
+	r, err = GetRequest(path + "?since=nope")
+	CheckErrorAndResponse(t, r, err, 400)
+}
+
+func TestSecretVersionConflict(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	path := fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Id)
+	r, err := PostRequest(path, &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	current := signAndPack(vPriv, a32b)
+	r, err = PatchRequest(path+"/"+s.Id, &vaultCreateSecretRequest{Data: current, Version: 1})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = PatchRequest(path+"/"+s.Id, &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b), Version: 1})
+	CheckErrorAndResponse(t, r, err, 409)
+	scr := &vaultSecretConflictResponse{}
+	if err := json.NewDecoder(r.Body).Decode(scr); err != nil {
+		t.Fatal(err)
+	}
+	if scr.Secret == nil || scr.Secret.Version != 2 || string(scr.Secret.Data) != string(current) {
+		t.Fatalf("Unexpected conflict response %#v", scr)
+	}
+	req, err := http.NewRequest("DELETE", srv.URL+path+"/"+s.Id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"1"`)
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 409)
+	req.Header.Set("If-Match", `"2"`)
+	r, err = httpDo(req)
+	CheckErrorAndResponse(t, r, err, 200)
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
@@ -12,8 +13,15 @@ func (ah apiHandler) sessionRoot(w http.ResponseWriter, r *http.Request) error {
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
 	if len(head) == 0 {
-		//TODO: list all sessions
-		return util.NewErrorFrom(ErrNotFound)
+		switch r.Method {
+		case "GET":
+			return ah.sessionList(w, r)
+		}
+	} else if head == "others" {
+		switch r.Method {
+		case "DELETE":
+			return ah.sessionDeleteOthers(w, r)
+		}
 	} else {
 		switch r.Method {
 		case "GET":
@@ -25,6 +33,32 @@ func (ah apiHandler) sessionRoot(w http.ResponseWriter, r *http.Request) error {
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+type sessionListEntry struct {
+	Id         string    `json:"id"`
+	Agent      string    `json:"agent"`
+	LastIp     string    `json:"last_ip"`
+	LastAccess time.Time `json:"last_access"`
+	Current    bool      `json:"current"`
+}
+
+type sessionListResponse struct {
+	Sessions []sessionListEntry `json:"sessions"`
+}
+
+// GET /session
+func (ah apiHandler) sessionList(w http.ResponseWriter, r *http.Request) error {
+	currentSession := ctxGetSession(r.Context())
+	ss, err := ah.sm.GetAllSessions(currentSession.User)
+	if err != nil {
+		return err
+	}
+	slr := sessionListResponse{make([]sessionListEntry, len(ss))}
+	for i, s := range ss {
+		slr.Sessions[i] = sessionListEntry{s.PublicId(), s.Agent, s.LastIp, s.LastAccess, s.Id == currentSession.Id}
+	}
+	return jsonResponse(w, slr)
+}
+
 type sessionGetTokenResponse struct {
 	*managers.Session
 	StoreToken string `json:"store_token,omitempty"`
@@ -47,15 +81,53 @@ func (ah apiHandler) sessionGetToken(w http.ResponseWriter, r *http.Request, tid
 	return jsonResponse(w, s)
 }
 
+// Finds a session of the current user either by its token or by the id returned when listing sessions
+func (ah apiHandler) findUserSession(currentUser *models.User, tid string) (*managers.Session, error) {
+	if s, err := ah.sm.GetSession(tid); err == nil {
+		if s.User != currentUser.Id {
+			return nil, util.NewErrorFrom(models.ErrDoesntExist)
+		}
+		return s, nil
+	}
+	ss, err := ah.sm.GetAllSessions(currentUser.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range ss {
+		if s.PublicId() == tid {
+			return s, nil
+		}
+	}
+	return nil, util.NewErrorFrom(models.ErrDoesntExist)
+}
+
 // DELETE /session/:token
 func (ah apiHandler) sessionDeleteToken(w http.ResponseWriter, r *http.Request, tid string) error {
-	if len(tid) == 0 {
-		currentSession := ctxGetSession(r.Context())
-		tid = currentSession.Id
+	s, err := ah.findUserSession(ctxGetUser(r.Context()), tid)
+	if err != nil {
+		return err
 	}
-	if err := ah.sm.DeleteSession(tid); err != nil {
+	if err := ah.sm.DeleteSession(s.Id); err != nil {
 		return err
 	}
 	w.WriteHeader(http.StatusOK)
 	return nil
 }
+
+// DELETE /session/others
+func (ah apiHandler) sessionDeleteOthers(w http.ResponseWriter, r *http.Request) error {
+	currentSession := ctxGetSession(r.Context())
+	ss, err := ah.sm.GetAllSessions(currentSession.User)
+	if err != nil {
+		return err
+	}
+	for _, s := range ss {
+		if s.Id == currentSession.Id {
+			continue
+		}
+		if err := ah.sm.DeleteSession(s.Id); err != nil {
+			return err
+		}
+	}
+	return ah.sessionList(w, r)
+}
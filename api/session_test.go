@@ -1,7 +1,9 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -34,3 +36,72 @@ func TestGetAndDeleteSessions(t *testing.T) {
 	r, err = GetRequest("/session/" + s.Id)
 	CheckErrorAndResponse(t, r, err, 404)
 }
+
+func TestListAndRevokeOtherSessions(t *testing.T) {
+	u := loginDummyUser()
+	s, err := apiH.sm.NewSession(u.Id, "1.1.1.2", "other", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := getDummyUser()
+	otherSession, err := apiH.sm.NewSession(other.Id, "1.1.1.3", "none", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := DeleteRequest("/session/" + otherSession.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = GetRequest("/session")
+	CheckErrorAndResponse(t, r, err, 200)
+	slr := &sessionListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(slr); err != nil {
+		t.Fatal(err)
+	}
+	if len(slr.Sessions) != 2 {
+		t.Fatalf("Expected 2 sessions and got %d", len(slr.Sessions))
+	}
+	for _, se := range slr.Sessions {
+		if se.Id == s.Id || se.Id == activeSessionToken {
+			t.Fatalf("Session list leaks the session token")
+		}
+		if se.Current != (se.Agent != "other") {
+			t.Errorf("Wrong current session flag for %#v", se)
+		}
+	}
+	r, err = DeleteRequest("/session/others")
+	CheckErrorAndResponse(t, r, err, 200)
+	slr = &sessionListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(slr); err != nil {
+		t.Fatal(err)
+	}
+	if len(slr.Sessions) != 1 || !slr.Sessions[0].Current {
+		t.Fatalf("Expected only the current session and got %#v", slr.Sessions)
+	}
+	if _, err = apiH.sm.GetSession(s.Id); err == nil {
+		t.Fatalf("Other session was not deleted")
+	}
+	if _, err = apiH.sm.GetSession(otherSession.Id); err != nil {
+		t.Fatalf("Session from another user was deleted: %s", err)
+	}
+}
+
+func TestRejectedSessionBody(t *testing.T) {
+	loginDummyUser()
+	if err := apiH.sm.DeleteSession(activeSessionToken); err != nil {
+		t.Fatal(err)
+	}
+	r, err := GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 401)
+	body := &bytes.Buffer{}
+	body.ReadFrom(r.Body)
+	if strings.TrimSpace(body.String()) != sessionExpiredMsg {
+		t.Fatalf("Unexpected body for a rejected session: %s", body)
+	}
+	activeSessionToken = ""
+	r, err = GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 401)
+	body.Reset()
+	body.ReadFrom(r.Body)
+	if strings.TrimSpace(body.String()) != invalidAuthorizationMsg {
+		t.Fatalf("Unexpected body without a session: %s", body)
+	}
+}
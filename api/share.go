@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+	"github.com/tomasen/realip"
+)
+
+// /share/:id
+// Opening a share does not need an account. The id is all it takes so requests are rate limited per ip.
+func (ah apiHandler) shareRoot(w http.ResponseWriter, r *http.Request) error {
+	var sid string
+	sid, r.URL.Path = shiftPath(r.URL.Path)
+	if len(sid) == 0 || len(r.URL.Path) > 1 || (r.Method != "GET" && r.Method != "POST") {
+		return util.NewErrorFrom(ErrNotFound)
+	}
+	if err := ah.checkRateLimit(w, "share", realip.FromRequest(r), ah.options.rateLimit.PerIp); err != nil {
+		return err
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	if r.Method == "GET" {
+		return ah.shareInfo(w, r, sid)
+	}
+	return ah.shareOpen(w, r, sid)
+}
+
+type shareInfoResponse struct {
+	ViewsLeft int       `json:"views_left"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// GET /share/:id
+// Only tells whether the share can still be opened. Link previews and prefetchers do not burn a view.
+func (ah apiHandler) shareInfo(w http.ResponseWriter, r *http.Request, sid string) error {
+	s, err := models.FindShare(r.Context(), sid)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, shareInfoResponse{s.MaxViews - s.Views, s.ExpiresAt})
+}
+
+type shareOpenResponse struct {
+	Data      []byte    `json:"data"`
+	ViewsLeft int       `json:"views_left"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// POST /share/:id
+// Every request counts as a view. The share is gone once the last view has been served.
+func (ah apiHandler) shareOpen(w http.ResponseWriter, r *http.Request, sid string) error {
+	s, err := models.OpenShare(r.Context(), sid)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, shareOpenResponse{s.Data, s.MaxViews - s.Views, s.ExpiresAt})
+}
+
+// /team/:tid/share
+func (ah apiHandler) teamShareRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var sid string
+	sid, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(sid) == 0 && r.Method == "GET":
+		return ah.teamShareList(w, r, t)
+	case len(sid) == 0 && r.Method == "POST":
+		return ah.teamShareCreate(w, r, t)
+	case len(sid) > 0 && r.Method == "DELETE":
+		return ah.teamShareRevoke(w, r, t, sid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type teamShareListResponse struct {
+	Shares []*models.Share `json:"shares"`
+}
+
+// GET /team/:tid/share
+func (ah apiHandler) teamShareList(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ctx := r.Context()
+	shares, err := t.GetShares(ctx, ctxGetUser(ctx))
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, teamShareListResponse{shares})
+}
+
+type teamShareCreateRequest struct {
+	Data      []byte    `json:"data"`
+	MaxViews  int       `json:"max_views"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// POST /team/:tid/share
+// The data has to come encrypted with a key the server never gets. Clients put it in the fragment of the link.
+func (ah apiHandler) teamShareCreate(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	tscr := &teamShareCreateRequest{}
+	if err := jsonDecode(w, r, 2*models.MAX_SHARE_DATA_LENGTH, tscr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	s, err := t.CreateShare(ctx, ctxGetUser(ctx), tscr.Data, tscr.MaxViews, tscr.ExpiresAt)
+	if err != nil {
+		return ah.notifyPlanLimit(r, t, err)
+	}
+	return jsonResponse(w, s)
+}
+
+// DELETE /team/:tid/share/:sid
+func (ah apiHandler) teamShareRevoke(w http.ResponseWriter, r *http.Request, t *models.Team, sid string) error {
+	ctx := r.Context()
+	if err := t.RevokeShare(ctx, ctxGetUser(ctx), sid); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
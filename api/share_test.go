@@ -0,0 +1,70 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestShareOpenAndRevoke(t *testing.T) {
+	u := loginDummyUser()
+	teams, err := u.GetTeams(getCtx())
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	path := fmt.Sprintf("/team/%s/share", team.Id)
+	r, err := PostRequest(path, teamShareCreateRequest{Data: a32b, MaxViews: 1})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest(path, teamShareCreateRequest{Data: a32b, MaxViews: 1, ExpiresAt: time.Now().Add(time.Hour)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Share{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = GetRequest(path)
+	CheckErrorAndResponse(t, r, err, 200)
+	tslr := &teamShareListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tslr); err != nil {
+		t.Fatal(err)
+	}
+	if len(tslr.Shares) != 1 || tslr.Shares[0].Id != s.Id {
+		t.Fatalf("Unexpected shares %#v", tslr.Shares)
+	}
+	for i := 0; i < 2; i++ {
+		r, err = GetRequest("/share/" + s.Id)
+		CheckErrorAndResponse(t, r, err, 200)
+		sir := &shareInfoResponse{}
+		if err := json.NewDecoder(r.Body).Decode(sir); err != nil {
+			t.Fatal(err)
+		}
+		if sir.ViewsLeft != 1 {
+			t.Fatalf("Getting the share counted a view %#v", sir)
+		}
+	}
+	r, err = PostRequest("/share/"+s.Id, nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	sor := &shareOpenResponse{}
+	if err := json.NewDecoder(r.Body).Decode(sor); err != nil {
+		t.Fatal(err)
+	}
+	if string(sor.Data) != string(a32b) || sor.ViewsLeft != 0 {
+		t.Fatalf("Unexpected share %#v", sor)
+	}
+	r, err = GetRequest("/share/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest("/share/"+s.Id, nil)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest(path, teamShareCreateRequest{Data: a32b, MaxViews: 5, ExpiresAt: time.Now().Add(time.Hour)})
+	CheckErrorAndResponse(t, r, err, 200)
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	r, err = DeleteRequest(path + "/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/share/" + s.Id)
+	CheckErrorAndResponse(t, r, err, 404)
+}
@@ -1,8 +1,10 @@
 package api
 
 import (
+	"log"
 	"net/http"
 
+	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
 )
@@ -20,8 +22,16 @@ func (ah apiHandler) teamRoot(w http.ResponseWriter, r *http.Request) error {
 			return util.NewErrorFrom(ErrNotFound)
 		}
 	} else {
+		if at := ctxGetAccessToken(r.Context()); at != nil && !at.AllowsAnyInTeam(tid) {
+			return util.NewErrorFrom(models.ErrDoesntExist)
+		}
 		u := ctxGetUser(r.Context())
 		t, err := u.GetTeam(r.Context(), tid)
+		if util.CheckErr(err, models.ErrDoesntExist) && ah.isServerAdmin(r) {
+			if head, _ := shiftPath(r.URL.Path); head == "owner" {
+				t, err = models.FindTeam(r.Context(), tid)
+			}
+		}
 		if err != nil {
 			return err
 		}
@@ -41,6 +51,15 @@ func (ah apiHandler) teamGetAll(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if at := ctxGetAccessToken(ctx); at != nil {
+		inScope := []*models.Team{}
+		for _, t := range teams {
+			if at.AllowsAnyInTeam(t.Id) {
+				inScope = append(inScope, t)
+			}
+		}
+		teams = inScope
+	}
 	return jsonResponse(w, teamGetAllResponse{teams})
 }
 
@@ -71,10 +90,21 @@ func (ah apiHandler) teamCreate(w http.ResponseWriter, r *http.Request) error {
 func (ah apiHandler) validTeamRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
+	if at := ctxGetAccessToken(r.Context()); at != nil && head != "vault" {
+		//Access tokens can only read the team and its secrets. Managing the team needs a session
+		readsTeam := r.Method == "GET" && (len(head) == 0 || head == "secret")
+		if !readsTeam || !at.Allows(t.Id, "") {
+			return util.NewErrorFrom(models.ErrUnauthorized)
+		}
+	}
 	if len(head) == 0 {
 		switch r.Method {
 		case "GET":
 			return ah.teamGetInfo(w, r, t)
+		case "PATCH":
+			return ah.teamRename(w, r, t)
+		case "DELETE":
+			return ah.teamDelete(w, r, t)
 		default:
 			return util.NewErrorFrom(ErrNotFound)
 		}
@@ -86,6 +116,14 @@ func (ah apiHandler) validTeamRoot(w http.ResponseWriter, r *http.Request, t *mo
 			return ah.vaultRoot(w, r, t)
 		case "secret":
 			return ah.teamSecretRoot(w, r, t)
+		case "owner":
+			if r.Method == "POST" {
+				return ah.teamTransferOwnership(w, r, t)
+			}
+		case "access":
+			return ah.teamAccessRoot(w, r, t)
+		case "share":
+			return ah.teamShareRoot(w, r, t)
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
@@ -102,6 +140,84 @@ func (ah apiHandler) teamGetInfo(w http.ResponseWriter, r *http.Request, t *mode
 	return jsonResponse(w, tf)
 }
 
+type teamRenameRequest struct {
+	Name string `json:"name"`
+}
+
+// PATCH /team/:tid
+func (ah apiHandler) teamRename(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	trr := &teamRenameRequest{}
+	if err := jsonDecode(w, r, 1024, trr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := t.Rename(ctx, u, trr.Name); err != nil {
+		return err
+	}
+	tf, err := t.GetTeamFull(ctx, u)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, tf)
+}
+
+// Lets the admins of the team know when one of its limits stopped a request. A failure to notify them
+// never hides the limit error from the client.
+func (ah apiHandler) notifyPlanLimit(r *http.Request, t *models.Team, err error) error {
+	if !util.CheckErr(err, models.ErrPlanLimitReached) {
+		return err
+	}
+	limit := models.ReachedPlanLimit(err)
+	notify, nerr := t.MarkPlanLimitNotified(r.Context(), limit)
+	if nerr != nil {
+		log.Printf("Could not record the plan limit notice for team %s: %s", t.Id, nerr)
+	}
+	if !notify {
+		return err
+	}
+	admins, aerr := t.GetAdminUsers(r.Context())
+	if aerr != nil {
+		ah.clearPlanLimitNotice(r, t, limit)
+		return aerr
+	}
+	sent := true
+	for _, admin := range admins {
+		if merr := ah.mail.sendPlanLimitReachedMail(t, admin, limit, r.Header.Get("X-Locale")); merr != nil {
+			log.Printf("Could not send the plan limit mail for team %s to %s: %s", t.Id, admin.Id, merr)
+			sent = false
+		}
+	}
+	if !sent {
+		//Let the next request that hits the limit try again
+		ah.clearPlanLimitNotice(r, t, limit)
+	}
+	return err
+}
+
+func (ah apiHandler) clearPlanLimitNotice(r *http.Request, t *models.Team, limit string) {
+	if err := t.ClearPlanLimitNotice(r.Context(), limit); err != nil {
+		log.Printf("Could not clear the plan limit notice for team %s: %s", t.Id, err)
+	}
+}
+
+// DELETE /team/:tid
+func (ah apiHandler) teamDelete(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := t.Delete(ctx, u); err != nil {
+		return err
+	}
+	ah.bcast.Send(t.Id, "", managers.BCAST_ACTION_TEAM_REMOVE, nil)
+	return ah.teamGetAll(w, r)
+}
+
 func (ah apiHandler) validTeamUserRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
@@ -114,6 +230,8 @@ func (ah apiHandler) validTeamUserRoot(w http.ResponseWriter, r *http.Request, t
 		switch r.Method {
 		case "PATCH":
 			return ah.teamModifyUser(w, r, t, head)
+		case "DELETE":
+			return ah.teamRemoveUser(w, r, t, head)
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
@@ -127,13 +245,16 @@ type teamInviteUserRequest struct {
 func (ah apiHandler) teamInviteUser(w http.ResponseWriter, r *http.Request, t *models.Team) error {
 	ctx := r.Context()
 	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
 	tcr := &teamInviteUserRequest{}
 	if err := jsonDecode(w, r, 1024, tcr); err != nil {
 		return err
 	}
 	invite, err := t.AddOrInviteUserByEmail(ctx, u, tcr.Invite)
 	if err != nil && !util.CheckErr(err, models.ErrAlreadyInvited) {
-		return err
+		return ah.notifyPlanLimit(r, t, err)
 	}
 	if invite != nil {
 		if err := ah.mail.sendInvitationMail(t, u, invite, r.Header.Get("X-Locale")); err != nil {
@@ -165,6 +286,9 @@ func (ah apiHandler) teamModifyUser(w http.ResponseWriter, r *http.Request, t *m
 	}
 	ctx := r.Context()
 	admin := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
 	u, err := models.FindUser(ctx, uid)
 	if err != nil {
 		return err
@@ -183,3 +307,112 @@ func (ah apiHandler) teamModifyUser(w http.ResponseWriter, r *http.Request, t *m
 	}
 	return jsonResponse(w, teamModifyUserResponse{t.Id, tuf})
 }
+
+type teamRemoveUserResponse struct {
+	Team         string          `json:"team"`
+	RotateVaults []*models.Vault `json:"rotate_vaults"`
+}
+
+// DELETE /team/:tid/user/:uid
+func (ah apiHandler) teamRemoveUser(w http.ResponseWriter, r *http.Request, t *models.Team, uid string) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	rotate, err := t.RemoveUser(ctx, u, uid)
+	if err != nil {
+		return err
+	}
+	ah.bcast.SendTeamUser(t.Id, uid, managers.BCAST_ACTION_TEAM_USER_DEL)
+	return jsonResponse(w, teamRemoveUserResponse{t.Id, rotate})
+}
+
+type teamTransferOwnershipRequest struct {
+	Owner string `json:"owner"`
+}
+
+// POST /team/:tid/owner
+func (ah apiHandler) teamTransferOwnership(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	ttor := &teamTransferOwnershipRequest{}
+	if err := jsonDecode(w, r, 1024, ttor); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	newOwner, err := models.FindUser(ctx, ttor.Owner)
+	if err != nil {
+		return err
+	}
+	if err := t.TransferOwnership(ctx, u, newOwner, ah.isServerAdmin(r)); err != nil {
+		return err
+	}
+	tf, err := t.GetTeamFull(ctx, u)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, tf)
+}
+
+// /team/:tid/access
+func (ah apiHandler) teamAccessRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	var vid, uid string
+	vid, r.URL.Path = shiftPath(r.URL.Path)
+	uid, r.URL.Path = shiftPath(r.URL.Path)
+	switch {
+	case len(vid) == 0 && r.Method == "POST":
+		return ah.teamRequestVaultAccess(w, r, t)
+	case len(vid) > 0 && len(uid) > 0 && r.Method == "DELETE":
+		return ah.teamDenyVaultAccess(w, r, t, vid, uid)
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type teamRequestVaultAccessRequest struct {
+	Vault string `json:"vault"`
+}
+
+// POST /team/:tid/access
+func (ah apiHandler) teamRequestVaultAccess(w http.ResponseWriter, r *http.Request, t *models.Team) error {
+	trvar := &teamRequestVaultAccessRequest{}
+	if err := jsonDecode(w, r, 1024, trvar); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	ar, err := t.RequestVaultAccess(ctx, u, trvar.Vault)
+	if err != nil {
+		return err
+	}
+	admins, err := t.GetAdminUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		if err := ah.mail.sendVaultAccessRequestMail(t, u, admin, r.Header.Get("X-Locale")); err != nil {
+			panic(err)
+		}
+	}
+	return jsonResponse(w, ar)
+}
+
+// DELETE /team/:tid/access/:vid/:uid
+func (ah apiHandler) teamDenyVaultAccess(w http.ResponseWriter, r *http.Request, t *models.Team, vid, uid string) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := t.DenyVaultAccess(ctx, u, vid, uid); err != nil {
+		return err
+	}
+	ah.bcast.SendVaultUser(t.Id, vid, uid, managers.BCAST_ACTION_VAULT_ACCESS_DENIED)
+	tf, err := t.GetTeamFull(ctx, u)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, tf)
+}
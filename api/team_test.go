@@ -1,9 +1,12 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
+	"fmt"
 	"testing"
 
+	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
 )
@@ -43,3 +46,274 @@ func TestGetAllTeams(t *testing.T) {
 		t.Fatalf("Unexpected number of teams: %d vs %d", len(teams)+1, len(sga.Teams))
 	}
 }
+
+func TestTransferTeamOwnership(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := getDummyUser()
+	if _, err = team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	vaultsFull, err := team.GetVaultsFullForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string][]byte{}
+	for _, v := range vaultsFull {
+		keys[v.Id] = v.Key
+	}
+	if err = team.PromoteUser(ctx, owner, member, models.VaultKeyPair{Keys: keys}); err != nil {
+		t.Fatal(err)
+	}
+	admin := loginDummyUser()
+	r, err := PostRequest("/team/"+team.Id+"/owner", teamTransferOwnershipRequest{member.Id})
+	CheckErrorAndResponse(t, r, err, 404)
+	apiH.options.admins[admin.Id] = true
+	defer delete(apiH.options.admins, admin.Id)
+	r, err = PostRequest("/team/"+team.Id+"/info", nil)
+	CheckErrorAndResponse(t, r, err, 404)
+	r, err = PostRequest("/team/"+team.Id+"/owner", teamTransferOwnershipRequest{member.Id})
+	CheckErrorAndResponse(t, r, err, 200)
+	tf := &models.TeamFull{}
+	if err := json.NewDecoder(r.Body).Decode(tf); err != nil {
+		t.Fatal(err)
+	}
+	if tf.Owner != member.Id {
+		t.Fatalf("Expected %s to own the team and got %s", member.Id, tf.Owner)
+	}
+}
+
+func TestRemoveUserFromTeam(t *testing.T) {
+	ctx := getCtx()
+	owner := loginDummyUser()
+	ownerToken := activeSessionToken
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := getDummyUser()
+	if _, err = team.AddOrInviteUserByEmail(ctx, owner, admin.Email); err != nil {
+		t.Fatal(err)
+	}
+	vaultsFull, err := team.GetVaultsFullForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = team.PromoteUser(ctx, owner, admin, expandVaultKeysOnce(vaultsFull)); err != nil {
+		t.Fatal(err)
+	}
+	member := loginDummyUser()
+	if _, err = team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	r, err := DeleteRequest(fmt.Sprintf("/team/%s/user/%s", team.Id, admin.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	resp, err := EventRequest("/eventsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := bufio.NewReader(resp.Body)
+	if bp := getEvent(t, source); bp.Action != managers.BCAST_ACTION_VAULT_VERSION {
+		t.Fatalf("Unexpected action: %s", bp.Action)
+	}
+	activeSessionToken = ownerToken
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/user/%s", team.Id, owner.Id))
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/user/%s", team.Id, admin.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	trr := &teamRemoveUserResponse{}
+	if err := json.NewDecoder(r.Body).Decode(trr); err != nil {
+		t.Fatal(err)
+	}
+	if len(trr.RotateVaults) != len(vaultsFull) {
+		t.Fatalf("Expected %d vaults to rotate and got %d", len(vaultsFull), len(trr.RotateVaults))
+	}
+	bp := getEvent(t, source)
+	if bp.Action != managers.BCAST_ACTION_TEAM_USER_DEL || bp.Team != team.Id || bp.User != admin.Id {
+		t.Fatalf("Unexpected broadcast %#v", bp)
+	}
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/user/%s", team.Id, member.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	bp = getEvent(t, source)
+	if bp.Action != managers.BCAST_ACTION_TEAM_USER_DEL || bp.User != member.Id {
+		t.Fatalf("Unexpected broadcast %#v", bp)
+	}
+	resp.Body.Close()
+	if _, err = member.GetTeam(ctx, team.Id); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("The member is still in the team: %s", err)
+	}
+}
+
+func TestDeleteTeamAndVault(t *testing.T) {
+	ctx := getCtx()
+	owner := loginDummyUser()
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := team.GetVaultsForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := DeleteRequest(fmt.Sprintf("/team/%s/vault/%s", team.Id, vs[0].Id))
+	CheckErrorAndResponse(t, r, err, 400)
+	extra, err := team.CreateVault(ctx, owner, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := EventRequest("/eventsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	source := bufio.NewReader(resp.Body)
+	if bp := getEvent(t, source); bp.Action != managers.BCAST_ACTION_VAULT_VERSION {
+		t.Fatalf("Unexpected action: %s", bp.Action)
+	}
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/vault/%s", team.Id, extra.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	vlr := &vaultListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vlr); err != nil {
+		t.Fatal(err)
+	}
+	if len(vlr.Vaults) != 1 {
+		t.Fatalf("Expected 1 vault and got %d", len(vlr.Vaults))
+	}
+	bp := getEvent(t, source)
+	if bp.Action != managers.BCAST_ACTION_VAULT_REMOVE || bp.Team != team.Id || bp.Vault != extra.Id {
+		t.Fatalf("Unexpected broadcast %#v", bp)
+	}
+	teams, err := owner.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pt := range teams {
+		if pt.Primary {
+			r, err = DeleteRequest(fmt.Sprintf("/team/%s", pt.Id))
+			CheckErrorAndResponse(t, r, err, 401)
+		}
+	}
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	bp = getEvent(t, source)
+	if bp.Action != managers.BCAST_ACTION_TEAM_REMOVE || bp.Team != team.Id {
+		t.Fatalf("Unexpected broadcast %#v", bp)
+	}
+	if _, err = models.FindTeam(ctx, team.Id); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("The team still exists: %s", err)
+	}
+}
+
+func TestRenameTeamAndVault(t *testing.T) {
+	ctx := getCtx()
+	owner := loginDummyUser()
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := team.GetVaultsForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := PatchRequest(fmt.Sprintf("/team/%s/vault/%s", team.Id, vs[0].Id), vaultRenameRequest{"Work"})
+	CheckErrorAndResponse(t, r, err, 200)
+	vf := &models.VaultFull{}
+	if err := json.NewDecoder(r.Body).Decode(vf); err != nil {
+		t.Fatal(err)
+	}
+	if vf.Id != vs[0].Id || vf.Name != "Work" {
+		t.Fatalf("Unexpected vault %s with name %s", vf.Id, vf.Name)
+	}
+	r, err = PatchRequest(fmt.Sprintf("/team/%s", team.Id), teamRenameRequest{""})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PatchRequest(fmt.Sprintf("/team/%s", team.Id), teamRenameRequest{"Renamed"})
+	CheckErrorAndResponse(t, r, err, 200)
+	tf := &models.TeamFull{}
+	if err := json.NewDecoder(r.Body).Decode(tf); err != nil {
+		t.Fatal(err)
+	}
+	if tf.Name != "Renamed" {
+		t.Fatalf("Expected team name Renamed and got %s", tf.Name)
+	}
+}
+
+func TestVaultAccessRequest(t *testing.T) {
+	ctx := getCtx()
+	owner := loginDummyUser()
+	ownerToken := activeSessionToken
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs, err := team.GetVaultsFullForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	member := loginDummyUser()
+	if _, err = team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := EventRequest("/eventsource")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	source := bufio.NewReader(resp.Body)
+	if bp := getEvent(t, source); bp.Action != managers.BCAST_ACTION_VAULT_VERSION {
+		t.Fatalf("Unexpected action: %s", bp.Action)
+	}
+	r, err := PostRequest(fmt.Sprintf("/team/%s/access", team.Id), teamRequestVaultAccessRequest{v.Id})
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ownerToken
+	r, err = GetRequest(fmt.Sprintf("/team/%s", team.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	tf := &models.TeamFull{}
+	if err := json.NewDecoder(r.Body).Decode(tf); err != nil {
+		t.Fatal(err)
+	}
+	if len(tf.AccessRequests) != 1 || tf.AccessRequests[0].User != member.Id || tf.AccessRequests[0].Vault != v.Id {
+		t.Fatalf("Unexpected access requests %#v", tf.AccessRequests)
+	}
+	r, err = DeleteRequest(fmt.Sprintf("/team/%s/access/%s/%s", team.Id, v.Id, member.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	bp := getEvent(t, source)
+	if bp.Action != managers.BCAST_ACTION_VAULT_ACCESS_DENIED || bp.Vault != v.Id || bp.User != member.Id {
+		t.Fatalf("Unexpected broadcast %#v", bp)
+	}
+	if _, err = team.RequestVaultAccess(ctx, member, v.Id); err != nil {
+		t.Fatal(err)
+	}
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault/%s/user", team.Id, v.Id), map[string][]byte{member.Id: v.Key})
+	CheckErrorAndResponse(t, r, err, 200)
+	bp = getEvent(t, source)
+	if bp.Action != managers.BCAST_ACTION_VAULT_ACCESS_GRANTED || bp.Vault != v.Id || bp.User != member.Id {
+		t.Fatalf("Unexpected broadcast %#v", bp)
+	}
+}
+
+func TestTeamLimits(t *testing.T) {
+	ctx := getCtx()
+	owner := loginDummyUser()
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	team, err := owner.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, owner.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	models.TEAM_MAX_VAULTS = 1
+	defer func() { models.TEAM_MAX_VAULTS = 0 }()
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault", team.Id), vaultCreateRequest{"extra", getDummyVaultKeyPair(privKeys, owner.Id)})
+	CheckErrorAndResponse(t, r, err, 403)
+	models.TEAM_MAX_VAULTS = 0
+	r, err = PostRequest(fmt.Sprintf("/team/%s/vault", team.Id), vaultCreateRequest{"extra", getDummyVaultKeyPair(privKeys, owner.Id)})
+	CheckErrorAndResponse(t, r, err, 200)
+}
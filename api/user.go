@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
 )
 
@@ -15,6 +16,19 @@ func (ah apiHandler) userRoot(w http.ResponseWriter, r *http.Request) error {
 			return ah.userGetInfo(w, r)
 		case "PUT", "PATCH":
 			return ah.userUpdate(w, r)
+		case "DELETE":
+			return ah.userDelete(w, r)
+		}
+	} else {
+		switch head {
+		case "2fa":
+			return ah.userTOTPRoot(w, r)
+		case "webauthn":
+			return ah.userWebauthnRoot(w, r)
+		case "tokens":
+			return ah.userAccessTokenRoot(w, r)
+		case "oidc":
+			return ah.userOidcRoot(w, r)
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
@@ -65,3 +79,129 @@ func (ah apiHandler) userUpdate(w http.ResponseWriter, r *http.Request) error {
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
+
+type userDeleteRequest struct {
+	Password string `json:"password"`
+}
+
+type userDeleteConflictResponse struct {
+	Error string         `json:"error"`
+	Teams []*models.Team `json:"teams"`
+}
+
+// DELETE /user
+func (ah apiHandler) userDelete(w http.ResponseWriter, r *http.Request) error {
+	udr := &userDeleteRequest{}
+	if err := jsonDecode(w, r, 1024, udr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	shared, unlock, err := u.Delete(ctx, udr.Password)
+	if unlock != nil {
+		if err := ah.mail.sendUnlockMail(u, unlock, r.Header.Get("X-Locale")); err != nil {
+			panic(err)
+		}
+	}
+	if util.CheckErr(err, models.ErrOwnsSharedTeams) {
+		return jsonResponseWithCode(w, http.StatusConflict, userDeleteConflictResponse{"transfer_ownership_required", shared})
+	} else if err != nil {
+		return err
+	}
+	if err := ah.sm.DeleteAllSessions(u.Id); err != nil {
+		return err
+	}
+	if err := ah.mail.sendAccountDeletedMail(u, r.Header.Get("X-Locale")); err != nil {
+		panic(err)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// /user/2fa
+func (ah apiHandler) userTOTPRoot(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.userTOTPGetStatus(w, r)
+		case "POST":
+			return ah.userTOTPGenerate(w, r)
+		case "DELETE":
+			return ah.userTOTPDisable(w, r)
+		}
+	} else {
+		switch head {
+		case "confirm":
+			if r.Method == "POST" {
+				return ah.userTOTPConfirm(w, r)
+			}
+		}
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type userTOTPStatusResponse struct {
+	Enabled bool     `json:"enabled"`
+	Methods []string `json:"methods"`
+}
+
+// GET /user/2fa
+func (ah apiHandler) userTOTPGetStatus(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	methods, err := u.SecondFactorMethods(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userTOTPStatusResponse{u.TotpEnabled, methods})
+}
+
+type userTOTPGenerateResponse struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+// POST /user/2fa
+func (ah apiHandler) userTOTPGenerate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	secret, err := u.GenerateTOTPSecret(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userTOTPGenerateResponse{util.EncodeTOTPSecret(secret), util.TOTPKeyURI("Key.cat", u.Id, secret)})
+}
+
+type userTOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// POST /user/2fa/confirm
+func (ah apiHandler) userTOTPConfirm(w http.ResponseWriter, r *http.Request) error {
+	utcr := &userTOTPCodeRequest{}
+	if err := jsonDecode(w, r, 1024, utcr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := u.EnableTOTP(ctx, utcr.Code); err != nil {
+		return err
+	}
+	return ah.userTOTPGetStatus(w, r)
+}
+
+// DELETE /user/2fa
+func (ah apiHandler) userTOTPDisable(w http.ResponseWriter, r *http.Request) error {
+	utcr := &userTOTPCodeRequest{}
+	if err := jsonDecode(w, r, 1024, utcr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := u.DisableTOTP(ctx, utcr.Code); err != nil {
+		return err
+	}
+	return ah.userTOTPGetStatus(w, r)
+}
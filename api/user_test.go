@@ -1,10 +1,13 @@
 package api
 
 import (
+	"encoding/base32"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
 )
 
 func TestGetUserInfo(t *testing.T) {
@@ -19,3 +22,78 @@ func TestGetUserInfo(t *testing.T) {
 		t.Errorf("Mismatch in the user. Expected %s and got %s", uf.Id, u.Id)
 	}
 }
+
+func TestTOTPLogin(t *testing.T) {
+	u := loginDummyUser()
+	r, err := PostRequest("/user/2fa", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	tgr := &userTOTPGenerateResponse{}
+	if err := json.NewDecoder(r.Body).Decode(tgr); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(tgr.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	r, err = PostRequest("/user/2fa/confirm", userTOTPCodeRequest{util.TOTPCode(secret, now.Add(-util.TOTP_PERIOD*time.Second))})
+	CheckErrorAndResponse(t, r, err, 200)
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id})
+	CheckErrorAndResponse(t, r, err, 401)
+	lcr := &authLoginChallengeResponse{}
+	if err := json.NewDecoder(r.Body).Decode(lcr); err != nil {
+		t.Fatal(err)
+	}
+	if lcr.Error != "2fa_required" || len(lcr.ChallengeToken) == 0 {
+		t.Fatalf("Expected a second factor challenge and got %#v", lcr)
+	}
+	r, err = PostRequest("/auth/2fa", authSecondFactorRequest{ChallengeToken: lcr.ChallengeToken, Totp: "abcdef"})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/2fa", authSecondFactorRequest{ChallengeToken: lcr.ChallengeToken, Totp: util.TOTPCode(secret, now)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &authLoginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Username != u.Id || len(s.Token) == 0 {
+		t.Fatalf("Unexpected login response %#v", s)
+	}
+	activeSessionToken = s.Token
+	r, err = DeleteRequestWithBody("/user/2fa", userTOTPCodeRequest{util.TOTPCode(secret, now.Add(util.TOTP_PERIOD*time.Second))})
+	CheckErrorAndResponse(t, r, err, 200)
+}
+
+func TestDeleteUser(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	privKeys := getUserPrivateKeys(u.PublicKey, u.Key)
+	team, err := u.CreateTeam(ctx, util.GenerateRandomToken(5), getDummyVaultKeyPair(privKeys, u.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = team.AddOrInviteUserByEmail(ctx, u, getDummyUser().Email); err != nil {
+		t.Fatal(err)
+	}
+	r, err := DeleteRequestWithBody("/user", userDeleteRequest{"nope"})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = DeleteRequestWithBody("/user", userDeleteRequest{u.Id})
+	CheckErrorAndResponse(t, r, err, 409)
+	udr := &userDeleteConflictResponse{}
+	if err := json.NewDecoder(r.Body).Decode(udr); err != nil {
+		t.Fatal(err)
+	}
+	if len(udr.Teams) != 1 || udr.Teams[0].Id != team.Id {
+		t.Fatalf("Expected team %s to block the deletion and got %#v", team.Id, udr.Teams)
+	}
+	if _, err = apiH.db.Exec(`DELETE FROM "team" WHERE "id" = $1`, team.Id); err != nil {
+		t.Fatal(err)
+	}
+	r, err = DeleteRequestWithBody("/user", userDeleteRequest{u.Id})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user")
+	CheckErrorAndResponse(t, r, err, 401)
+	if _, err = models.FindUser(ctx, u.Id); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("User still exists: %s", err)
+	}
+}
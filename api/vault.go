@@ -3,6 +3,7 @@ package api
 import (
 	"net/http"
 
+	"github.com/keydotcat/keycatd/managers"
 	"github.com/keydotcat/keycatd/models"
 	"github.com/keydotcat/keycatd/util"
 )
@@ -11,6 +12,9 @@ import (
 func (ah apiHandler) vaultRoot(w http.ResponseWriter, r *http.Request, t *models.Team) error {
 	var vid string
 	vid, r.URL.Path = shiftPath(r.URL.Path)
+	if at := ctxGetAccessToken(r.Context()); at != nil && !at.Allows(t.Id, vid) {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
 	if len(vid) == 0 {
 		switch r.Method {
 		case "GET":
@@ -57,7 +61,7 @@ func (ah apiHandler) vaultCreate(w http.ResponseWriter, r *http.Request, t *mode
 	u := ctxGetUser(ctx)
 	v, err := t.CreateVault(ctx, u, vcr.Name, vcr.Keys)
 	if err != nil {
-		return err
+		return ah.notifyPlanLimit(r, t, err)
 	}
 	vf, err := v.GetVaultFullForUser(ctx, u)
 	if err != nil {
@@ -71,7 +75,12 @@ func (ah apiHandler) validVaultRoot(w http.ResponseWriter, r *http.Request, t *m
 	var head string
 	head, r.URL.Path = shiftPath(r.URL.Path)
 	if len(head) == 0 {
-		//Not yet here
+		switch r.Method {
+		case "PATCH":
+			return ah.vaultRename(w, r, t, v)
+		case "DELETE":
+			return ah.vaultDelete(w, r, t, v)
+		}
 	} else {
 		switch head {
 		case "user":
@@ -80,11 +89,99 @@ func (ah apiHandler) validVaultRoot(w http.ResponseWriter, r *http.Request, t *m
 			return ah.validVaultSecretRoot(w, r, t, v)
 		case "secrets":
 			return ah.validVaultSecretsRoot(w, r, t, v)
+		case "trash":
+			return ah.validVaultTrashRoot(w, r, t, v)
+		case "rotate":
+			if r.Method == "POST" {
+				return ah.vaultRotateKeys(w, r, t, v)
+			}
+		case "retention":
+			switch r.Method {
+			case "GET":
+				return ah.vaultGetRetention(w, r, v)
+			case "PATCH":
+				return ah.vaultSetRetention(w, r, v)
+			}
 		}
 	}
 	return util.NewErrorFrom(ErrNotFound)
 }
 
+type vaultRenameRequest struct {
+	Name string `json:"name"`
+}
+
+// PATCH /team/:tid/vault/:vid
+func (ah apiHandler) vaultRename(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	vrr := &vaultRenameRequest{}
+	if err := jsonDecode(w, r, 4096, vrr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := v.Rename(ctx, u, vrr.Name); err != nil {
+		return err
+	}
+	vf, err := v.GetVaultFullForUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vf)
+}
+
+type vaultRetentionRequest struct {
+	KeepVersions     int `json:"keep_versions"`
+	KeepVersionsDays int `json:"keep_versions_days"`
+}
+
+type vaultRetentionResponse struct {
+	KeepVersions           int `json:"keep_versions"`
+	KeepVersionsDays       int `json:"keep_versions_days"`
+	ServerKeepVersions     int `json:"server_keep_versions"`
+	ServerKeepVersionsDays int `json:"server_keep_versions_days"`
+}
+
+// GET /team/:tid/vault/:vid/retention
+func (ah apiHandler) vaultGetRetention(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	return jsonResponse(w, vaultRetentionResponse{
+		KeepVersions:           v.KeepVersions,
+		KeepVersionsDays:       v.KeepVersionsDays,
+		ServerKeepVersions:     models.SECRET_KEEP_VERSIONS,
+		ServerKeepVersionsDays: models.SECRET_KEEP_VERSIONS_DAYS,
+	})
+}
+
+// PATCH /team/:tid/vault/:vid/retention
+// The stricter of the vault and the server retention applies so admins can only tighten it
+func (ah apiHandler) vaultSetRetention(w http.ResponseWriter, r *http.Request, v *models.Vault) error {
+	vrr := &vaultRetentionRequest{}
+	if err := jsonDecode(w, r, 4096, vrr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := v.SetVersionRetention(ctx, ctxGetUser(ctx), vrr.KeepVersions, vrr.KeepVersionsDays); err != nil {
+		return err
+	}
+	return ah.vaultGetRetention(w, r, v)
+}
+
+// DELETE /team/:tid/vault/:vid
+func (ah apiHandler) vaultDelete(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := t.DeleteVault(ctx, u, v.Id); err != nil {
+		return err
+	}
+	ah.bcast.Send(t.Id, v.Id, managers.BCAST_ACTION_VAULT_REMOVE, nil)
+	return ah.vaultList(w, r, t)
+}
+
 // /team/:tid/vault/:vid/user
 func (ah apiHandler) validVaultUserRoot(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
 	var uid string
@@ -96,6 +193,8 @@ func (ah apiHandler) validVaultUserRoot(w http.ResponseWriter, r *http.Request,
 		}
 	} else {
 		switch r.Method {
+		case "PATCH":
+			return ah.vaultSetUserPermission(w, r, t, v, uid)
 		case "DELETE":
 			return ah.vaultRemoveUser(w, r, t, v, uid)
 		}
@@ -111,16 +210,18 @@ func (ah apiHandler) vaultAddUser(w http.ResponseWriter, r *http.Request, t *mod
 	}
 	ctx := r.Context()
 	u := ctxGetUser(ctx)
-	isAdmin, err := t.CheckAdmin(ctx, u)
-	if err != nil {
-		return err
-	}
-	if !isAdmin {
+	if ctxGetAccessToken(ctx) != nil {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	}
+	if err := v.CheckPermission(ctx, u, models.VAULT_PERMISSION_MANAGE); err != nil {
+		return err
+	}
 	if err := v.AddUsers(ctx, keys); err != nil {
 		return err
 	}
+	for uid := range keys {
+		ah.bcast.SendVaultUser(t.Id, v.Id, uid, managers.BCAST_ACTION_VAULT_ACCESS_GRANTED)
+	}
 	vf, err := v.GetVaultFullForUser(ctx, u)
 	if err != nil {
 		return err
@@ -132,14 +233,38 @@ func (ah apiHandler) vaultAddUser(w http.ResponseWriter, r *http.Request, t *mod
 func (ah apiHandler) vaultRemoveUser(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, uid string) error {
 	ctx := r.Context()
 	u := ctxGetUser(ctx)
-	isAdmin, err := t.CheckAdmin(ctx, u)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	if err := v.CheckPermission(ctx, u, models.VAULT_PERMISSION_MANAGE); err != nil {
+		return err
+	}
+	if err := v.RemoveUser(ctx, uid); err != nil {
+		return err
+	}
+	vf, err := v.GetVaultFullForUser(ctx, u)
 	if err != nil {
 		return err
 	}
-	if !isAdmin {
+	return jsonResponse(w, vf)
+}
+
+type vaultSetUserPermissionRequest struct {
+	Permission string `json:"permission"`
+}
+
+// PATCH /team/:tid/vault/:vid/user/:uid
+func (ah apiHandler) vaultSetUserPermission(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault, uid string) error {
+	vsupr := &vaultSetUserPermissionRequest{}
+	if err := jsonDecode(w, r, 1024, vsupr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
 		return util.NewErrorFrom(models.ErrUnauthorized)
 	}
-	if err := v.RemoveUser(ctx, uid); err != nil {
+	if err := v.SetUserPermission(ctx, u, uid, vsupr.Permission); err != nil {
 		return err
 	}
 	vf, err := v.GetVaultFullForUser(ctx, u)
@@ -148,3 +273,37 @@ func (ah apiHandler) vaultRemoveUser(w http.ResponseWriter, r *http.Request, t *
 	}
 	return jsonResponse(w, vf)
 }
+
+type vaultRotateKeysRequest struct {
+	Keys    models.VaultKeyPair `json:"vault_keys"`
+	Secrets map[string][]byte   `json:"secrets"`
+}
+
+type vaultRotateKeysResponse struct {
+	*models.VaultFull
+	PurgedVersions int64 `json:"purged_versions"`
+}
+
+// POST /team/:tid/vault/:vid/rotate
+// Older versions of the secrets cannot be read with the new key so they are purged. The response says how many were.
+func (ah apiHandler) vaultRotateKeys(w http.ResponseWriter, r *http.Request, t *models.Team, v *models.Vault) error {
+	vrkr := &vaultRotateKeysRequest{}
+	if err := jsonDecode(w, r, 10*1024*1024, vrkr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if ctxGetAccessToken(ctx) != nil {
+		return util.NewErrorFrom(models.ErrUnauthorized)
+	}
+	_, purged, err := v.RotateKeys(ctx, u, vrkr.Keys, vrkr.Secrets)
+	if err != nil {
+		return err
+	}
+	ah.bcast.Send(v.Team, v.Id, managers.BCAST_ACTION_VAULT_ROTATED, nil)
+	vf, err := v.GetVaultFullForUser(ctx, u)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, vaultRotateKeysResponse{vf, purged})
+}
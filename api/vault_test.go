@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+)
+
+func TestRotateVaultKeys(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	vcsr := &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)}
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Vault.Id), vcsr)
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	vkp := getDummyVaultKeyPair(getUserPrivateKeys(u.PublicKey, u.Key), u.Id)
+	pub, err := verifyAndUnpack(u.PublicKey, vkp.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPriv := unsealVaultKey(&models.Vault{PublicKey: pub}, vkp.Keys[u.Id])
+	path := fmt.Sprintf("/team/%s/vault/%s/rotate", team.Id, v.Vault.Id)
+	r, err = PostRequest(path, vaultRotateKeysRequest{vkp, map[string][]byte{}})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PostRequest(path, vaultRotateKeysRequest{vkp, map[string][]byte{s.Id: signAndPack(newPriv, a32b)}})
+	CheckErrorAndResponse(t, r, err, 200)
+	vrkr := &vaultRotateKeysResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vrkr); err != nil {
+		t.Fatal(err)
+	}
+	vf := vrkr.VaultFull
+	if vrkr.PurgedVersions != 1 {
+		t.Fatalf("Expected the old version to be purged and got %d", vrkr.PurgedVersions)
+	}
+	if vf.Version <= v.Version {
+		t.Fatalf("Vault version did not increase: %d vs %d", vf.Version, v.Version)
+	}
+	if string(unsealVaultKey(&vf.Vault, vf.Key)) != string(newPriv) {
+		t.Fatal("Vault key was not rotated")
+	}
+}
+
+func TestVaultSecretVersionRetention(t *testing.T) {
+	u := loginDummyUser()
+	ctx := getCtx()
+	teams, err := u.GetTeams(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	team := teams[0]
+	vs, err := team.GetVaultsFullForUser(ctx, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := vs[0]
+	vPriv := unsealVaultKey(&v.Vault, v.Key)
+	r, err := PostRequest(fmt.Sprintf("/team/%s/vault/%s/secret", team.Id, v.Vault.Id), &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &models.Secret{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		r, err = PatchRequest(fmt.Sprintf("/team/%s/vault/%s/secret/%s", team.Id, v.Vault.Id, s.Id), &vaultCreateSecretRequest{Data: signAndPack(vPriv, a32b)})
+		CheckErrorAndResponse(t, r, err, 200)
+	}
+	path := fmt.Sprintf("/team/%s/vault/%s/retention", team.Id, v.Vault.Id)
+	r, err = PatchRequest(path, vaultRetentionRequest{KeepVersions: -1})
+	CheckErrorAndResponse(t, r, err, 400)
+	r, err = PatchRequest(path, vaultRetentionRequest{KeepVersions: 1, KeepVersionsDays: 30})
+	CheckErrorAndResponse(t, r, err, 200)
+	vrr := &vaultRetentionResponse{}
+	if err := json.NewDecoder(r.Body).Decode(vrr); err != nil {
+		t.Fatal(err)
+	}
+	if vrr.KeepVersions != 1 || vrr.KeepVersionsDays != 30 {
+		t.Fatalf("Unexpected retention %#v", vrr)
+	}
+	r, err = GetRequest(fmt.Sprintf("/team/%s/vault/%s/secret/%s/versions", team.Id, v.Vault.Id, s.Id))
+	CheckErrorAndResponse(t, r, err, 200)
+	vsr := &teamSecretListWrap{}
+	if err := json.NewDecoder(r.Body).Decode(vsr); err != nil {
+		t.Fatal(err)
+	}
+	if len(vsr.Secrets) != 1 || vsr.Secrets[0].Version != 3 {
+		t.Fatalf("Unexpected versions %#v", vsr.Secrets)
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const webauthnTimeout = 60000
+
+type webauthnCredentialDescriptor struct {
+	Type string `json:"type"`
+	Id   []byte `json:"id"`
+}
+
+type webauthnCredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type webauthnUserEntity struct {
+	Id          []byte `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type webauthnCreationOptions struct {
+	Challenge          []byte                         `json:"challenge"`
+	Rp                 util.WebauthnRelyingParty      `json:"rp"`
+	User               webauthnUserEntity             `json:"user"`
+	PubKeyCredParams   []webauthnCredentialParameter  `json:"pubKeyCredParams"`
+	Timeout            int                            `json:"timeout"`
+	Attestation        string                         `json:"attestation"`
+	ExcludeCredentials []webauthnCredentialDescriptor `json:"excludeCredentials"`
+}
+
+type webauthnRequestOptions struct {
+	Challenge        []byte                         `json:"challenge"`
+	RpId             string                         `json:"rpId"`
+	Timeout          int                            `json:"timeout"`
+	AllowCredentials []webauthnCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                         `json:"userVerification"`
+}
+
+func webauthnCredentialDescriptors(wcs []*models.WebauthnCredential) []webauthnCredentialDescriptor {
+	wcds := make([]webauthnCredentialDescriptor, len(wcs))
+	for i, wc := range wcs {
+		wcds[i] = webauthnCredentialDescriptor{"public-key", wc.RawId()}
+	}
+	return wcds
+}
+
+func (ah apiHandler) webauthnRequestOptions(ctx context.Context, u *models.User, t *models.Token) (*webauthnRequestOptions, error) {
+	wcs, err := u.GetWebauthnCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &webauthnRequestOptions{
+		Challenge:        []byte(t.Id),
+		RpId:             ah.options.webauthn.Id,
+		Timeout:          webauthnTimeout,
+		AllowCredentials: webauthnCredentialDescriptors(wcs),
+		UserVerification: "discouraged",
+	}, nil
+}
+
+// /user/webauthn
+func (ah apiHandler) userWebauthnRoot(w http.ResponseWriter, r *http.Request) error {
+	var head string
+	head, r.URL.Path = shiftPath(r.URL.Path)
+	if len(head) == 0 {
+		switch r.Method {
+		case "GET":
+			return ah.userWebauthnList(w, r)
+		case "POST":
+			return ah.userWebauthnBeginRegistration(w, r)
+		}
+	} else {
+		switch r.Method {
+		case "POST":
+			return ah.userWebauthnFinishRegistration(w, r, head)
+		case "DELETE":
+			return ah.userWebauthnDelete(w, r, head)
+		}
+	}
+	return util.NewErrorFrom(ErrNotFound)
+}
+
+type userWebauthnListResponse struct {
+	Credentials []*models.WebauthnCredential `json:"credentials"`
+}
+
+// GET /user/webauthn
+func (ah apiHandler) userWebauthnList(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	wcs, err := u.GetWebauthnCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userWebauthnListResponse{wcs})
+}
+
+type userWebauthnBeginResponse struct {
+	Token     string                  `json:"token"`
+	PublicKey webauthnCreationOptions `json:"public_key"`
+}
+
+// POST /user/webauthn
+func (ah apiHandler) userWebauthnBeginRegistration(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	wcs, err := u.GetWebauthnCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	t, err := u.NewWebauthnRegistration(ctx)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, userWebauthnBeginResponse{
+		t.Id,
+		webauthnCreationOptions{
+			Challenge: []byte(t.Id),
+			Rp:        ah.options.webauthn,
+			User:      webauthnUserEntity{[]byte(u.Id), u.Id, u.FullName},
+			PubKeyCredParams: []webauthnCredentialParameter{
+				{"public-key", util.WEBAUTHN_ALG_ES256},
+				{"public-key", util.WEBAUTHN_ALG_EDDSA},
+			},
+			Timeout:            webauthnTimeout,
+			Attestation:        "none",
+			ExcludeCredentials: webauthnCredentialDescriptors(wcs),
+		},
+	})
+}
+
+type userWebauthnFinishRequest struct {
+	Name              string `json:"name"`
+	ClientDataJSON    []byte `json:"client_data_json"`
+	AttestationObject []byte `json:"attestation_object"`
+}
+
+// POST /user/webauthn/:token
+func (ah apiHandler) userWebauthnFinishRegistration(w http.ResponseWriter, r *http.Request, token string) error {
+	uwfr := &userWebauthnFinishRequest{}
+	if err := jsonDecode(w, r, 8192, uwfr); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	t, err := models.FindToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	wc, err := t.FinishWebauthnRegistration(ctx, u, ah.options.webauthn, uwfr.Name, uwfr.ClientDataJSON, uwfr.AttestationObject)
+	if err != nil {
+		return err
+	}
+	return jsonResponse(w, wc)
+}
+
+// DELETE /user/webauthn/:cid
+func (ah apiHandler) userWebauthnDelete(w http.ResponseWriter, r *http.Request, cid string) error {
+	ctx := r.Context()
+	u := ctxGetUser(ctx)
+	if err := u.DeleteWebauthnCredential(ctx, cid); err != nil {
+		return err
+	}
+	return ah.userWebauthnList(w, r)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type softAuthenticator struct {
+	rp    util.WebauthnRelyingParty
+	id    []byte
+	key   *ecdsa.PrivateKey
+	count uint32
+}
+
+func newSoftAuthenticator(rp util.WebauthnRelyingParty) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return &softAuthenticator{rp, util.GenerateRandomByteArray(16), key, 0}
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rp.Id))
+	a.count++
+	ad := append([]byte{}, rpIdHash[:]...)
+	ad = append(ad, 0x01, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(ad[33:], a.count)
+	if attested {
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		a.key.X.FillBytes(x)
+		a.key.Y.FillBytes(y)
+		ad[32] |= 0x40
+		ad = append(ad, make([]byte, 16)...)
+		ad = append(ad, byte(len(a.id)>>8), byte(len(a.id)))
+		ad = append(ad, a.id...)
+		ad = append(ad, 0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20)
+		ad = append(ad, x...)
+		ad = append(ad, 0x22, 0x58, 0x20)
+		ad = append(ad, y...)
+	}
+	return ad
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	cd, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.rp.Origin,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cd
+}
+
+func (a *softAuthenticator) create(challenge []byte) ([]byte, []byte) {
+	ad := a.authData(true)
+	att := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x59, byte(len(ad) >> 8), byte(len(ad))}
+	return a.clientData("webauthn.create", challenge), append(att, ad...)
+}
+
+func (a *softAuthenticator) get(challenge []byte) *authWebauthnAssertion {
+	cd := a.clientData("webauthn.get", challenge)
+	ad := a.authData(false)
+	cdHash := sha256.Sum256(cd)
+	h := sha256.Sum256(append(append([]byte{}, ad...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return &authWebauthnAssertion{base64.RawURLEncoding.EncodeToString(a.id), cd, ad, sig}
+}
+
+func TestWebauthnLogin(t *testing.T) {
+	u := loginDummyUser()
+	r, err := PostRequest("/user/webauthn", nil)
+	CheckErrorAndResponse(t, r, err, 200)
+	wbr := &userWebauthnBeginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(wbr); err != nil {
+		t.Fatal(err)
+	}
+	if wbr.PublicKey.Rp.Id != apiH.options.webauthn.Id || string(wbr.PublicKey.Challenge) != wbr.Token {
+		t.Fatalf("Unexpected creation options %#v", wbr.PublicKey)
+	}
+	a := newSoftAuthenticator(apiH.options.webauthn)
+	cd, att := a.create(wbr.PublicKey.Challenge)
+	r, err = PostRequest("/user/webauthn/"+wbr.Token, userWebauthnFinishRequest{"key", cd, att})
+	CheckErrorAndResponse(t, r, err, 200)
+	r, err = GetRequest("/user/webauthn")
+	CheckErrorAndResponse(t, r, err, 200)
+	wlr := &userWebauthnListResponse{}
+	if err := json.NewDecoder(r.Body).Decode(wlr); err != nil {
+		t.Fatal(err)
+	}
+	if len(wlr.Credentials) != 1 || wlr.Credentials[0].Name != "key" {
+		t.Fatalf("Unexpected credentials %#v", wlr.Credentials)
+	}
+	activeSessionToken = ""
+	r, err = PostRequest("/auth/login", authRequest{Id: u.Id, Password: u.Id})
+	CheckErrorAndResponse(t, r, err, 401)
+	lcr := &authLoginChallengeResponse{}
+	if err := json.NewDecoder(r.Body).Decode(lcr); err != nil {
+		t.Fatal(err)
+	}
+	if lcr.Webauthn == nil || len(lcr.Webauthn.AllowCredentials) != 1 {
+		t.Fatalf("Expected a webauthn challenge and got %#v", lcr)
+	}
+	other := newSoftAuthenticator(apiH.options.webauthn)
+	r, err = PostRequest("/auth/2fa", authSecondFactorRequest{ChallengeToken: lcr.ChallengeToken, Webauthn: other.get(lcr.Webauthn.Challenge)})
+	CheckErrorAndResponse(t, r, err, 401)
+	r, err = PostRequest("/auth/2fa", authSecondFactorRequest{ChallengeToken: lcr.ChallengeToken, Webauthn: a.get(lcr.Webauthn.Challenge)})
+	CheckErrorAndResponse(t, r, err, 200)
+	s := &authLoginResponse{}
+	if err := json.NewDecoder(r.Body).Decode(s); err != nil {
+		t.Fatal(err)
+	}
+	activeSessionToken = s.Token
+	r, err = DeleteRequest("/user/webauthn/" + wlr.Credentials[0].Id)
+	CheckErrorAndResponse(t, r, err, 200)
+}
@@ -97,7 +97,7 @@ func (ah apiHandler) broadcastEventListenLoop(r *http.Request, eb eventSender) e
 			if !ok {
 				continue
 			}
-			found := false
+			found := len(b.Vault) == 0 || b.User == currentUser.Id
 			for _, v := range vs {
 				if v.Id == b.Vault {
 					found = true
@@ -110,6 +110,21 @@ func (ah apiHandler) broadcastEventListenLoop(r *http.Request, eb eventSender) e
 			if err := eb.sendMessage(b.Message); err != nil {
 				alive = false
 			}
+			//Keep track of the teams and vaults the user can see
+			switch {
+			case b.Action == managers.BCAST_ACTION_TEAM_USER_DEL && b.User == currentUser.Id,
+				b.Action == managers.BCAST_ACTION_TEAM_REMOVE:
+				delete(tv, b.Team)
+			case b.Action == managers.BCAST_ACTION_VAULT_ACCESS_GRANTED && b.User == currentUser.Id:
+				tv[b.Team] = append(vs, &models.Vault{Team: b.Team, Id: b.Vault})
+			case b.Action == managers.BCAST_ACTION_VAULT_REMOVE:
+				for i, v := range vs {
+					if v.Id == b.Vault {
+						tv[b.Team] = append(vs[:i:i], vs[i+1:]...)
+						break
+					}
+				}
+			}
 		}
 	}
 	return nil
@@ -21,10 +21,37 @@ func processConf(cfgFile string) api.Conf {
 	viper.SetDefault("db.maxconns", 0)
 	viper.SetDefault("db.type", "postgresql")
 	viper.SetDefault("only_invited", false)
+	viper.SetDefault("admins", []string{})
 	viper.SetDefault("csrf.hash_key", "")
 	viper.SetDefault("csrf.block_key", "")
+	viper.SetDefault("session.idle_ttl", "168h")
+	viper.SetDefault("session.absolute_ttl", "720h")
 	viper.SetDefault("session.redis.server", "")
 	viper.SetDefault("session.redis.db_id", 0)
+	viper.SetDefault("lockout.attempts", 5)
+	viper.SetDefault("lockout.duration", "15m")
+	viper.SetDefault("ratelimit.per_ip", 60)
+	viper.SetDefault("ratelimit.per_account", 10)
+	viper.SetDefault("ratelimit.period", "1m")
+	viper.SetDefault("team_limits.members", 0)
+	viper.SetDefault("team_limits.vaults", 0)
+	viper.SetDefault("team_limits.secrets", 0)
+	viper.SetDefault("team_limits.secret_bytes", 0)
+	viper.SetDefault("team_limits.shares", 100)
+	viper.SetDefault("trash.retention", "720h")
+	viper.SetDefault("secret_versions.keep", 0)
+	viper.SetDefault("secret_versions.days", 0)
+	viper.SetDefault("blob_store.fs.dir", "attachments")
+	viper.SetDefault("blob_store.s3.endpoint", "")
+	viper.SetDefault("blob_store.s3.region", "")
+	viper.SetDefault("blob_store.s3.bucket", "")
+	viper.SetDefault("blob_store.s3.access_key", "")
+	viper.SetDefault("blob_store.s3.secret_key", "")
+	viper.SetDefault("blob_store.s3.path_style", true)
+	viper.SetDefault("oidc.issuer", "")
+	viper.SetDefault("oidc.client_id", "")
+	viper.SetDefault("oidc.client_secret", "")
+	viper.SetDefault("oidc.redirect_url", "")
 	viper.SetDefault("mail.from", "")
 	viper.SetDefault("mail.smtp.server", "")
 	viper.SetDefault("mail.smtp.user", "")
@@ -47,9 +74,25 @@ func processConf(cfgFile string) api.Conf {
 	}
 	c.DBMaxConns = viper.GetInt("db.maxconns")
 	c.OnlyInvited = viper.GetBool("only_invited")
+	c.Admins = viper.GetStringSlice("admins")
 	c.MailFrom = viper.GetString("mail.from")
+	c.Session.IdleTTL = viper.GetDuration("session.idle_ttl")
+	c.Session.AbsoluteTTL = viper.GetDuration("session.absolute_ttl")
 	c.Csrf.HashKey = viper.GetString("csrf.hash_key")
 	c.Csrf.BlockKey = viper.GetString("csrf.block_key")
+	c.Lockout.Attempts = viper.GetInt("lockout.attempts")
+	c.Lockout.Duration = viper.GetDuration("lockout.duration")
+	c.RateLimit.PerIp = viper.GetInt("ratelimit.per_ip")
+	c.RateLimit.PerAccount = viper.GetInt("ratelimit.per_account")
+	c.RateLimit.Period = viper.GetDuration("ratelimit.period")
+	c.TeamLimits.Members = viper.GetInt("team_limits.members")
+	c.TeamLimits.Vaults = viper.GetInt("team_limits.vaults")
+	c.TeamLimits.Secrets = viper.GetInt("team_limits.secrets")
+	c.TeamLimits.SecretBytes = viper.GetInt64("team_limits.secret_bytes")
+	c.TeamLimits.Shares = viper.GetInt("team_limits.shares")
+	c.Trash.Retention = viper.GetDuration("trash.retention")
+	c.SecretVersions.Keep = viper.GetInt("secret_versions.keep")
+	c.SecretVersions.Days = viper.GetInt("secret_versions.days")
 	if len(viper.GetString("mail.smtp.server")) > 0 {
 		c.MailSMTP = &api.ConfMailSMTP{
 			Server:   viper.GetString("mail.smtp.server"),
@@ -63,6 +106,26 @@ func processConf(cfgFile string) api.Conf {
 			EU:  viper.GetBool("mail.sparkpost.eu"),
 		}
 	}
+	if len(viper.GetString("blob_store.s3.endpoint")) > 0 {
+		c.BlobStoreS3 = &api.ConfBlobStoreS3{
+			Endpoint:  viper.GetString("blob_store.s3.endpoint"),
+			Region:    viper.GetString("blob_store.s3.region"),
+			Bucket:    viper.GetString("blob_store.s3.bucket"),
+			AccessKey: viper.GetString("blob_store.s3.access_key"),
+			SecretKey: viper.GetString("blob_store.s3.secret_key"),
+			PathStyle: viper.GetBool("blob_store.s3.path_style"),
+		}
+	} else {
+		c.BlobStoreFS = &api.ConfBlobStoreFS{Dir: viper.GetString("blob_store.fs.dir")}
+	}
+	if len(viper.GetString("oidc.issuer")) > 0 {
+		c.OIDC = &api.ConfOIDC{
+			Issuer:       viper.GetString("oidc.issuer"),
+			ClientId:     viper.GetString("oidc.client_id"),
+			ClientSecret: viper.GetString("oidc.client_secret"),
+			RedirectUrl:  viper.GetString("oidc.redirect_url"),
+		}
+	}
 	if srv := viper.GetString("session.redis.server"); len(srv) > 0 {
 		c.SessionRedis = &api.ConfSessionRedis{srv, viper.GetInt("session.redis.db_id")}
 	}
@@ -0,0 +1 @@
+ALTER TABLE "vault_user" ADD COLUMN "key_lost" BOOL NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE "user" ADD COLUMN "totp_secret" BYTEA NULL;
+ALTER TABLE "user" ADD COLUMN "totp_enabled" BOOL NOT NULL DEFAULT false;
+ALTER TABLE "user" ADD COLUMN "totp_last_counter" BIGINT NOT NULL DEFAULT 0;
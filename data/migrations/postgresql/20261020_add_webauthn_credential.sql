@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS "webauthn_credential" CASCADE;
+CREATE TABLE "webauthn_credential" (
+	"id" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"public_key" BYTEA NOT NULL,
+	"sign_count" BIGINT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_webauthn_credential" PRIMARY KEY ("id"),
+	CONSTRAINT "fk_webauthn_credential_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
+);
+CREATE INDEX "idx_webauthn_credential_user" ON "webauthn_credential" ("user");
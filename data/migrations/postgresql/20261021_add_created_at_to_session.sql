@@ -0,0 +1,3 @@
+ALTER TABLE "session" ADD COLUMN "created_at" TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+CREATE INDEX "idx_session_last_access" ON "session" ("last_access");
+CREATE INDEX "idx_session_created_at" ON "session" ("created_at");
@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS "access_token" CASCADE;
+CREATE TABLE "access_token" (
+	"id" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"hash_secret" BYTEA NOT NULL,
+	"store_token" TEXT NOT NULL,
+	"scopes" TEXT[] NOT NULL,
+	"read_only" BOOL NOT NULL,
+	"expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"last_used_at" TIMESTAMP WITH TIME ZONE NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_access_token" PRIMARY KEY ("id"),
+	CONSTRAINT "fk_access_token_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
+);
+CREATE INDEX "idx_access_token_user" ON "access_token" ("user");
@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS "oidc_identity" CASCADE;
+CREATE TABLE "oidc_identity" (
+	"issuer" TEXT NOT NULL,
+	"subject" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"email" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_oidc_identity" PRIMARY KEY ("issuer", "subject"),
+	CONSTRAINT "fk_oidc_identity_user" FOREIGN KEY ("user") REFERENCES "user" ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_oidc_identity_user_issuer" ON "oidc_identity" ("user", "issuer");
@@ -0,0 +1,3 @@
+ALTER TABLE "vault" ADD COLUMN "name" TEXT NOT NULL DEFAULT '';
+UPDATE "vault" SET "name" = "id";
+ALTER TABLE "vault" ALTER COLUMN "name" DROP DEFAULT;
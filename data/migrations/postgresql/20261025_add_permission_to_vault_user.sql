@@ -0,0 +1,2 @@
+ALTER TABLE "vault_user" ADD COLUMN "permission" TEXT NOT NULL DEFAULT 'write';
+UPDATE "vault_user" SET "permission" = 'manage' FROM "team_user" WHERE "team_user"."team" = "vault_user"."team" AND "team_user"."user" = "vault_user"."user" AND "team_user"."admin";
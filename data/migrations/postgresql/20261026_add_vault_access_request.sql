@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS "vault_access_request" CASCADE;
+CREATE TABLE "vault_access_request" (
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"user" TEXT NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_vault_access_request" PRIMARY KEY ("team", "vault", "user"),
+	CONSTRAINT "fk_vault_access_request_vault" FOREIGN KEY ("team", "vault") REFERENCES "vault" ON DELETE CASCADE,
+	CONSTRAINT "fk_vault_access_request_team_user" FOREIGN KEY ("team", "user") REFERENCES "team_user" ON DELETE CASCADE
+);
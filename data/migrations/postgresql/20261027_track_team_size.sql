@@ -0,0 +1,2 @@
+ALTER TABLE "team" ALTER COLUMN "size" TYPE BIGINT;
+UPDATE "team" SET "size" = (SELECT COALESCE(SUM(OCTET_LENGTH("secret"."data")), 0) FROM "secret" WHERE "secret"."team" = "team"."id");
@@ -0,0 +1,2 @@
+ALTER TABLE "secret" ADD COLUMN "deleted_at" TIMESTAMP WITH TIME ZONE NULL;
+CREATE INDEX "idx_secret_deleted_at" ON "secret" ("deleted_at");
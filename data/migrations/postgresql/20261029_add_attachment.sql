@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS "attachment" CASCADE;
+CREATE TABLE "attachment" (
+	"id" TEXT NOT NULL,
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"secret" TEXT NOT NULL,
+	"meta" BYTEA NOT NULL,
+	"size" BIGINT NOT NULL DEFAULT 0,
+	"chunk_sizes" BIGINT[] NOT NULL,
+	"chunk_keys" TEXT[] NOT NULL,
+	"complete" BOOLEAN NOT NULL DEFAULT FALSE,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"updated_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_attachment" PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_attachment_team_vault_secret" ON "attachment" ("team","vault","secret");
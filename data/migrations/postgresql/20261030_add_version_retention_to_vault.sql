@@ -0,0 +1,2 @@
+ALTER TABLE "vault" ADD COLUMN "keep_versions" INT NOT NULL DEFAULT 0;
+ALTER TABLE "vault" ADD COLUMN "keep_versions_days" INT NOT NULL DEFAULT 0;
@@ -0,0 +1,15 @@
+DROP TABLE IF EXISTS "share" CASCADE;
+CREATE TABLE "share" (
+	"id" TEXT NOT NULL,
+	"team" TEXT NOT NULL,
+	"creator" TEXT NOT NULL,
+	"data" BYTEA NOT NULL,
+	"max_views" INT NOT NULL,
+	"views" INT NOT NULL DEFAULT 0,
+	"expires_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	"created_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_share" PRIMARY KEY ("id"),
+	CONSTRAINT "fk_share_team_user" FOREIGN KEY ("team", "creator") REFERENCES "team_user" ON DELETE CASCADE
+);
+CREATE INDEX "idx_share_team" ON "share" ("team");
+CREATE INDEX "idx_share_expires_at" ON "share" ("expires_at");
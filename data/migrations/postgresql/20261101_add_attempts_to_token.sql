@@ -0,0 +1 @@
+ALTER TABLE "token" ADD COLUMN "attempts" INT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+UPDATE "oidc_identity" SET "issuer" = RTRIM("issuer", '/') WHERE "issuer" LIKE '%/';
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS "team_limit_notice" CASCADE;
+CREATE TABLE "team_limit_notice" (
+	"team" TEXT NOT NULL,
+	"name" TEXT NOT NULL,
+	"notified_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_team_limit_notice" PRIMARY KEY ("team", "name"),
+	CONSTRAINT "fk_team_limit_notice_team" FOREIGN KEY ("team") REFERENCES "team" ON DELETE CASCADE
+);
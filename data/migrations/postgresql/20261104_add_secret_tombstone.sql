@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS "secret_tombstone" CASCADE;
+CREATE TABLE "secret_tombstone" (
+	"team" TEXT NOT NULL,
+	"vault" TEXT NOT NULL,
+	"id" TEXT NOT NULL,
+	"vault_version" INT NOT NULL,
+	"deleted_at" TIMESTAMP WITH TIME ZONE NOT NULL,
+	CONSTRAINT "pk_secret_tombstone" PRIMARY KEY ("team", "vault", "id"),
+	CONSTRAINT "fk_secret_tombstone_vault" FOREIGN KEY ("team", "vault") REFERENCES "vault" ON DELETE CASCADE
+);
+CREATE INDEX "idx_secret_tombstone_deleted_at" ON "secret_tombstone" ("deleted_at");
@@ -0,0 +1 @@
+ALTER TABLE "vault" ADD COLUMN "purged_version" INT NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+ALTER TABLE "vault_user" ADD COLUMN "member_permission" TEXT NULL;
@@ -0,0 +1,3 @@
+UPDATE "team" SET "size" =
+	(SELECT COALESCE(SUM(OCTET_LENGTH("data")), 0) FROM "secret" WHERE "secret"."team" = "team"."id") +
+	(SELECT COALESCE(SUM("size"), 0) FROM "attachment" WHERE "attachment"."team" = "team"."id");
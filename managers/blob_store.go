@@ -0,0 +1,11 @@
+package managers
+
+import "io"
+
+// Stores the encrypted chunks of the attachments. Keys are slash separated paths generated by the server.
+// Deleting a blob that does not exist is not an error.
+type BlobStore interface {
+	Put(key string, data io.Reader, size int64) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
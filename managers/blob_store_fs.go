@@ -0,0 +1,89 @@
+package managers
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+type blobStoreFS struct {
+	dir string
+}
+
+func NewBlobStoreFS(dir string) (BlobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return blobStoreFS{dir}, nil
+}
+
+func (b blobStoreFS) path(key string) (string, error) {
+	if len(key) == 0 || strings.Contains(key, "..") {
+		return "", util.NewErrorf("Invalid blob key %s", key)
+	}
+	return filepath.Join(b.dir, filepath.FromSlash(key)), nil
+}
+
+// Writes to a temporary file first so readers never see a partial blob
+func (b blobStoreFS) Put(key string, data io.Reader, size int64) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return util.NewErrorFrom(err)
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload")
+	if err != nil {
+		return util.NewErrorFrom(err)
+	}
+	n, err := io.Copy(f, io.LimitReader(data, size))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n != size {
+		err = util.NewErrorf("Expected %d bytes for blob %s and got %d", size, key, n)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+func (b blobStoreFS) Get(key string) (io.ReadCloser, error) {
+	p, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, util.NewErrorFrom(models.ErrDoesntExist)
+	} else if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	return f, nil
+}
+
+func (b blobStoreFS) Delete(key string) error {
+	p, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return util.NewErrorFrom(err)
+	}
+	//Drop the parent directory once it is empty. It fails harmlessly while other blobs remain
+	if parent := filepath.Dir(p); parent != filepath.Clean(b.dir) {
+		os.Remove(parent)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package managers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+func testBlobStore(bs BlobStore, t *testing.T, bsName string) {
+	key := util.GenerateRandomToken(16) + "/0"
+	data := util.GenerateRandomByteArray(4096)
+	if err := bs.Put(key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("%s failed test: %s", bsName, err)
+	}
+	r, err := bs.Get(key)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", bsName, err)
+	}
+	stored, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatalf("%s failed test: %s", bsName, err)
+	}
+	if !bytes.Equal(stored, data) {
+		t.Fatalf("%s returned different data", bsName)
+	}
+	if err = bs.Delete(key); err != nil {
+		t.Fatalf("%s failed test: %s", bsName, err)
+	}
+	if _, err = bs.Get(key); !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("%s expected %s and got %s", bsName, models.ErrDoesntExist, err)
+	}
+	if err = bs.Delete(key); err != nil {
+		t.Fatalf("%s failed deleting a missing blob: %s", bsName, err)
+	}
+}
+
+func TestFSBlobStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keycat-blobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bs, err := NewBlobStoreFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(bs, t, "fs")
+	if err = bs.Put("../escape", bytes.NewReader(nil), 0); err == nil {
+		t.Fatal("Stored a blob outside of the directory")
+	}
+}
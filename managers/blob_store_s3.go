@@ -0,0 +1,141 @@
+package managers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/models"
+	"github.com/keydotcat/keycatd/util"
+)
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+type blobStoreS3 struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+// Stores the blobs in a bucket of an S3 compatible service such as AWS or MinIO. Path style requests
+// (endpoint/bucket/key) are what MinIO and most self hosted services expect.
+func NewBlobStoreS3(endpoint, region, bucket, accessKey, secretKey string, pathStyle bool) (BlobStore, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || len(u.Host) == 0 {
+		return nil, util.NewErrorf("Invalid s3 endpoint %s", endpoint)
+	}
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	return blobStoreS3{u, region, bucket, accessKey, secretKey, pathStyle, &http.Client{Timeout: 5 * time.Minute}}, nil
+}
+
+func (b blobStoreS3) objectUrl(key string) string {
+	u := *b.endpoint
+	if b.pathStyle {
+		u.Path = "/" + b.bucket + "/" + key
+	} else {
+		u.Host = b.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	return u.String()
+}
+
+func (b blobStoreS3) do(method, key string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.objectUrl(key), body)
+	if err != nil {
+		return nil, util.NewErrorFrom(err)
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	b.sign(req, time.Now().UTC())
+	resp, err := b.client.Do(req)
+	return resp, util.NewErrorFrom(err)
+}
+
+// Signs the request with AWS signature version 4. The payload is not signed so it can be streamed.
+func (b blobStoreS3) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + b.region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + s3UnsignedPayload + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+	crh := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crh[:])
+	key := []byte("AWS4" + b.secretKey)
+	for _, part := range []string{now.Format("20060102"), b.region, "s3", "aws4_request"} {
+		key = s3Hmac(key, part)
+	}
+	signature := hex.EncodeToString(s3Hmac(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func s3Hmac(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func s3Error(resp *http.Response) error {
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return util.NewErrorf("S3 replied with %s: %s", resp.Status, msg)
+}
+
+func (b blobStoreS3) Put(key string, data io.Reader, size int64) error {
+	resp, err := b.do("PUT", key, data, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (b blobStoreS3) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.do("GET", key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, util.NewErrorFrom(models.ErrDoesntExist)
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(resp)
+}
+
+func (b blobStoreS3) Delete(key string) error {
+	resp, err := b.do("DELETE", key, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package managers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Expects a MinIO server with the default credentials, e.g. docker run -p 9000:9000 minio/minio server /data
+func TestS3BlobStore(t *testing.T) {
+	bs, err := NewBlobStoreS3("http://localhost:9000", "", "keycat-test", "minioadmin", "minioadmin", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := bs.(blobStoreS3)
+	req, err := http.NewRequest("PUT", s3.endpoint.String()+"/"+s3.bucket, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3.sign(req, time.Now().UTC())
+	resp, err := s3.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Could not create the test bucket: %s", resp.Status)
+	}
+	testBlobStore(bs, t, "s3")
+}
@@ -13,11 +13,23 @@ const (
 	BCAST_ACTION_SECRET_CHANGE = BroadcastAction("secret:change")
 	BCAST_ACTION_SECRET_REMOVE = BroadcastAction("secret:remove")
 	BCAST_ACTION_VAULT_VERSION = BroadcastAction("vault:version")
+	BCAST_ACTION_VAULT_ROTATED = BroadcastAction("vault:rotated")
+	BCAST_ACTION_VAULT_REMOVE  = BroadcastAction("vault:remove")
+	BCAST_ACTION_TEAM_REMOVE   = BroadcastAction("team:remove")
+	BCAST_ACTION_TEAM_USER_DEL = BroadcastAction("team:user:remove")
+
+	BCAST_ACTION_VAULT_ACCESS_GRANTED = BroadcastAction("vault:access:granted")
+	BCAST_ACTION_VAULT_ACCESS_DENIED  = BroadcastAction("vault:access:denied")
+	BCAST_ACTION_SECRET_RESTORE       = BroadcastAction("secret:restore")
 )
 
+// Broadcasts without a vault are about the whole team and reach every member of it.
+// Broadcasts with a user also reach that user even if the vault is not visible to them yet.
 type Broadcast struct {
 	Team    string
 	Vault   string
+	User    string
+	Action  BroadcastAction
 	Message []byte
 }
 
@@ -25,6 +37,8 @@ type BroadcasterMgr interface {
 	Subscribe(address string) <-chan *Broadcast
 	Unsubscribe(address string)
 	Send(team, vault string, action BroadcastAction, secret *models.Secret)
+	SendTeamUser(team, user string, action BroadcastAction)
+	SendVaultUser(team, vault, user string, action BroadcastAction)
 	Stop()
 }
 
@@ -32,14 +46,15 @@ type BroadcastPayload struct {
 	Action       BroadcastAction              `json:"action"`
 	Team         string                       `json:"team,omitempty"`
 	Vault        string                       `json:"vault,omitempty"`
+	User         string                       `json:"user,omitempty"`
 	Secret       *models.Secret               `json:"secret,omitempty"`
 	VaultVersion map[string]map[string]uint32 `json:"vault_version,omitempty"`
 }
 
-func createBroadcast(team, vault string, action BroadcastAction, secret *models.Secret) *Broadcast {
-	msg, err := json.Marshal(BroadcastPayload{action, team, vault, secret, nil})
+func createBroadcast(team, vault, user string, action BroadcastAction, secret *models.Secret) *Broadcast {
+	msg, err := json.Marshal(BroadcastPayload{action, team, vault, user, secret, nil})
 	if err != nil {
 		panic(err)
 	}
-	return &Broadcast{team, vault, msg}
+	return &Broadcast{team, vault, user, action, msg}
 }
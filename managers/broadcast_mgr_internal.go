@@ -85,7 +85,15 @@ func (ibm *InternalBroadcasterMgr) Unsubscribe(sid string) {
 }
 
 func (ibm *InternalBroadcasterMgr) Send(team, vault string, action BroadcastAction, secret *models.Secret) {
-	ibm.sourceChan <- createBroadcast(team, vault, action, secret)
+	ibm.sourceChan <- createBroadcast(team, vault, "", action, secret)
+}
+
+func (ibm *InternalBroadcasterMgr) SendTeamUser(team, user string, action BroadcastAction) {
+	ibm.sourceChan <- createBroadcast(team, "", user, action, nil)
+}
+
+func (ibm *InternalBroadcasterMgr) SendVaultUser(team, vault, user string, action BroadcastAction) {
+	ibm.sourceChan <- createBroadcast(team, vault, user, action, nil)
 }
 
 func (ibm *InternalBroadcasterMgr) Stop() {
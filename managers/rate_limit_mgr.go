@@ -0,0 +1,20 @@
+package managers
+
+import "time"
+
+// Token bucket limit. Up to Requests requests can be done in a burst and the bucket refills
+// completely after Period.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func (rl RateLimit) refillRate() float64 {
+	return float64(rl.Requests) / float64(rl.Period)
+}
+
+type RateLimitMgr interface {
+	// Takes a token from the bucket for key. If there are no tokens left it returns false
+	// and how long the caller has to wait for the next one.
+	Allow(key string, limit RateLimit) (bool, time.Duration, error)
+}
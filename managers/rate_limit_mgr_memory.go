@@ -0,0 +1,57 @@
+package managers
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+type rateLimitMgrMemory struct {
+	lock      *sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewRateLimitMgrMemory() RateLimitMgr {
+	return &rateLimitMgrMemory{&sync.Mutex{}, map[string]*tokenBucket{}, time.Now()}
+}
+
+func (r *rateLimitMgrMemory) Allow(key string, limit RateLimit) (bool, time.Duration, error) {
+	now := time.Now()
+	rate := limit.refillRate()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.sweep(now)
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &tokenBucket{float64(limit.Requests), now, now}
+		r.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Requests), b.tokens+float64(now.Sub(b.last))*rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration(math.Ceil((1 - b.tokens) / rate)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(limit.Requests) - b.tokens) / rate))
+	return true, 0, nil
+}
+
+// Buckets that have refilled completely are the same as missing ones so drop them once a minute
+func (r *rateLimitMgrMemory) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < time.Minute {
+		return
+	}
+	r.lastSweep = now
+	for key, b := range r.buckets {
+		if now.After(b.full) {
+			delete(r.buckets, key)
+		}
+	}
+}
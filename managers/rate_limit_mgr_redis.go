@@ -0,0 +1,66 @@
+package managers
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// Refills the bucket stored in KEYS[1] and takes a token from it. Returns 0 if a token was taken
+// or the milliseconds until the next token will be available otherwise.
+var rateLimitScript = radix.NewEvalScript(1, `
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(bucket[1]) or capacity
+local last = tonumber(bucket[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - last) * rate)
+local wait = 0
+if tokens < 1 then
+	wait = math.ceil((1 - tokens) / rate)
+else
+	tokens = tokens - 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1)
+return wait
+`)
+
+type rateLimitMgrRedis struct {
+	prefix string
+	dbId   string
+	pool   *radix.Pool
+}
+
+func NewRateLimitMgrRedis(pool *radix.Pool, dbId int) RateLimitMgr {
+	return rateLimitMgrRedis{"kc-", strconv.Itoa(dbId), pool}
+}
+
+func (r rateLimitMgrRedis) rkey(i string) string {
+	return fmt.Sprintf("%srl:%s", r.prefix, i)
+}
+
+func (r rateLimitMgrRedis) Allow(key string, limit RateLimit) (bool, time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	rate := limit.refillRate() * float64(time.Millisecond)
+	var wait int64
+	err := r.pool.Do(radix.WithConn(r.rkey(key), func(c radix.Conn) error {
+		if err := c.Do(radix.Cmd(nil, "SELECT", r.dbId)); err != nil {
+			return err
+		}
+		return c.Do(rateLimitScript.Cmd(
+			&wait,
+			r.rkey(key),
+			strconv.Itoa(limit.Requests),
+			strconv.FormatFloat(rate, 'g', -1, 64),
+			strconv.FormatInt(now, 10),
+		))
+	}))
+	if err != nil {
+		return false, 0, err
+	}
+	return wait == 0, time.Duration(wait) * time.Millisecond, nil
+}
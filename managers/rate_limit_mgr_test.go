@@ -0,0 +1,40 @@
+package managers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func testRateLimitManager(rl RateLimitMgr, t *testing.T, rlName string) {
+	key := util.GenerateRandomToken(10)
+	limit := RateLimit{3, 300 * time.Millisecond}
+	for i := 0; i < limit.Requests; i++ {
+		if ok, _, err := rl.Allow(key, limit); err != nil || !ok {
+			t.Fatalf("%s rejected request %d: %s", rlName, i, err)
+		}
+	}
+	ok, wait, err := rl.Allow(key, limit)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", rlName, err)
+	}
+	if ok || wait <= 0 || wait > limit.Period {
+		t.Fatalf("%s allowed a request over the limit (wait %s)", rlName, wait)
+	}
+	if ok, _, err = rl.Allow(util.GenerateRandomToken(10), limit); err != nil || !ok {
+		t.Fatalf("%s rejected a request for a different key: %s", rlName, err)
+	}
+	time.Sleep(wait)
+	if ok, _, err = rl.Allow(key, limit); err != nil || !ok {
+		t.Fatalf("%s did not refill the bucket after %s: %s", rlName, wait, err)
+	}
+}
+
+func TestMemoryRateLimitManager(t *testing.T) {
+	testRateLimitManager(NewRateLimitMgrMemory(), t, "memory")
+}
+
+func TestRedisRateLimitManager(t *testing.T) {
+	testRateLimitManager(NewRateLimitMgrRedis(redisPool, 10), t, "redis")
+}
@@ -2,6 +2,7 @@ package managers
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/gob"
 	"time"
@@ -18,6 +19,14 @@ type Session struct {
 	LastAccess   time.Time `json:"last_access"`
 	StoreToken   string    `json:"-"`
 	LastIp       string    `json:"last_ip"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// The session id is the bearer token so it cannot be shown when listing sessions. This id
+// identifies the session without giving access to it.
+func (s *Session) PublicId() string {
+	h := sha256.Sum256([]byte(s.Id))
+	return base64.RawURLEncoding.EncodeToString(h[:12])
 }
 
 func encodeSession(buf *bytes.Buffer, s *Session) error {
@@ -45,4 +54,5 @@ type SessionMgr interface {
 	DeleteSession(id string) error
 	GetAllSessions(userId string) ([]*Session, error)
 	DeleteAllSessions(userId string) error
+	PurgeExpiredSessions() error
 }
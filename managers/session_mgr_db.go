@@ -10,10 +10,11 @@ import (
 
 type sessionMgrDB struct {
 	dbp *sql.DB
+	ttl SessionTTL
 }
 
-func NewSessionMgrDB(dbp *sql.DB) SessionMgr {
-	return sessionMgrDB{dbp}
+func NewSessionMgrDB(dbp *sql.DB, ttl SessionTTL) SessionMgr {
+	return sessionMgrDB{dbp, ttl}
 }
 
 func (r sessionMgrDB) doTx(ftor func(*sql.Tx) error) error {
@@ -37,9 +38,10 @@ func (r sessionMgrDB) doTx(ftor func(*sql.Tx) error) error {
 }
 
 func (r sessionMgrDB) NewSession(userId, ip, agent string, csrf bool) (*Session, error) {
-	o := Session{util.GenerateRandomToken(15), userId, agent, csrf, time.Now().UTC(), util.GenerateRandomToken(15), ip}
+	now := time.Now().UTC()
+	o := Session{util.GenerateRandomToken(15), userId, agent, csrf, now, util.GenerateRandomToken(15), ip, now}
 	err := r.doTx(func(tx *sql.Tx) error {
-		_, err := r.dbp.Exec("INSERT INTO \"session\" "+insertSessionFields+" VALUES "+insertSessionBinds, o.Id, o.User, o.Agent, o.RequiresCSRF, o.LastAccess, o.StoreToken, o.LastIp)
+		_, err := r.dbp.Exec("INSERT INTO \"session\" "+insertSessionFields+" VALUES "+insertSessionBinds, o.Id, o.User, o.Agent, o.RequiresCSRF, o.LastAccess, o.StoreToken, o.LastIp, o.CreatedAt)
 		return err
 	})
 	if err == nil {
@@ -54,24 +56,40 @@ func (r sessionMgrDB) NewSession(userId, ip, agent string, csrf bool) (*Session,
 func (r sessionMgrDB) GetSession(id string) (*Session, error) {
 	o := &Session{}
 	row := r.dbp.QueryRow("SELECT "+selectSessionFields+" FROM \"session\" WHERE "+findSessionCondition, id)
-	return o, o.dbScanRow(row)
+	if err := o.dbScanRow(row); err != nil {
+		return nil, err
+	}
+	if r.ttl.expired(o, time.Now().UTC()) {
+		return nil, util.NewErrorFrom(ErrSessionExpired)
+	}
+	return o, nil
 }
 
 func (r sessionMgrDB) UpdateSession(id, ip, agent string) (*Session, error) {
 	o := &Session{Id: id}
-	return o, r.doTx(func(tx *sql.Tx) error {
+	expired := false
+	err := r.doTx(func(tx *sql.Tx) error {
 		if err := o.dbFind(tx); err != nil {
 			if util.CheckErr(err, sql.ErrNoRows) {
 				return util.NewErrorFrom(models.ErrDoesntExist)
 			}
 			return err
 		}
+		now := time.Now().UTC()
+		if expired = r.ttl.expired(o, now); expired {
+			_, err := o.dbDelete(tx)
+			return err
+		}
 		o.Agent = agent
-		o.LastAccess = time.Now().UTC()
+		o.LastAccess = now
 		o.LastIp = ip
 		_, err := o.dbUpdate(tx)
 		return err
 	})
+	if err == nil && expired {
+		return nil, util.NewErrorFrom(ErrSessionExpired)
+	}
+	return o, err
 }
 
 func (r sessionMgrDB) DeleteSession(id string) error {
@@ -95,7 +113,33 @@ func (r sessionMgrDB) GetAllSessions(userId string) ([]*Session, error) {
 	if err != nil {
 		panic(err)
 	}
-	return scanSessions(rows)
+	all, err := scanSessions(rows)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	ses := make([]*Session, 0, len(all))
+	for _, s := range all {
+		if !r.ttl.expired(s, now) {
+			ses = append(ses, s)
+		}
+	}
+	return ses, nil
+}
+
+func (r sessionMgrDB) PurgeExpiredSessions() error {
+	now := time.Now().UTC()
+	if r.ttl.Idle > 0 {
+		if _, err := r.dbp.Exec("DELETE FROM \"session\" WHERE \"last_access\" < $1", now.Add(-r.ttl.Idle)); err != nil {
+			return util.NewErrorFrom(err)
+		}
+	}
+	if r.ttl.Absolute > 0 {
+		if _, err := r.dbp.Exec("DELETE FROM \"session\" WHERE \"created_at\" < $1", now.Add(-r.ttl.Absolute)); err != nil {
+			return util.NewErrorFrom(err)
+		}
+	}
+	return nil
 }
 
 func (r sessionMgrDB) purgeAllData() {
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/nacl/box"
@@ -109,7 +110,7 @@ func getDummyUser() *models.User {
 	uid := "u_" + util.GenerateRandomToken(10)
 	_, priv, fullpack := generateNewKeys()
 	vkp := getDummyVaultKeyPair(priv, uid)
-	u, _, err := models.NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, fullpack, vkp)
+	u, _, _, err := models.NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, fullpack, vkp)
 	if err != nil {
 		panic(err)
 	}
@@ -201,7 +202,50 @@ func testSessionManager(rs SessionMgr, t *testing.T, smName string) {
 		}
 	}
 }
+func testSessionExpiry(rs SessionMgr, t *testing.T, smName string) {
+	uid := getDummyUser().Id
+	s1, err := rs.NewSession(uid, "1.1.1.1", "s1", false)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	s2, err := rs.NewSession(uid, "1.1.1.1", "s2", false)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	//Keep s1 alive until it hits the absolute lifetime while s2 idles out
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if _, err = rs.UpdateSession(s1.Id, "1.1.1.1", "s1"); err != nil {
+			t.Fatalf("%s expired an active session: %s", smName, err)
+		}
+	}
+	if _, err = rs.UpdateSession(s2.Id, "1.1.1.1", "s2"); !util.CheckErr(err, ErrSessionExpired) && !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("%s did not expire an idle session: %s", smName, err)
+	}
+	for i := 0; i < 7; i++ {
+		time.Sleep(100 * time.Millisecond)
+		rs.UpdateSession(s1.Id, "1.1.1.1", "s1")
+	}
+	if _, err = rs.UpdateSession(s1.Id, "1.1.1.1", "s1"); !util.CheckErr(err, ErrSessionExpired) && !util.CheckErr(err, models.ErrDoesntExist) {
+		t.Fatalf("%s did not expire a session past its lifetime: %s", smName, err)
+	}
+	if err = rs.PurgeExpiredSessions(); err != nil {
+		t.Fatalf("%s could not purge sessions: %s", smName, err)
+	}
+	ses, err := rs.GetAllSessions(uid)
+	if err != nil {
+		t.Fatalf("%s failed test: %s", smName, err)
+	}
+	if len(ses) != 0 {
+		t.Fatalf("%s still has %d sessions after they expired", smName, len(ses))
+	}
+}
+
 func TestDBSessionManager(t *testing.T) {
-	rs := NewSessionMgrDB(mdb)
+	rs := NewSessionMgrDB(mdb, SessionTTL{})
 	testSessionManager(rs, t, "db")
 }
+
+func TestDBSessionExpiry(t *testing.T) {
+	testSessionExpiry(NewSessionMgrDB(mdb, SessionTTL{Idle: 200 * time.Millisecond, Absolute: time.Second}), t, "db")
+}
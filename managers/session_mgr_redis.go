@@ -14,14 +14,16 @@ type sessionMgrRedis struct {
 	prefix string
 	dbId   string
 	pool   *radix.Pool
+	ttl    SessionTTL
 }
 
-func NewSessionMgrRedis(connUrl string, dbId int) (SessionMgr, error) {
-	pool, err := radix.NewPool("tcp", connUrl, 10, nil)
-	if err != nil {
-		return nil, err
-	}
-	return sessionMgrRedis{"kc-", strconv.Itoa(dbId), pool}, nil
+// The pool can be shared with other redis backed managers
+func NewRedisPool(connUrl string) (*radix.Pool, error) {
+	return radix.NewPool("tcp", connUrl, 10, nil)
+}
+
+func NewSessionMgrRedis(pool *radix.Pool, dbId int, ttl SessionTTL) SessionMgr {
+	return sessionMgrRedis{"kc-", strconv.Itoa(dbId), pool, ttl}
 }
 
 func (r sessionMgrRedis) skey(i string) string {
@@ -33,23 +35,22 @@ func (r sessionMgrRedis) ukey(i string) string {
 }
 
 func (r sessionMgrRedis) NewSession(userId, ip, agent string, csrf bool) (*Session, error) {
-	s := &Session{util.GenerateRandomToken(15), userId, agent, csrf, time.Now().UTC(), util.GenerateRandomToken(15), ip}
-	b := util.BufPool.Get()
-	defer util.BufPool.Put(b)
-	if err := encodeSession(b, s); err != nil {
-		return nil, err
-	}
-	p := radix.Pipeline(
-		radix.Cmd(nil, "SELECT", r.dbId),
-		radix.Cmd(nil, "SET", r.skey(s.Id), b.String()),
-		radix.Cmd(nil, "SADD", r.ukey(s.User), s.Id),
-	)
-	if err := r.pool.Do(p); err != nil {
+	now := time.Now().UTC()
+	s := &Session{util.GenerateRandomToken(15), userId, agent, csrf, now, util.GenerateRandomToken(15), ip, now}
+	if err := r.storeSession(s); err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
+// Redis drops the session by itself once it expires
+func (r sessionMgrRedis) setSessionCmd(s *Session, data string, left time.Duration) radix.CmdAction {
+	if left > 0 {
+		return radix.Cmd(nil, "SET", r.skey(s.Id), data, "PX", strconv.FormatInt(int64(left/time.Millisecond)+1, 10))
+	}
+	return radix.Cmd(nil, "SET", r.skey(s.Id), data)
+}
+
 func (r sessionMgrRedis) purgeAllData() error {
 	s := radix.NewScanner(r.pool, radix.ScanOpts{Command: "SCAN", Pattern: r.prefix + "*"})
 	var key string
@@ -82,19 +83,36 @@ func (r sessionMgrRedis) getSession(id string) (*Session, error) {
 	if err := decodeSession(b, s); err != nil {
 		return nil, err
 	}
+	//Sessions stored before sessions had a creation time
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = s.LastAccess
+	}
+	if r.ttl.expired(s, time.Now().UTC()) {
+		if err := r.delete(s); err != nil {
+			return nil, err
+		}
+		return nil, util.NewErrorFrom(ErrSessionExpired)
+	}
 	return s, nil
 }
 
 func (r sessionMgrRedis) storeSession(s *Session) error {
+	left := r.ttl.remaining(s, time.Now().UTC())
+	if left <= 0 && (r.ttl.Idle > 0 || r.ttl.Absolute > 0) {
+		//Storing it again would keep it around without an expiry
+		if err := r.delete(s); err != nil {
+			return err
+		}
+		return util.NewErrorFrom(ErrSessionExpired)
+	}
 	b := util.BufPool.Get()
 	defer util.BufPool.Put(b)
-	s.LastAccess = time.Now()
 	if err := encodeSession(b, s); err != nil {
 		return err
 	}
 	p := radix.Pipeline(
 		radix.Cmd(nil, "SELECT", r.dbId),
-		radix.Cmd(nil, "SET", r.skey(s.Id), b.String()),
+		r.setSessionCmd(s, b.String(), left),
 		radix.Cmd(nil, "SADD", r.ukey(s.User), s.Id),
 	)
 	if err := r.pool.Do(p); err != nil {
@@ -169,22 +187,29 @@ func (r sessionMgrRedis) GetAllSessions(userId string) ([]*Session, error) {
 	if err := r.pool.Do(p); err != nil {
 		return nil, err
 	}
-	ses := make([]*Session, len(sids))
-	b := util.BufPool.Get()
-	defer util.BufPool.Put(b)
-	for i, sid := range sids {
-		b.Reset()
-		p = radix.Pipeline(
-			radix.Cmd(nil, "SELECT", r.dbId),
-			radix.Cmd(b, "GET", r.skey(sid)),
-		)
-		if err := r.pool.Do(p); err != nil {
-			return nil, err
-		}
-		ses[i] = &Session{}
-		if err := decodeSession(b, ses[i]); err != nil {
+	ses := make([]*Session, 0, len(sids))
+	for _, sid := range sids {
+		s, err := r.getSession(sid)
+		switch {
+		case util.CheckErr(err, models.ErrDoesntExist):
+			//The session expired so drop it from the user set
+			p = radix.Pipeline(
+				radix.Cmd(nil, "SELECT", r.dbId),
+				radix.Cmd(nil, "SREM", r.ukey(userId), sid),
+			)
+			if err := r.pool.Do(p); err != nil {
+				return nil, err
+			}
+		case util.CheckErr(err, ErrSessionExpired):
+		case err != nil:
 			return nil, err
+		default:
+			ses = append(ses, s)
 		}
 	}
 	return ses, nil
 }
+
+func (r sessionMgrRedis) PurgeExpiredSessions() error {
+	return nil
+}
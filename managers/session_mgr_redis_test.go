@@ -1,21 +1,54 @@
 package managers
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+var redisPool *radix.Pool
 
 func init() {
-	rs, err := NewSessionMgrRedis("localhost:6379", 10)
+	var err error
+	redisPool, err = NewRedisPool("localhost:6379")
 	if err != nil {
 		panic(err)
 	}
+	rs := NewSessionMgrRedis(redisPool, 10, SessionTTL{})
 	if err = rs.(sessionMgrRedis).purgeAllData(); err != nil {
 		panic(err)
 	}
 }
 
 func TestRedisSessionManager(t *testing.T) {
-	rs, err := NewSessionMgrRedis("localhost:6379", 10)
+	testSessionManager(NewSessionMgrRedis(redisPool, 10, SessionTTL{}), t, "redis")
+}
+
+func TestRedisSessionExpiry(t *testing.T) {
+	testSessionExpiry(NewSessionMgrRedis(redisPool, 10, SessionTTL{Idle: 200 * time.Millisecond, Absolute: time.Second}), t, "redis")
+}
+
+func TestRedisExpiredSessionIsNotStored(t *testing.T) {
+	rs := NewSessionMgrRedis(redisPool, 10, SessionTTL{Idle: time.Minute, Absolute: time.Hour}).(sessionMgrRedis)
+	s, err := rs.NewSession("expired", "1.1.1.1", "agent", false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	testSessionManager(rs, t, "redis")
+	s.CreatedAt = s.CreatedAt.Add(-2 * time.Hour)
+	if err = rs.storeSession(s); !util.CheckErr(err, ErrSessionExpired) {
+		t.Fatalf("Expected %s and got %v", ErrSessionExpired, err)
+	}
+	var exists int
+	p := radix.Pipeline(
+		radix.Cmd(nil, "SELECT", rs.dbId),
+		radix.Cmd(&exists, "EXISTS", rs.skey(s.Id)),
+	)
+	if err = rs.pool.Do(p); err != nil {
+		t.Fatal(err)
+	}
+	if exists != 0 {
+		t.Fatal("Expired session was stored again")
+	}
 }
@@ -0,0 +1,36 @@
+package managers
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrSessionExpired = errors.New("Session expired")
+
+// Sessions expire after being idle for Idle or after Absolute since they were created.
+// A zero duration disables that limit.
+type SessionTTL struct {
+	Idle     time.Duration
+	Absolute time.Duration
+}
+
+func (t SessionTTL) expired(s *Session, now time.Time) bool {
+	if t.Idle > 0 && now.After(s.LastAccess.Add(t.Idle)) {
+		return true
+	}
+	return t.Absolute > 0 && now.After(s.CreatedAt.Add(t.Absolute))
+}
+
+// Time until the session expires if it isn't used again. Zero means it never expires.
+func (t SessionTTL) remaining(s *Session, now time.Time) time.Duration {
+	var left time.Duration
+	if t.Idle > 0 {
+		left = s.LastAccess.Add(t.Idle).Sub(now)
+	}
+	if t.Absolute > 0 {
+		if abs := s.CreatedAt.Add(t.Absolute).Sub(now); left == 0 || abs < left {
+			left = abs
+		}
+	}
+	return left
+}
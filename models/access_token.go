@@ -0,0 +1,208 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const accessTokenPrefix = "kcat"
+
+var MAX_ACCESS_TOKEN_TTL = 365 * 24 * time.Hour
+
+// Personal access tokens are limited to the teams and vaults in Scopes. Each scope is
+// either a team id, giving access to the whole team, or team/vault for a single vault.
+type AccessToken struct {
+	Id         string         `scaneo:"pk" json:"id"`
+	User       string         `json:"-"`
+	Name       string         `json:"name"`
+	HashSecret []byte         `json:"-"`
+	StoreToken string         `json:"-"`
+	Scopes     pq.StringArray `json:"scopes"`
+	ReadOnly   bool           `json:"read_only"`
+	ExpiresAt  time.Time      `json:"expires_at"`
+	LastUsedAt pq.NullTime    `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+func hashAccessTokenSecret(secret string) []byte {
+	h := sha256.Sum256([]byte(secret))
+	return h[:]
+}
+
+func IsAccessToken(bearer string) bool {
+	return strings.HasPrefix(bearer, accessTokenPrefix+".")
+}
+
+// Checks that all the scopes point to teams and vaults the user has access to
+func (u *User) checkAccessTokenScopes(ctx context.Context, scopes []string) error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(scopes) == 0 {
+		errs.SetFieldError("access_token_scopes", "missing")
+	}
+	for _, scope := range scopes {
+		parts := strings.Split(scope, "/")
+		if len(parts) > 2 || len(parts[0]) == 0 {
+			errs.SetFieldError("access_token_scopes", "invalid")
+			continue
+		}
+		t, err := u.GetTeam(ctx, parts[0])
+		if util.CheckErr(err, ErrDoesntExist) {
+			errs.SetFieldError("access_token_scopes", "invalid")
+			continue
+		} else if err != nil {
+			return err
+		}
+		if len(parts) == 1 {
+			continue
+		}
+		if _, err = t.GetVaultForUser(ctx, parts[1], u); util.CheckErr(err, ErrDoesntExist) {
+			errs.SetFieldError("access_token_scopes", "invalid")
+		} else if err != nil {
+			return err
+		}
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+// Returns the bearer token to use with the access token. Only its hash is stored so it cannot be retrieved later.
+func (u *User) NewAccessToken(ctx context.Context, name string, scopes []string, readOnly bool, expiresAt time.Time) (at *AccessToken, bearer string, err error) {
+	if err := u.checkAccessTokenScopes(ctx, scopes); err != nil {
+		return nil, "", err
+	}
+	secret := util.GenerateRandomToken(32)
+	at = &AccessToken{
+		Id:         util.GenerateRandomToken(16),
+		User:       u.Id,
+		Name:       name,
+		HashSecret: hashAccessTokenSecret(secret),
+		StoreToken: util.GenerateRandomToken(15),
+		Scopes:     pq.StringArray(scopes),
+		ReadOnly:   readOnly,
+		ExpiresAt:  expiresAt.UTC(),
+	}
+	return at, strings.Join([]string{accessTokenPrefix, at.Id, secret}, "."), doTx(ctx, func(tx *sql.Tx) error {
+		return at.insert(tx)
+	})
+}
+
+func (at *AccessToken) insert(tx *sql.Tx) error {
+	if err := at.validate(); err != nil {
+		return err
+	}
+	at.CreatedAt = time.Now().UTC()
+	at.UpdatedAt = at.CreatedAt
+	_, err := at.dbInsert(tx)
+	if IsDuplicateErr(err) {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+func (at *AccessToken) update(tx *sql.Tx) error {
+	if err := at.validate(); err != nil {
+		return err
+	}
+	at.UpdatedAt = time.Now().UTC()
+	res, err := at.dbUpdate(tx)
+	return treatUpdateErr(res, err)
+}
+
+func (at *AccessToken) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(at.Id) == 0 {
+		errs.SetFieldError("access_token_id", "missing")
+	}
+	if !reValidUsername.MatchString(at.User) {
+		errs.SetFieldError("access_token_user", "invalid")
+	}
+	if len(at.Name) == 0 {
+		errs.SetFieldError("access_token_name", "invalid")
+	}
+	if len(at.Scopes) == 0 {
+		errs.SetFieldError("access_token_scopes", "missing")
+	}
+	if at.ExpiresAt.IsZero() || at.ExpiresAt.After(time.Now().UTC().Add(MAX_ACCESS_TOKEN_TTL)) {
+		errs.SetFieldError("access_token_expires_at", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (at *AccessToken) expired() bool {
+	return time.Now().UTC().After(at.ExpiresAt)
+}
+
+// Whole team access is required when vid is empty
+func (at *AccessToken) Allows(tid, vid string) bool {
+	for _, scope := range at.Scopes {
+		if scope == tid || (len(vid) > 0 && scope == tid+"/"+vid) {
+			return true
+		}
+	}
+	return false
+}
+
+// Any scope in the team
+func (at *AccessToken) AllowsAnyInTeam(tid string) bool {
+	for _, scope := range at.Scopes {
+		if scope == tid || strings.HasPrefix(scope, tid+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func FindAccessToken(ctx context.Context, bearer string) (at *AccessToken, err error) {
+	parts := strings.Split(bearer, ".")
+	if len(parts) != 3 || parts[0] != accessTokenPrefix {
+		return nil, util.NewErrorFrom(ErrUnauthorized)
+	}
+	at = &AccessToken{Id: parts[1]}
+	return at, doTx(ctx, func(tx *sql.Tx) error {
+		err := at.dbFind(tx)
+		if isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrUnauthorized)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if subtle.ConstantTimeCompare(at.HashSecret, hashAccessTokenSecret(parts[2])) != 1 || at.expired() {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		at.LastUsedAt = pq.NullTime{Time: time.Now().UTC(), Valid: true}
+		return at.update(tx)
+	})
+}
+
+func (u *User) GetAccessTokens(ctx context.Context) (ats []*AccessToken, err error) {
+	return ats, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectAccessTokenFields+` FROM "access_token" WHERE "user" = $1 ORDER BY "created_at"`, u.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		ats, err = scanAccessTokens(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+func (u *User) deleteAccessTokens(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM "access_token" WHERE "user" = $1`, u.Id); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+func (u *User) DeleteAccessToken(ctx context.Context, id string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "access_token" WHERE "id" = $1 AND "user" = $2`, id, u.Id)
+		return treatUpdateErr(res, err)
+	})
+}
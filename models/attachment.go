@@ -0,0 +1,258 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+const (
+	MAX_ATTACHMENT_META_LENGTH = 4096
+	MAX_ATTACHMENT_CHUNK_SIZE  = 4 * 1024 * 1024
+)
+
+// Files attached to a secret. The client encrypts every chunk and the metadata with a key kept in the
+// secret data, so rotating the vault keys does not require uploading them again. The chunks live in a
+// blob store and the attachment keeps their keys and sizes so clients can split the downloaded stream.
+// Attachments are not removed by the database when their secret, vault or team goes away. Those
+// orphans are returned by GetOrphanAttachments so their chunks can be removed from the blob store too.
+type Attachment struct {
+	Id         string         `scaneo:"pk" json:"id"`
+	Team       string         `json:"-"`
+	Vault      string         `json:"vault"`
+	Secret     string         `json:"secret"`
+	Meta       []byte         `json:"meta"`
+	Size       int64          `json:"size"`
+	ChunkSizes pq.Int64Array  `json:"chunk_sizes"`
+	ChunkKeys  pq.StringArray `json:"-"`
+	Complete   bool           `json:"complete"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+}
+
+// Every upload of a chunk gets its own key in the blob store so a rejected upload can be removed
+// without touching the chunks already stored
+func (a *Attachment) NewChunkKey(index int) string {
+	return fmt.Sprintf("%s/%d.%s", a.Id, index, util.GenerateRandomToken(8))
+}
+
+func (a *Attachment) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(a.Id) == 0 {
+		errs.SetFieldError("attachment_id", "missing")
+	}
+	if len(a.Team) == 0 || len(a.Vault) == 0 || len(a.Secret) == 0 {
+		errs.SetFieldError("attachment_secret", "missing")
+	}
+	if len(a.Meta) > MAX_ATTACHMENT_META_LENGTH {
+		errs.SetFieldError("attachment_meta", "too long")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (a *Attachment) insert(tx *sql.Tx) error {
+	a.Id = util.GenerateRandomToken(16)
+	a.ChunkSizes = pq.Int64Array{}
+	a.ChunkKeys = pq.StringArray{}
+	if a.Meta == nil {
+		a.Meta = []byte{}
+	}
+	if err := a.validate(); err != nil {
+		return err
+	}
+	a.CreatedAt = time.Now().UTC()
+	a.UpdatedAt = a.CreatedAt
+	_, err := a.dbInsert(tx)
+	if IsDuplicateErr(err) {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+func (a *Attachment) update(tx *sql.Tx) error {
+	if err := a.validate(); err != nil {
+		return err
+	}
+	a.UpdatedAt = time.Now().UTC()
+	res, err := a.dbUpdate(tx)
+	return treatUpdateErr(res, err)
+}
+
+// Starts an attachment for a secret. The chunks are uploaded afterwards with AddAttachmentChunk.
+func (v *Vault) AddAttachment(ctx context.Context, sid string, meta []byte) (a *Attachment, err error) {
+	a = &Attachment{Team: v.Team, Vault: v.Id, Secret: sid, Meta: meta}
+	return a, doTx(ctx, func(tx *sql.Tx) error {
+		if _, err := v.getSecret(tx, sid); err != nil {
+			return err
+		}
+		return a.insert(tx)
+	})
+}
+
+func (v Vault) GetAttachments(ctx context.Context, sid string) (atts []*Attachment, err error) {
+	return atts, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectAttachmentFields+` FROM "attachment" WHERE "team" = $1 AND "vault" = $2 AND "secret" = $3 ORDER BY "created_at"`, v.Team, v.Id, sid)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		atts, err = scanAttachments(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+func (v Vault) GetAttachment(ctx context.Context, sid, aid string) (a *Attachment, err error) {
+	return a, doTx(ctx, func(tx *sql.Tx) error {
+		a, err = v.getAttachment(tx, sid, aid, false)
+		return err
+	})
+}
+
+func (v Vault) getAttachment(tx *sql.Tx, sid, aid string, forUpdate bool) (*Attachment, error) {
+	query := `SELECT ` + selectAttachmentFields + ` FROM "attachment" WHERE "id" = $1 AND "team" = $2 AND "vault" = $3 AND "secret" = $4`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	a := &Attachment{}
+	if err := a.dbScanRow(tx.QueryRow(query, aid, v.Team, v.Id, sid)); isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	} else if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return a, nil
+}
+
+// Records a chunk that has been stored in the blob store. Chunks have to be added in order and count
+// towards the secret bytes limit of the team.
+func (v *Vault) AddAttachmentChunk(ctx context.Context, sid, aid string, index int, key string, size int64) (a *Attachment, err error) {
+	if size <= 0 || size > MAX_ATTACHMENT_CHUNK_SIZE {
+		errs := util.NewErrorFields().(*util.Error)
+		errs.SetFieldError("attachment_chunk", "invalid size")
+		return nil, errs.SetErrorOrCamo(ErrInvalidAttributes)
+	}
+	return a, doTx(ctx, func(tx *sql.Tx) error {
+		t, err := v.lockTeam(tx)
+		if err != nil {
+			return err
+		}
+		if a, err = v.getAttachment(tx, sid, aid, true); err != nil {
+			return err
+		}
+		if a.Complete || index != len(a.ChunkSizes) {
+			errs := util.NewErrorFields().(*util.Error)
+			errs.SetFieldError("attachment_chunk", "out of order")
+			return errs.SetErrorOrCamo(ErrInvalidAttributes)
+		}
+		if err := t.checkSecretsLimit(tx, 0, size); err != nil {
+			return err
+		}
+		a.ChunkSizes = append(a.ChunkSizes, size)
+		a.ChunkKeys = append(a.ChunkKeys, key)
+		a.Size += size
+		if err := a.update(tx); err != nil {
+			return err
+		}
+		return t.addSize(tx, size)
+	})
+}
+
+// Marks the upload as finished. No more chunks can be added afterwards.
+func (v *Vault) CompleteAttachment(ctx context.Context, sid, aid string) (a *Attachment, err error) {
+	return a, doTx(ctx, func(tx *sql.Tx) error {
+		if a, err = v.getAttachment(tx, sid, aid, true); err != nil {
+			return err
+		}
+		a.Complete = true
+		return a.update(tx)
+	})
+}
+
+// Removes the attachment from the database. The caller has to remove its chunks from the blob store.
+func (v *Vault) DeleteAttachment(ctx context.Context, sid, aid string) (a *Attachment, err error) {
+	return a, doTx(ctx, func(tx *sql.Tx) error {
+		t, err := v.lockTeam(tx)
+		if err != nil {
+			return err
+		}
+		if a, err = v.getAttachment(tx, sid, aid, true); err != nil {
+			return err
+		}
+		if err := treatUpdateErr(a.dbDelete(tx)); err != nil {
+			return err
+		}
+		return t.addSize(tx, -a.Size)
+	})
+}
+
+// Moves the attachments of a secret along with it. Moving to another team checks the limits of the
+// target team and updates the size of both teams.
+func moveAttachments(tx *sql.Tx, source, target *Vault, from, to string) error {
+	var size int64
+	r := tx.QueryRow(`SELECT COALESCE(SUM("size"), 0) FROM "attachment" WHERE "team" = $1 AND "vault" = $2 AND "secret" = $3`, source.Team, source.Id, from)
+	if err := r.Scan(&size); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	crossTeam := source.Team != target.Team
+	var tt *Team
+	if crossTeam {
+		var err error
+		if tt, err = target.lockTeam(tx); err != nil {
+			return err
+		}
+		if err := tt.checkSecretsLimit(tx, 0, size); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec(`UPDATE "attachment" SET "team" = $1, "vault" = $2, "secret" = $3 WHERE "team" = $4 AND "vault" = $5 AND "secret" = $6`, target.Team, target.Id, to, source.Team, source.Id, from)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if !crossTeam {
+		return nil
+	}
+	if err := tt.addSize(tx, size); err != nil {
+		return err
+	}
+	return (&Team{Id: source.Team}).addSize(tx, -size)
+}
+
+// Returns the attachments whose secret no longer exists and the uploads that were never completed
+// and have not been touched since staleBefore
+func GetOrphanAttachments(ctx context.Context, staleBefore time.Time) (atts []*Attachment, err error) {
+	return atts, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`
+			SELECT `+selectAttachmentFields+` FROM "attachment" WHERE
+				NOT EXISTS (SELECT 1 FROM "secret" WHERE "secret"."team" = "attachment"."team" AND "secret"."vault" = "attachment"."vault" AND "secret"."id" = "attachment"."secret") OR
+				(NOT "attachment"."complete" AND "attachment"."updated_at" < $1)`, staleBefore)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		atts, err = scanAttachments(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// Removes an attachment whose chunks are already gone from the blob store
+func (a *Attachment) Purge(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		var size int64
+		r := tx.QueryRow(`DELETE FROM "attachment" WHERE "id" = $1 RETURNING "size"`, a.Id)
+		//Someone else may have purged it already
+		if err := r.Scan(&size); isNotExistsErr(err) {
+			return nil
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		//The team may have been deleted already
+		if err := (&Team{Id: a.Team}).addSize(tx, -size); err != nil && !util.CheckErr(err, ErrDoesntExist) {
+			return err
+		}
+		return nil
+	})
+}
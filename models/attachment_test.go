@@ -0,0 +1,90 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestAttachmentChunksAndMove(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.v.AddAttachment(ctx, "nonexistent", a32b); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	a, err := vm.v.AddAttachment(ctx, s.Id, a32b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vm.v.AddAttachmentChunk(ctx, s.Id, a.Id, 1, a.NewChunkKey(1), 10); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Added a chunk out of order: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if a, err = vm.v.AddAttachmentChunk(ctx, s.Id, a.Id, i, a.NewChunkKey(i), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.Size != 20 || len(a.ChunkKeys) != 2 || len(a.ChunkSizes) != 2 {
+		t.Fatalf("Unexpected attachment %#v", a)
+	}
+	nt, err := FindTeam(ctx, team.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nt.Size != len(s.Data)+20 {
+		t.Fatalf("Attachment was not counted in the team size: %d", nt.Size)
+	}
+	if a, err = vm.v.CompleteAttachment(ctx, s.Id, a.Id); err != nil || !a.Complete {
+		t.Fatalf("Could not complete the attachment: %s", err)
+	}
+	if _, err = vm.v.AddAttachmentChunk(ctx, s.Id, a.Id, 2, a.NewChunkKey(2), 10); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Added a chunk to a complete attachment: %s", err)
+	}
+	target := createVaultMock(o, createTeamMock(o))
+	s.Data = signAndPack(target.priv, a32b)
+	oldSid := s.Id
+	if err = MoveSecretToVault(ctx, s, vm.v, target.v); err != nil {
+		t.Fatal(err)
+	}
+	if atts, err := vm.v.GetAttachments(ctx, oldSid); err != nil || len(atts) != 0 {
+		t.Fatalf("Attachment stayed in the source vault: %d %s", len(atts), err)
+	}
+	atts, err := target.v.GetAttachments(ctx, s.Id)
+	if err != nil || len(atts) != 1 || atts[0].Id != a.Id {
+		t.Fatalf("Attachment did not move with its secret: %d %s", len(atts), err)
+	}
+	if nt, err = FindTeam(ctx, target.v.Team); err != nil || nt.Size != len(s.Data)+20 {
+		t.Fatalf("Target team size was not updated: %v %s", nt, err)
+	}
+	if _, err = target.v.DeleteSecret(ctx, s.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = target.v.PurgeSecret(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := GetOrphanAttachments(ctx, time.Now().UTC().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, o := range orphans {
+		if o.Id == a.Id {
+			found = true
+			if err = o.Purge(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("Attachment of a purged secret is not an orphan")
+	}
+	if nt, err = FindTeam(ctx, target.v.Team); err != nil || nt.Size != 0 {
+		t.Fatalf("Target team size was not updated after the purge: %v %s", nt, err)
+	}
+}
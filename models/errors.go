@@ -14,4 +14,11 @@ var (
 	ErrInvalidSignature  = errors.New("Invalid signature")
 	ErrInvalidPublicKey  = errors.New("Invalid public key length")
 	ErrInvalidAttributes = errors.New("Invalid attributes")
+	ErrAccountLocked     = errors.New("Account locked")
+	ErrOwnsSharedTeams   = errors.New("Transfer the ownership of the shared teams first")
+	ErrSecretsMismatch   = errors.New("Secrets do not match the ones in the vault")
+	ErrLastVault         = errors.New("Teams need at least one vault")
+	ErrPlanLimitReached  = errors.New("Team plan limit reached")
+	ErrVersionConflict   = errors.New("Secret was changed since the expected version")
+	ErrResyncRequired    = errors.New("Changes since that vault version were purged")
 )
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Subject at an OpenID Connect provider that can sign in as the user. A user can only be linked
+// to one subject per provider.
+type OidcIdentity struct {
+	Issuer    string    `scaneo:"pk" json:"issuer"`
+	Subject   string    `scaneo:"pk" json:"subject"`
+	User      string    `json:"-"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (oi *OidcIdentity) insert(tx *sql.Tx) error {
+	if err := oi.validate(); err != nil {
+		return err
+	}
+	oi.CreatedAt = time.Now().UTC()
+	oi.UpdatedAt = oi.CreatedAt
+	_, err := oi.dbInsert(tx)
+	if IsDuplicateErr(err) {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+func (oi *OidcIdentity) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(oi.Issuer) == 0 {
+		errs.SetFieldError("oidc_issuer", "missing")
+	}
+	if len(oi.Subject) == 0 {
+		errs.SetFieldError("oidc_subject", "missing")
+	}
+	if !reValidUsername.MatchString(oi.User) {
+		errs.SetFieldError("oidc_user", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (u *User) LinkOidcIdentity(ctx context.Context, issuer, subject, email string) (oi *OidcIdentity, err error) {
+	oi = &OidcIdentity{Issuer: issuer, Subject: subject, User: u.Id, Email: email}
+	return oi, doTx(ctx, func(tx *sql.Tx) error {
+		return oi.insert(tx)
+	})
+}
+
+func (u *User) GetOidcIdentities(ctx context.Context) (ois []*OidcIdentity, err error) {
+	return ois, doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectOidcIdentityFields+` FROM "oidc_identity" WHERE "user" = $1 ORDER BY "created_at"`, u.Id)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		ois, err = scanOidcIdentitys(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+func (u *User) UnlinkOidcIdentity(ctx context.Context, issuer string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "oidc_identity" WHERE "user" = $1 AND "issuer" = $2`, u.Id, issuer)
+		return treatUpdateErr(res, err)
+	})
+}
+
+func FindUserByOidcIdentity(ctx context.Context, issuer, subject string) (u *User, err error) {
+	return u, doTx(ctx, func(tx *sql.Tx) error {
+		oi := &OidcIdentity{Issuer: issuer, Subject: subject}
+		err := oi.dbFind(tx)
+		if isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		u, err = findUser(tx, oi.User)
+		return err
+	})
+}
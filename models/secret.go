@@ -6,16 +6,18 @@ import (
 	"time"
 
 	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
 )
 
 type Secret struct {
-	Team         string    `scaneo:"pk" json:"-"`
-	Vault        string    `scaneo:"pk" json:"vault"`
-	Id           string    `scaneo:"pk" json:"id"`
-	Version      uint32    `json:"version"`
-	Data         []byte    `json:"data"`
-	VaultVersion uint32    `json:"vault_version"`
-	CreatedAt    time.Time `json:"created_at"`
+	Team         string      `scaneo:"pk" json:"-"`
+	Vault        string      `scaneo:"pk" json:"vault"`
+	Id           string      `scaneo:"pk" json:"id"`
+	Version      uint32      `json:"version"`
+	Data         []byte      `json:"data"`
+	VaultVersion uint32      `json:"vault_version"`
+	CreatedAt    time.Time   `json:"created_at"`
+	DeletedAt    pq.NullTime `json:"deleted_at,omitempty"`
 }
 
 func (v *Secret) insert(tx *sql.Tx) error {
@@ -50,13 +52,20 @@ func (v *Secret) update(tx *sql.Tx) error {
 	return nil
 }
 
+// Removes the secret from the source vault and stores it as a new secret in the target along with its
+// attachments. Only a tombstone is left behind in the source vault so clients in sync drop the secret.
+// If s.Version is set it has to match the newest version of the secret in the source vault.
 func MoveSecretToVault(ctx context.Context, s *Secret, source, target *Vault) error {
 	return doTx(ctx, func(tx *sql.Tx) error {
-		if err := source.deleteSecret(tx, s.Id); err != nil {
+		if err := source.removeSecret(tx, s.Id, s.Version); err != nil {
 			return err
 		}
+		from := s.Id
 		s.Id = ""
-		return target.addSecret(tx, s)
+		if err := target.addSecret(tx, s); err != nil {
+			return err
+		}
+		return moveAttachments(tx, source, target, from, s.Id)
 	})
 }
 
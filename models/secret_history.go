@@ -0,0 +1,50 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Returns every stored version of a secret, newest first
+func (v Vault) GetSecretVersions(ctx context.Context, sid string) (secrets []*Secret, err error) {
+	return secrets, doTx(ctx, func(tx *sql.Tx) error {
+		secrets, err = v.getSecretVersions(tx, sid)
+		return err
+	})
+}
+
+func (v Vault) getSecretVersions(tx *sql.Tx, sid string) ([]*Secret, error) {
+	rows, err := tx.Query(`SELECT `+selectSecretFields+` FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND "id" = $3 ORDER BY "version" DESC`, v.Team, v.Id, sid)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	secrets, err := scanSecrets(rows)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	if len(secrets) == 0 {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	return secrets, nil
+}
+
+// Stores the data of an old version of the secret as its newest version. Versions stored before the
+// vault keys were rotated are signed with the old key and cannot be restored.
+func (v *Vault) RestoreSecretVersion(ctx context.Context, sid string, version uint32) (s *Secret, err error) {
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		old := &Secret{}
+		r := tx.QueryRow(`SELECT `+selectSecretFields+` FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND "id" = $3 AND "version" = $4`, v.Team, v.Id, sid, version)
+		if err := old.dbScanRow(r); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if _, err := verifyAndUnpack(v.PublicKey, old.Data); err != nil {
+			return err
+		}
+		s = &Secret{Id: sid, Data: old.Data}
+		return v.updateSecret(tx, s)
+	})
+}
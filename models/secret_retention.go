@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// Server wide retention of old secret versions. 0 keeps every version.
+var (
+	SECRET_KEEP_VERSIONS      = 0
+	SECRET_KEEP_VERSIONS_DAYS = 0
+)
+
+// Returns the smallest non zero value so a vault can only tighten the server retention
+func stricterRetention(server, vault int) int {
+	if vault > 0 && (server < 1 || vault < server) {
+		return vault
+	}
+	return server
+}
+
+// Sets how many versions of each secret are kept and for how many days. 0 falls back to the server
+// retention. Versions that fall out of the new policy are removed right away.
+func (v *Vault) SetVersionRetention(ctx context.Context, admin *User, keep, days int) error {
+	errs := util.NewErrorFields().(*util.Error)
+	if keep < 0 {
+		errs.SetFieldError("vault_keep_versions", "invalid")
+	}
+	if days < 0 {
+		errs.SetFieldError("vault_keep_versions_days", "invalid")
+	}
+	if err := errs.SetErrorOrCamo(ErrInvalidAttributes); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		t, err := v.lockTeam(tx)
+		if err != nil {
+			return err
+		}
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		v.KeepVersions = keep
+		v.KeepVersionsDays = days
+		v.UpdatedAt = time.Now().UTC()
+		res, err := tx.Exec(`UPDATE "vault" SET "keep_versions" = $1, "keep_versions_days" = $2, "updated_at" = $3 WHERE "team" = $4 AND "id" = $5`, v.KeepVersions, v.KeepVersionsDays, v.UpdatedAt, v.Team, v.Id)
+		if err := treatUpdateErr(res, err); err != nil {
+			return err
+		}
+		freed, err := v.pruneSecretVersions(tx, "")
+		if err != nil {
+			return err
+		}
+		return t.addSize(tx, -freed)
+	})
+}
+
+// Removes the versions that fall out of the retention of the vault. An empty sid prunes every secret in the
+// vault. The age of a version counts from when it was superseded by the next one, so old secrets that were
+// just changed keep their previous version. The newest version of a secret is never removed. Returns the
+// bytes freed. The caller has to lock the team and update its size.
+func (v *Vault) pruneSecretVersions(tx *sql.Tx, sid string) (freed int64, err error) {
+	//Read the policy from the database so a stale vault does not loosen it
+	var keep, days int
+	r := tx.QueryRow(`SELECT "keep_versions", "keep_versions_days" FROM "vault" WHERE "team" = $1 AND "id" = $2`, v.Team, v.Id)
+	if err := r.Scan(&keep, &days); isNotExistsErr(err) {
+		return 0, util.NewErrorFrom(ErrDoesntExist)
+	} else if isErrOrPanic(err) {
+		return 0, util.NewErrorFrom(err)
+	}
+	keep = stricterRetention(SECRET_KEEP_VERSIONS, keep)
+	days = stricterRetention(SECRET_KEEP_VERSIONS_DAYS, days)
+	if keep < 1 && days < 1 {
+		return 0, nil
+	}
+	cutoff := pq.NullTime{}
+	if days > 0 {
+		cutoff = pq.NullTime{Time: time.Now().UTC().Add(-time.Duration(days) * 24 * time.Hour), Valid: true}
+	}
+	r = tx.QueryRow(`
+		WITH "pruned" AS (DELETE FROM "secret" USING (
+			SELECT "id", "version",
+				ROW_NUMBER() OVER (PARTITION BY "id" ORDER BY "version" DESC) AS "rank",
+				LEAD("created_at") OVER (PARTITION BY "id" ORDER BY "version") AS "superseded_at"
+			FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND ($3::TEXT = '' OR "id" = $3::TEXT)
+		) AS "ranked"
+		WHERE "secret"."team" = $1 AND "secret"."vault" = $2 AND "secret"."id" = "ranked"."id" AND "secret"."version" = "ranked"."version" AND
+			"ranked"."rank" > 1 AND (($4::INT > 0 AND "ranked"."rank" > $4) OR "ranked"."superseded_at" < $5)
+			RETURNING OCTET_LENGTH("secret"."data") AS "size")
+		SELECT COALESCE(SUM("size"), 0) FROM "pruned"`, v.Team, v.Id, sid, keep, cutoff)
+	if err := r.Scan(&freed); isErrOrPanic(err) {
+		return 0, util.NewErrorFrom(err)
+	}
+	return freed, nil
+}
+
+// Applies the version retention to every vault that has one. Updates get pruned as they are stored so
+// this only catches versions that got too old since then.
+func PruneSecretVersions(ctx context.Context) error {
+	var vaults []*Vault
+	err := doTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT `+selectVaultFields+` FROM "vault" WHERE "keep_versions" > 0 OR "keep_versions_days" > 0 OR $1`, SECRET_KEEP_VERSIONS > 0 || SECRET_KEEP_VERSIONS_DAYS > 0)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vaults, err = scanVaults(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range vaults {
+		err := doTx(ctx, func(tx *sql.Tx) error {
+			t, err := v.lockTeam(tx)
+			if err != nil {
+				return err
+			}
+			freed, err := v.pruneSecretVersions(tx, "")
+			if err != nil {
+				return err
+			}
+			return t.addSize(tx, -freed)
+		})
+		//The vault or its team may have been deleted in the meantime
+		if err != nil && !util.CheckErr(err, ErrDoesntExist) {
+			return err
+		}
+	}
+	return nil
+}
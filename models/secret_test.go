@@ -2,6 +2,8 @@ package models
 
 import (
 	"testing"
+
+	"github.com/keydotcat/keycatd/util"
 )
 
 func TestGetAllSecretsForOwnerAndUser(t *testing.T) {
@@ -144,6 +146,19 @@ func TestMoveSecretToVault(t *testing.T) {
 	if secrets[0].Id == oldSid {
 		t.Fatal("Secret ID has not changed")
 	}
+	if _, err := vms[0].v.RestoreSecret(ctx, oldSid); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Moved secret could be restored from the source vault: %v", err)
+	}
+	if trash, err := vms[0].v.GetTrash(ctx); err != nil || len(trash) != 0 {
+		t.Fatalf("Moved secret is in the trash of the source vault: %d %v", len(trash), err)
+	}
+	changed, err := vms[0].v.GetSecretsSince(ctx, vms[0].v.Version-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].Id != oldSid || !changed[0].DeletedAt.Valid || len(changed[0].Data) != 0 {
+		t.Fatalf("Expected the tombstone of the moved secret and got %#v", changed)
+	}
 	secrets[2], secrets[0] = secrets[0], secrets[2]
 	for i, vm := range vms {
 		vs, err := vm.v.GetSecretsAllVersions(ctx)
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+	"github.com/lib/pq"
+)
+
+// Moves a secret to the trash of the vault. Every version of the secret is kept until it is purged so it
+// still counts towards the team limits. The returned tombstone carries the vault version of the removal.
+// A non zero version has to match the newest version of the secret or ErrVersionConflict is returned.
+func (v *Vault) DeleteSecret(ctx context.Context, sid string, version uint32) (s *Secret, err error) {
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		s, err = v.trashSecret(tx, sid, version)
+		return err
+	})
+}
+
+func (v *Vault) trashSecret(tx *sql.Tx, sid string, version uint32) (*Secret, error) {
+	s, err := v.getLatestSecret(tx, sid, false)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && version != s.Version {
+		return nil, util.NewErrorFrom(ErrVersionConflict)
+	}
+	s.DeletedAt = pq.NullTime{Time: time.Now().UTC(), Valid: true}
+	return s, v.setSecretDeletedAt(tx, s)
+}
+
+// Removes every version of a secret for good. A tombstone with the vault version of the removal is kept
+// so GetSecretsSince still reports it. It has no data so it cannot be restored from the trash.
+func (v *Vault) removeSecret(tx *sql.Tx, sid string, version uint32) error {
+	t, err := v.lockTeam(tx)
+	if err != nil {
+		return err
+	}
+	s, err := v.getLatestSecret(tx, sid, false)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != s.Version {
+		return util.NewErrorFrom(ErrVersionConflict)
+	}
+	if err := v.update(tx); err != nil {
+		return err
+	}
+	var freed sql.NullInt64
+	r := tx.QueryRow(`
+		WITH "removed" AS (DELETE FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND "id" = $3 RETURNING OCTET_LENGTH("data") AS "size")
+		SELECT SUM("size") FROM "removed"`, v.Team, v.Id, sid)
+	if err := r.Scan(&freed); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if !freed.Valid {
+		return util.NewErrorFrom(ErrDoesntExist)
+	}
+	_, err = tx.Exec(`INSERT INTO "secret_tombstone" ("team", "vault", "id", "vault_version", "deleted_at") VALUES ($1, $2, $3, $4, $5)`, v.Team, v.Id, sid, v.Version, time.Now().UTC())
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return t.addSize(tx, -freed.Int64)
+}
+
+// Returns the tombstones of the secrets removed from the vault after the given vault version
+func (v Vault) getTombstonesSince(tx *sql.Tx, version uint32) ([]*Secret, error) {
+	rows, err := tx.Query(`SELECT "id", "vault_version", "deleted_at" FROM "secret_tombstone" WHERE "team" = $1 AND "vault" = $2 AND "vault_version" > $3`, v.Team, v.Id, version)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	defer rows.Close()
+	secrets := []*Secret{}
+	for rows.Next() {
+		s := &Secret{Team: v.Team, Vault: v.Id}
+		if err := rows.Scan(&s.Id, &s.VaultVersion, &s.DeletedAt); isErrOrPanic(err) {
+			return nil, util.NewErrorFrom(err)
+		}
+		secrets = append(secrets, s)
+	}
+	if err := rows.Err(); isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	return secrets, nil
+}
+
+// Brings a secret back from the trash as it was when it was deleted
+func (v *Vault) RestoreSecret(ctx context.Context, sid string) (s *Secret, err error) {
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		if s, err = v.getLatestSecret(tx, sid, true); err != nil {
+			return err
+		}
+		s.DeletedAt = pq.NullTime{}
+		return v.setSecretDeletedAt(tx, s)
+	})
+}
+
+func (v *Vault) setSecretDeletedAt(tx *sql.Tx, s *Secret) error {
+	if _, err := v.lockTeam(tx); err != nil {
+		return err
+	}
+	if err := v.update(tx); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE "secret" SET "deleted_at" = $1 WHERE "team" = $2 AND "vault" = $3 AND "id" = $4`, s.DeletedAt, v.Team, v.Id, s.Id)
+	if err := treatUpdateErr(res, err); err != nil {
+		return err
+	}
+	//The newest version carries the vault version of the change so clients know when it happened
+	s.VaultVersion = v.Version
+	res, err = tx.Exec(`UPDATE "secret" SET "vault_version" = $1 WHERE "team" = $2 AND "vault" = $3 AND "id" = $4 AND "version" = $5`, s.VaultVersion, v.Team, v.Id, s.Id, s.Version)
+	return treatUpdateErr(res, err)
+}
+
+// Returns the newest version of every secret in the trash of the vault
+func (v Vault) GetTrash(ctx context.Context) (secrets []*Secret, err error) {
+	return secrets, doTx(ctx, func(tx *sql.Tx) error {
+		secrets, err = v.getLatestSecrets(tx, `AND "secret"."deleted_at" IS NOT NULL`)
+		return err
+	})
+}
+
+// Removes every version of a secret in the trash for good
+func (v *Vault) PurgeSecret(ctx context.Context, sid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		t, err := v.lockTeam(tx)
+		if err != nil {
+			return err
+		}
+		var purged, freed sql.NullInt64
+		r := tx.QueryRow(`
+			WITH "purged" AS (DELETE FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND "id" = $3 AND "deleted_at" IS NOT NULL RETURNING "vault_version", OCTET_LENGTH("data") AS "size")
+			SELECT MAX("vault_version"), SUM("size") FROM "purged"`, v.Team, v.Id, sid)
+		if err := r.Scan(&purged, &freed); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if !purged.Valid {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		res, err := tx.Exec(`UPDATE "vault" SET "purged_version" = GREATEST("purged_version", $1) WHERE "team" = $2 AND "id" = $3`, purged.Int64, v.Team, v.Id)
+		if err := treatUpdateErr(res, err); err != nil {
+			return err
+		}
+		return t.addSize(tx, -freed.Int64)
+	})
+}
+
+// Sets the purge horizon of the vaults with purged rows to the highest vault version purged
+const raisePurgedVersionQuery = `
+	"horizon" AS (
+		UPDATE "vault" SET "purged_version" = GREATEST("vault"."purged_version", "p"."version")
+		FROM (SELECT "team", "vault", MAX("vault_version") AS "version" FROM "purged" GROUP BY "team", "vault") AS "p"
+		WHERE "vault"."team" = "p"."team" AND "vault"."id" = "p"."vault"
+	)`
+
+// Removes for good the secrets that were moved to the trash before the given time along with the
+// tombstones of the secrets moved away since. Returns how many secrets were purged. Vaults remember the
+// newest change purged so clients that synced before it are told to fetch the whole vault again.
+func PurgeDeletedSecrets(ctx context.Context, before time.Time) (purged int64, err error) {
+	return purged, doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			WITH "purged" AS (DELETE FROM "secret_tombstone" WHERE "deleted_at" < $1 RETURNING "team", "vault", "vault_version"),`+raisePurgedVersionQuery+`
+			SELECT 1`, before)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		rows, err := tx.Query(`
+			WITH "purged" AS (DELETE FROM "secret" WHERE "deleted_at" < $1 RETURNING "team", "vault", "id", "vault_version", OCTET_LENGTH("data") AS "size"),`+raisePurgedVersionQuery+`
+			SELECT "team", COUNT(DISTINCT ("vault", "id")), SUM("size") FROM "purged" GROUP BY "team"`, before)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		counts := map[string]int64{}
+		freed := map[string]int64{}
+		for rows.Next() {
+			var tid string
+			var count, size int64
+			if err := rows.Scan(&tid, &count, &size); isErrOrPanic(err) {
+				rows.Close()
+				return util.NewErrorFrom(err)
+			}
+			counts[tid] = count
+			freed[tid] = size
+		}
+		if err := rows.Err(); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for tid, count := range counts {
+			if err := (&Team{Id: tid}).addSize(tx, -freed[tid]); err != nil {
+				return err
+			}
+			purged += count
+		}
+		return nil
+	})
+}
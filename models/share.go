@@ -0,0 +1,177 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+const (
+	MAX_SHARE_DATA_LENGTH = 64 * 1024
+	MAX_SHARE_VIEWS       = 100
+)
+
+var MAX_SHARE_TTL = 30 * 24 * time.Hour
+
+// Links to hand a secret to someone without an account. The client encrypts the data with a key that
+// only travels in the fragment of the link so the server never sees it. Anyone with the id can open
+// the share until it expires or has been opened MaxViews times. Then it is removed for good.
+type Share struct {
+	Id        string    `scaneo:"pk" json:"id"`
+	Team      string    `json:"-"`
+	Creator   string    `json:"creator"`
+	Data      []byte    `json:"-"`
+	MaxViews  int       `json:"max_views"`
+	Views     int       `json:"views"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *Share) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(s.Id) == 0 {
+		errs.SetFieldError("share_id", "missing")
+	}
+	if len(s.Data) == 0 || len(s.Data) > MAX_SHARE_DATA_LENGTH {
+		errs.SetFieldError("share_data", "invalid")
+	}
+	if s.MaxViews < 1 || s.MaxViews > MAX_SHARE_VIEWS {
+		errs.SetFieldError("share_max_views", "invalid")
+	}
+	now := time.Now().UTC()
+	if !s.ExpiresAt.After(now) || s.ExpiresAt.After(now.Add(MAX_SHARE_TTL)) {
+		errs.SetFieldError("share_expires_at", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (s *Share) insert(tx *sql.Tx) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	s.CreatedAt = time.Now().UTC()
+	_, err := s.dbInsert(tx)
+	if IsDuplicateErr(err) {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+func (s *Share) expired() bool {
+	return !time.Now().UTC().Before(s.ExpiresAt)
+}
+
+// Any member of the team can share. Active shares count towards the shares limit of the team.
+func (t *Team) CreateShare(ctx context.Context, u *User, data []byte, maxViews int, expiresAt time.Time) (s *Share, err error) {
+	s = &Share{
+		Id:        util.GenerateRandomToken(24),
+		Team:      t.Id,
+		Creator:   u.Id,
+		Data:      data,
+		MaxViews:  maxViews,
+		ExpiresAt: expiresAt.UTC(),
+	}
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		tu, err := t.getUserAffiliation(tx, u.Id)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		if err := t.checkSharesLimit(tx); err != nil {
+			return err
+		}
+		return s.insert(tx)
+	})
+}
+
+// Returns the shares of the team that can still be opened. Only admins can see them.
+func (t *Team) GetShares(ctx context.Context, admin *User) (shares []*Share, err error) {
+	return shares, doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		rows, err := tx.Query(`SELECT `+selectShareFields+` FROM "share" WHERE "team" = $1 AND "expires_at" > $2 ORDER BY "created_at"`, t.Id, time.Now().UTC())
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		shares, err = scanShares(rows)
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+// Removes a share before it expires. Admins can revoke any share of the team and members their own ones.
+func (t *Team) RevokeShare(ctx context.Context, u *User, sid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		s := &Share{Id: sid}
+		if err := s.dbFind(tx); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if s.Team != t.Id {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		if s.Creator != u.Id {
+			if err := t.checkAdmin(tx, u); err != nil {
+				return err
+			}
+		}
+		return treatUpdateErr(s.dbDelete(tx))
+	})
+}
+
+// Returns a share that can still be opened without counting a view. Expired and burnt shares cannot be
+// told apart from the ones that never existed.
+func FindShare(ctx context.Context, sid string) (s *Share, err error) {
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		s, err = findShare(tx, sid, false)
+		return err
+	})
+}
+
+func findShare(tx *sql.Tx, sid string, forUpdate bool) (*Share, error) {
+	query := `SELECT ` + selectShareFields + ` FROM "share" WHERE "id" = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+	s := &Share{}
+	if err := s.dbScanRow(tx.QueryRow(query, sid)); isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	} else if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	if s.expired() {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	return s, nil
+}
+
+// Counts a view of the share and returns it with its data. The share is removed with its last view.
+func OpenShare(ctx context.Context, sid string) (s *Share, err error) {
+	return s, doTx(ctx, func(tx *sql.Tx) error {
+		if s, err = findShare(tx, sid, true); err != nil {
+			return err
+		}
+		s.Views++
+		if s.Views >= s.MaxViews {
+			return treatUpdateErr(s.dbDelete(tx))
+		}
+		return treatUpdateErr(s.dbUpdate(tx))
+	})
+}
+
+// Removes the shares that expired before being opened as many times as allowed
+func PurgeExpiredShares(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM "share" WHERE "expires_at" <= $1`, time.Now().UTC()); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
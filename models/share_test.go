@@ -0,0 +1,90 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func TestShareViewsAndRevoke(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	if _, err := team.CreateShare(ctx, getDummyUser(), a32b, 1, expiresAt); !util.CheckErr(err, ErrNotInTeam) {
+		t.Fatalf("Expected %s and got %s", ErrNotInTeam, err)
+	}
+	if _, err := team.CreateShare(ctx, member, a32b, 1, time.Now().Add(2*MAX_SHARE_TTL)); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Expected %s and got %s", ErrInvalidAttributes, err)
+	}
+	s, err := team.CreateShare(ctx, member, a32b, 2, expiresAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		found, err := FindShare(ctx, s.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.Views != 0 {
+			t.Fatalf("Finding a share counted %d views", found.Views)
+		}
+	}
+	for left := 1; left >= 0; left-- {
+		opened, err := OpenShare(ctx, s.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(opened.Data) != string(a32b) || opened.MaxViews-opened.Views != left {
+			t.Fatalf("Unexpected share %#v", opened)
+		}
+	}
+	if _, err = OpenShare(ctx, s.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Opened a burnt share: %s", err)
+	}
+	if s, err = team.CreateShare(ctx, member, a32b, 1, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = team.GetShares(ctx, member); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Expected %s and got %s", ErrUnauthorized, err)
+	}
+	shares, err := team.GetShares(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 1 || shares[0].Id != s.Id || shares[0].Creator != member.Id {
+		t.Fatalf("Unexpected shares %#v", shares)
+	}
+	if err = team.RevokeShare(ctx, member, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenShare(ctx, s.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Opened a revoked share: %s", err)
+	}
+}
+
+func TestSharesLimit(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	defer func() { TEAM_MAX_SHARES = 0 }()
+	TEAM_MAX_SHARES = 1
+	s, err := team.CreateShare(ctx, owner, a32b, 1, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = team.CreateShare(ctx, owner, a32b, 1, time.Now().Add(time.Hour)); !util.CheckErr(err, ErrPlanLimitReached) {
+		t.Fatalf("Went over the shares limit: %s", err)
+	}
+	if _, err = OpenShare(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = team.CreateShare(ctx, owner, a32b, 1, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+}
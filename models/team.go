@@ -43,7 +43,7 @@ func createTeam(tx *sql.Tx, owner *User, primary bool, name string, vaultKeys Va
 	if err := vaultKeys.checkKeyIdsMatch([]string{owner.Id}); err != nil {
 		return nil, err
 	}
-	if _, err := createVault(tx, DEFAULT_VAULT_NAME, t.Id, vaultKeys); err != nil {
+	if _, err := createVault(tx, t.Id, DEFAULT_VAULT_NAME, vaultKeys); err != nil {
 		return nil, err
 	}
 	return t, nil
@@ -58,6 +58,125 @@ func (t *Team) insert(tx *sql.Tx) error {
 	return util.NewErrorFrom(err)
 }
 
+func FindTeam(ctx context.Context, tid string) (t *Team, err error) {
+	t = &Team{Id: tid}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		return t.dbFind(tx)
+	})
+	if isNotExistsErr(err) {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	return t, err
+}
+
+// Reloads the team and locks its row until the transaction finishes
+func (t *Team) lockForUpdate(tx *sql.Tx) error {
+	r := tx.QueryRow(`SELECT `+selectTeamFields+` FROM "team" WHERE "id" = $1 FOR UPDATE`, t.Id)
+	err := t.dbScanRow(r)
+	if isNotExistsErr(err) {
+		return util.NewErrorFrom(ErrDoesntExist)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+// Hands the team over to another of its admins. Only the current owner can do it unless the
+// transfer is forced by a server admin. Primary teams belong to their user and cannot change hands.
+func (t *Team) TransferOwnership(ctx context.Context, by *User, newOwner *User, force bool) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.lockForUpdate(tx); err != nil {
+			return err
+		}
+		if t.Primary || (!force && t.Owner != by.Id) {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		if t.Owner == newOwner.Id {
+			return nil
+		}
+		tu, err := t.getUserAffiliation(tx, newOwner.Id)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		} else if !tu.Admin {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		t.Owner = newOwner.Id
+		return t.update(tx)
+	})
+}
+
+func (t *Team) update(tx *sql.Tx) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	t.UpdatedAt = time.Now().UTC()
+	return treatUpdateErr(t.dbUpdate(tx))
+}
+
+func (t *Team) delete(tx *sql.Tx) error {
+	return treatUpdateErr(t.dbDelete(tx))
+}
+
+// Changes the name of the team. Any admin of the team can do it.
+func (t *Team) Rename(ctx context.Context, admin *User, name string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.lockForUpdate(tx); err != nil {
+			return err
+		}
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		t.Name = name
+		return t.update(tx)
+	})
+}
+
+// Deletes the team with all its vaults and secrets. Only the owner can do it and primary teams
+// are only deleted along with their user.
+func (t *Team) Delete(ctx context.Context, u *User) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.lockForUpdate(tx); err != nil {
+			return err
+		}
+		if t.Primary || t.Owner != u.Id {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		return t.delete(tx)
+	})
+}
+
+// Deletes a vault of the team. Only admins can do it and the last vault of a team cannot be deleted.
+func (t *Team) DeleteVault(ctx context.Context, u *User, vid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		//Lock the team so two vaults cannot be deleted at the same time leaving it empty
+		if err := t.lockForUpdate(tx); err != nil {
+			return err
+		}
+		if err := t.checkAdmin(tx, u); err != nil {
+			return err
+		}
+		var vaults int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM "vault" WHERE "team" = $1`, t.Id).Scan(&vaults); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if vaults < 2 {
+			return util.NewErrorFrom(ErrLastVault)
+		}
+		var freed int64
+		if err := tx.QueryRow(`SELECT COALESCE(SUM(OCTET_LENGTH("data")), 0) FROM "secret" WHERE "team" = $1 AND "vault" = $2`, t.Id, vid).Scan(&freed); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		v := &Vault{Team: t.Id, Id: vid}
+		if err := treatUpdateErr(v.dbDelete(tx)); err != nil {
+			return err
+		}
+		//The secrets of the vault are removed along with it
+		return t.addSize(tx, -freed)
+	})
+}
+
 func (t *Team) validate() error {
 	errs := util.NewErrorFields().(*util.Error)
 	if !reValidUsername.MatchString(t.Owner) {
@@ -69,6 +188,13 @@ func (t *Team) validate() error {
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
 }
 
+func (t *Team) GetAdminUsers(ctx context.Context) (us []*User, err error) {
+	return us, doTx(ctx, func(tx *sql.Tx) error {
+		us, err = t.getAdminUsers(tx)
+		return err
+	})
+}
+
 func (t *Team) getAdminUsers(tx *sql.Tx) ([]*User, error) {
 	rows, err := tx.Query(`SELECT `+selectUserFullFields+` FROM "user", "team_user" WHERE "team_user"."team" = $1 AND "user"."id" = "team_user"."user" AND "team_user"."admin" = true`, t.Id)
 	if isErrOrPanic(err) {
@@ -89,6 +215,14 @@ func (t *Team) getUsers(tx *sql.Tx) ([]*User, error) {
 	return users, util.NewErrorFrom(err)
 }
 
+func (t *Team) countOtherUsers(tx *sql.Tx, uid string) (others int, err error) {
+	r := tx.QueryRow(`SELECT COUNT(*) FROM "team_user" WHERE "team" = $1 AND "user" != $2`, t.Id, uid)
+	if err = r.Scan(&others); isErrOrPanic(err) {
+		return 0, util.NewErrorFrom(err)
+	}
+	return others, nil
+}
+
 func (t *Team) getUsersAfiliation(tx *sql.Tx) ([]*teamUser, error) {
 	rows, err := tx.Query(`SELECT `+selectTeamUserFullFields+` FROM "team_user" WHERE "team_user"."team" = $1`, t.Id)
 	if isErrOrPanic(err) {
@@ -118,10 +252,13 @@ func (t *Team) CreateVault(ctx context.Context, u *User, name string, signedVaul
 		if !isAdmin {
 			return util.NewErrorFrom(ErrUnauthorized)
 		}
+		if err = t.checkVaultsLimit(tx); err != nil {
+			return err
+		}
 		if err = vaultKeys.checkKeyIdsMatch(uids); err != nil {
 			return err
 		}
-		v, err = createVault(tx, name, t.Id, vaultKeys)
+		v, err = createVault(tx, t.Id, name, vaultKeys)
 		return err
 	})
 }
@@ -184,13 +321,17 @@ func (t *Team) PromoteUser(ctx context.Context, promoter *User, promotee *User,
 		if err := signedVaultKeys.checkKeyIdsMatch(vaultIds); err != nil {
 			return err
 		}
+		//Before adding the missing vaults so they are not taken for permissions set while being a member
+		if err := t.promoteVaultPermissions(tx, promotee.Id); err != nil {
+			return err
+		}
 		for _, v := range missingVaults {
 			vaultKey := signedVaultKeys.Keys[v.Id]
 			_, err := verifyAndUnpack(v.PublicKey, vaultKey)
 			if err != nil {
 				return err
 			}
-			if err := v.addUser(tx, promotee.Id, vaultKey); err != nil {
+			if err := v.addUser(tx, promotee.Id, vaultKey, VAULT_PERMISSION_MANAGE); err != nil {
 				return err
 			}
 		}
@@ -293,15 +434,22 @@ func (t *Team) addUserNoAdminCheck(tx *sql.Tx, newUser *User) error {
 	if tu != nil {
 		return util.NewErrorFrom(ErrAlreadyInTeam)
 	}
+	if err := t.checkMembersLimit(tx); err != nil {
+		return err
+	}
 	tu = &teamUser{t.Id, newUser.Id, false, false}
 	return tu.insert(tx)
 }
 
 func (t *Team) DemoteUser(ctx context.Context, demoter *User, demotee *User) error {
-	if t.Owner == demotee.Id {
-		return util.NewErrorFrom(ErrUnauthorized)
-	}
 	return doTx(ctx, func(tx *sql.Tx) error {
+		//The owner may have changed since the team was loaded
+		if err := t.lockForUpdate(tx); err != nil {
+			return err
+		}
+		if t.Owner == demotee.Id {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
 		teamUsers, err := t.filterTeamUsers(tx, demoter.Id, demotee.Id)
 		if err != nil {
 			return err
@@ -312,12 +460,62 @@ func (t *Team) DemoteUser(ctx context.Context, demoter *User, demotee *User) err
 		if !teamUsers[1].Admin {
 			return nil
 		}
+		if err := t.demoteVaultPermissions(tx, demotee.Id); err != nil {
+			return err
+		}
 		ta := teamUsers[1]
 		ta.Admin = false
 		return ta.update(tx)
 	})
 }
 
+// Admins manage every vault. The permission the user had as a member is kept aside to give it back on demotion.
+func (t *Team) promoteVaultPermissions(tx *sql.Tx, uid string) error {
+	_, err := tx.Exec(`UPDATE "vault_user" SET "member_permission" = "permission", "permission" = $1, "updated_at" = $2 WHERE "team" = $3 AND "user" = $4`, VAULT_PERMISSION_MANAGE, time.Now().UTC(), t.Id, uid)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// Gives back the permissions the user had as a member. Vaults it only got as an admin are left writable.
+func (t *Team) demoteVaultPermissions(tx *sql.Tx, uid string) error {
+	_, err := tx.Exec(`UPDATE "vault_user" SET "permission" = COALESCE("member_permission", $1), "member_permission" = NULL, "updated_at" = $2 WHERE "team" = $3 AND "user" = $4`, VAULT_PERMISSION_WRITE, time.Now().UTC(), t.Id, uid)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// Removes a user from the team. Admins can remove anybody but the owner and any member can leave.
+// Returns the vaults the user had keys for so the remaining admins can rotate them.
+func (t *Team) RemoveUser(ctx context.Context, remover *User, uid string) (rotate []*Vault, err error) {
+	return rotate, doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.lockForUpdate(tx); err != nil {
+			return err
+		}
+		if t.Owner == uid {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		if remover.Id != uid {
+			if err := t.checkAdmin(tx, remover); err != nil {
+				return err
+			}
+		}
+		tu, err := t.getUserAffiliation(tx, uid)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		if rotate, err = t.getVaultsForUser(tx, &User{Id: uid}); err != nil {
+			return err
+		}
+		return treatUpdateErr(tu.dbDelete(tx))
+	})
+}
+
 func (t *Team) GetSecretsForUser(ctx context.Context, u *User) (s []*Secret, err error) {
 	return s, doTx(ctx, func(tx *sql.Tx) error {
 		s, err = t.getSecretsForUser(tx, u)
@@ -328,10 +526,11 @@ func (t *Team) GetSecretsForUser(ctx context.Context, u *User) (s []*Secret, err
 func (t *Team) getSecretsForUser(tx *sql.Tx, u *User) (s []*Secret, err error) {
 	query := `
 	SELECT DISTINCT ON ("secret"."team", "secret"."vault", "secret"."id")
-		"secret"."team", "secret"."vault", "secret"."id", "secret"."version", "secret"."data", "secret"."vault_version", "secret"."created_at"  
+		"secret"."team", "secret"."vault", "secret"."id", "secret"."version", "secret"."data", "secret"."vault_version", "secret"."created_at", "secret"."deleted_at"
 	FROM "secret", "vault_user" 
 	WHERE 
 		"secret"."team" = $1 AND 
+		"secret"."deleted_at" IS NULL AND 
 		"secret"."team" = "vault_user"."team" AND 
 		"secret"."vault" = "vault_user"."vault" AND 
 		"vault_user"."user" = $2
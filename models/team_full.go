@@ -10,6 +10,8 @@ type TeamFull struct {
 	Vaults  []*VaultFull    `json:"vaults"`
 	Users   []*TeamUserFull `json:"users"`
 	Invites []*Invite       `json:"invites"`
+	//Only filled in for admins
+	AccessRequests []*VaultAccessRequest `json:"access_requests,omitempty"`
 }
 
 func (u *User) GetTeamFull(ctx context.Context, tid string) (tf *TeamFull, err error) {
@@ -44,5 +46,13 @@ func (t *Team) getTeamFull(tx *sql.Tx, u *User) (*TeamFull, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &TeamFull{t, vf, tu, invs}, nil
+	tf := &TeamFull{Team: t, Vaults: vf, Users: tu, Invites: invs}
+	for _, member := range tu {
+		if member.User == u.Id && member.Admin {
+			if tf.AccessRequests, err = t.getVaultAccessRequests(tx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tf, nil
 }
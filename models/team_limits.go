@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Limits applied to every team. 0 disables the limit
+var (
+	TEAM_MAX_MEMBERS            = 0
+	TEAM_MAX_VAULTS             = 0
+	TEAM_MAX_SECRETS            = 0
+	TEAM_MAX_SECRET_BYTES int64 = 0
+	TEAM_MAX_SHARES             = 0
+)
+
+// Admins are told about each reached limit at most once in this interval
+var PLAN_LIMIT_NOTICE_INTERVAL = 24 * time.Hour
+
+var planLimits = []string{"team_members", "team_vaults", "team_secrets", "team_secret_bytes", "team_shares"}
+
+func planLimitReached(limit string) error {
+	errs := util.NewErrorFields().(*util.Error)
+	errs.SetFieldError(limit, "limit reached")
+	return errs.SetErrorOrCamo(ErrPlanLimitReached)
+}
+
+// Returns the name of the limit that stopped the request or an empty string
+func ReachedPlanLimit(err error) string {
+	for _, limit := range planLimits {
+		if util.CheckFieldErr(err, limit, "limit reached") {
+			return limit
+		}
+	}
+	return ""
+}
+
+// Records that the admins are being told about a reached limit. Returns false when they were already
+// told about it in the last PLAN_LIMIT_NOTICE_INTERVAL so clients retrying a request do not flood them.
+func (t *Team) MarkPlanLimitNotified(ctx context.Context, limit string) (notify bool, err error) {
+	return notify, doTx(ctx, func(tx *sql.Tx) error {
+		now := time.Now().UTC()
+		var notifiedAt time.Time
+		r := tx.QueryRow(`
+			INSERT INTO "team_limit_notice" ("team", "name", "notified_at") VALUES ($1, $2, $3)
+			ON CONFLICT ("team", "name") DO UPDATE SET "notified_at" = EXCLUDED."notified_at" WHERE "team_limit_notice"."notified_at" < $4
+			RETURNING "notified_at"`, t.Id, limit, now, now.Add(-PLAN_LIMIT_NOTICE_INTERVAL))
+		err := r.Scan(&notifiedAt)
+		if isNotExistsErr(err) {
+			return nil
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		notify = true
+		return nil
+	})
+}
+
+// Forgets that the admins were told about a reached limit so the next request hitting it tells them again.
+// Used when the notice could not be delivered.
+func (t *Team) ClearPlanLimitNotice(ctx context.Context, limit string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM "team_limit_notice" WHERE "team" = $1 AND "name" = $2`, t.Id, limit)
+		if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return nil
+	})
+}
+
+func (t *Team) checkMembersLimit(tx *sql.Tx) error {
+	if TEAM_MAX_MEMBERS < 1 {
+		return nil
+	}
+	if err := t.lockForUpdate(tx); err != nil {
+		return err
+	}
+	var members int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM "team_user" WHERE "team" = $1`, t.Id).Scan(&members); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if members >= TEAM_MAX_MEMBERS {
+		return planLimitReached("team_members")
+	}
+	return nil
+}
+
+func (t *Team) checkVaultsLimit(tx *sql.Tx) error {
+	if TEAM_MAX_VAULTS < 1 {
+		return nil
+	}
+	if err := t.lockForUpdate(tx); err != nil {
+		return err
+	}
+	var vaults int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM "vault" WHERE "team" = $1`, t.Id).Scan(&vaults); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if vaults >= TEAM_MAX_VAULTS {
+		return planLimitReached("team_vaults")
+	}
+	return nil
+}
+
+// Only the shares that have not expired yet count towards the limit
+func (t *Team) checkSharesLimit(tx *sql.Tx) error {
+	if TEAM_MAX_SHARES < 1 {
+		return nil
+	}
+	if err := t.lockForUpdate(tx); err != nil {
+		return err
+	}
+	var shares int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM "share" WHERE "team" = $1 AND "expires_at" > $2`, t.Id, time.Now().UTC()).Scan(&shares); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if shares >= TEAM_MAX_SHARES {
+		return planLimitReached("team_shares")
+	}
+	return nil
+}
+
+// Checks that the team has room for the new secrets and the new bytes. The team row has to be
+// locked so concurrent writes cannot go over the limits together.
+func (t *Team) checkSecretsLimit(tx *sql.Tx, newSecrets int, newBytes int64) error {
+	if TEAM_MAX_SECRETS > 0 && newSecrets > 0 {
+		var secrets int
+		if err := tx.QueryRow(`SELECT COUNT(DISTINCT ("vault", "id")) FROM "secret" WHERE "team" = $1`, t.Id).Scan(&secrets); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if secrets+newSecrets > TEAM_MAX_SECRETS {
+			return planLimitReached("team_secrets")
+		}
+	}
+	if TEAM_MAX_SECRET_BYTES > 0 && int64(t.Size)+newBytes > TEAM_MAX_SECRET_BYTES {
+		return planLimitReached("team_secret_bytes")
+	}
+	return nil
+}
+
+// Size is the amount of bytes used by all the stored versions of the secrets and the attachments of the team.
+// Every write that stores or removes bytes adds the difference so the size never needs a full scan.
+func (t *Team) addSize(tx *sql.Tx, delta int64) error {
+	r := tx.QueryRow(`UPDATE "team" SET "size" = "size" + $1 WHERE "id" = $2 RETURNING "size"`, delta, t.Id)
+	if err := r.Scan(&t.Size); isNotExistsErr(err) {
+		return util.NewErrorFrom(ErrDoesntExist)
+	} else if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// Secret writes lock the team first so the limits hold and the size stays right
+func (v *Vault) lockTeam(tx *sql.Tx) (*Team, error) {
+	t := &Team{Id: v.Team}
+	return t, t.lockForUpdate(tx)
+}
+
+func secretsSize(sl []*Secret) (size int64) {
+	for _, s := range sl {
+		size += int64(len(s.Data))
+	}
+	return size
+}
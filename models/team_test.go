@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/keydotcat/keycatd/util"
@@ -178,3 +179,344 @@ func TestPromoteUser(t *testing.T) {
 	}
 
 }
+
+func TestTransferOwnership(t *testing.T) {
+	ctx := getCtx()
+	owner, primary := getDummyOwnerWithTeam()
+	team := createTeamMock(owner)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.TransferOwnership(ctx, owner, member, false); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Transferred the team to a non admin: %s", err)
+	}
+	vaultsFull, err := team.GetVaultsFullForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = team.PromoteUser(ctx, owner, member, expandVaultKeysOnce(vaultsFull)); err != nil {
+		t.Fatal(err)
+	}
+	if err = team.TransferOwnership(ctx, member, member, false); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("An admin took the team from the owner: %s", err)
+	}
+	if err = primary.TransferOwnership(ctx, owner, member, true); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Transferred a primary team: %s", err)
+	}
+	if err = team.TransferOwnership(ctx, owner, member, false); err != nil {
+		t.Fatal(err)
+	}
+	if team.Owner != member.Id {
+		t.Fatalf("Expected %s to own the team and got %s", member.Id, team.Owner)
+	}
+	stale := &Team{Id: team.Id, Owner: owner.Id}
+	if err = stale.DemoteUser(ctx, owner, member); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Demoted the new owner: %s", err)
+	}
+	if err = team.DemoteUser(ctx, member, owner); err != nil {
+		t.Fatal(err)
+	}
+	if err = team.TransferOwnership(ctx, getDummyUser(), owner, true); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Forced the team to a non admin: %s", err)
+	}
+}
+
+func TestRemoveUserFromTeam(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	member := getDummyUser()
+	other := getDummyUser()
+	for _, u := range []*User{member, other} {
+		if _, err := team.AddOrInviteUserByEmail(ctx, owner, u.Email); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := team.RemoveUser(ctx, member, other.Id); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A member removed somebody else: %s", err)
+	}
+	if _, err := team.RemoveUser(ctx, owner, owner.Id); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("The owner left the team: %s", err)
+	}
+	rotate, err := team.RemoveUser(ctx, owner, other.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotate) != 0 {
+		t.Fatalf("Member without vaults got %d vaults to rotate", len(rotate))
+	}
+	if _, err = team.RemoveUser(ctx, member, member.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = team.RemoveUser(ctx, owner, member.Id); !util.CheckErr(err, ErrNotInTeam) {
+		t.Fatalf("Removed a user twice: %s", err)
+	}
+}
+
+func TestDeleteTeamAndVault(t *testing.T) {
+	ctx := getCtx()
+	owner, primary := getDummyOwnerWithTeam()
+	team := createTeamMock(owner)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	vm := getFirstVault(owner, team)
+	if err := team.DeleteVault(ctx, owner, vm.v.Id); !util.CheckErr(err, ErrLastVault) {
+		t.Fatalf("Deleted the last vault of a team: %s", err)
+	}
+	extra := createVaultMock(owner, team)
+	if err := extra.v.AddSecret(ctx, &Secret{Data: signAndPack(extra.priv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := team.DeleteVault(ctx, member, extra.v.Id); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A member deleted a vault: %s", err)
+	}
+	if err := team.DeleteVault(ctx, owner, extra.v.Id); err != nil {
+		t.Fatal(err)
+	}
+	if nt, err := FindTeam(ctx, team.Id); err != nil || nt.Size != 0 {
+		t.Fatalf("The secrets of the deleted vault are still counted: %v %s", nt, err)
+	}
+	if _, err := team.GetVaultForUser(ctx, extra.v.Id, owner); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("The vault is still there: %s", err)
+	}
+	if err := primary.Delete(ctx, owner); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Deleted a primary team: %s", err)
+	}
+	if err := team.Delete(ctx, member); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A member deleted the team: %s", err)
+	}
+	if err := team.Delete(ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindTeam(ctx, team.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("The team is still there: %s", err)
+	}
+}
+
+func TestRenameTeamAndVault(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	vm := getFirstVault(owner, team)
+	if err := vm.v.Rename(ctx, member, "nope"); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A member renamed a vault: %s", err)
+	}
+	if err := vm.v.Rename(ctx, owner, ""); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Renamed a vault to an empty name: %s", err)
+	}
+	if err := vm.v.Rename(ctx, owner, strings.Repeat("a", MAX_VAULT_NAME_LENGTH+1)); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Renamed a vault to a too long name: %s", err)
+	}
+	if err := vm.v.Rename(ctx, owner, "Shared"); err != nil {
+		t.Fatal(err)
+	}
+	v, err := team.GetVaultForUser(ctx, vm.v.Id, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "Shared" {
+		t.Fatalf("Expected vault name Shared and got %s", v.Name)
+	}
+	if err := team.Rename(ctx, member, "nope"); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A member renamed the team: %s", err)
+	}
+	if err := team.Rename(ctx, owner, "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if nt, err := FindTeam(ctx, team.Id); err != nil || nt.Name != "Renamed" {
+		t.Fatalf("Team was not renamed: %v %s", nt, err)
+	}
+}
+
+func TestVaultAccessRequest(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	vm := getFirstVault(owner, team)
+	if _, err := team.RequestVaultAccess(ctx, getDummyUser(), vm.v.Id); !util.CheckErr(err, ErrNotInTeam) {
+		t.Fatalf("A stranger asked for access: %s", err)
+	}
+	if _, err := team.RequestVaultAccess(ctx, owner, vm.v.Id); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Asked for access to an accessible vault: %s", err)
+	}
+	if _, err := team.RequestVaultAccess(ctx, member, vm.v.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := team.RequestVaultAccess(ctx, member, vm.v.Id); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Asked twice for access: %s", err)
+	}
+	tf, err := team.GetTeamFull(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tf.AccessRequests) != 1 || tf.AccessRequests[0].User != member.Id {
+		t.Fatalf("Unexpected access requests %#v", tf.AccessRequests)
+	}
+	for _, tu := range tf.Users {
+		if tu.User == member.Id && !tu.AccessRequired {
+			t.Fatal("The member is not flagged as waiting for access")
+		}
+	}
+	if tf, err = team.GetTeamFull(ctx, member); err != nil || len(tf.AccessRequests) != 0 {
+		t.Fatalf("A member can see the access requests: %v %s", tf, err)
+	}
+	if err = team.DenyVaultAccess(ctx, member, vm.v.Id, member.Id); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A member denied an access request: %s", err)
+	}
+	if err = team.DenyVaultAccess(ctx, owner, vm.v.Id, member.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = team.DenyVaultAccess(ctx, owner, vm.v.Id, member.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Denied a request twice: %s", err)
+	}
+	if _, err = team.RequestVaultAccess(ctx, member, vm.v.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	ars, err := team.GetVaultAccessRequests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ars) != 0 {
+		t.Fatalf("Granting access did not fulfill the request: %#v", ars)
+	}
+}
+
+func TestTeamLimits(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	vm := getFirstVault(owner, team)
+	defer func() {
+		TEAM_MAX_MEMBERS, TEAM_MAX_VAULTS, TEAM_MAX_SECRETS, TEAM_MAX_SECRET_BYTES = 0, 0, 0, 0
+	}()
+	TEAM_MAX_MEMBERS = 1
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, getDummyUser().Email); !util.CheckErr(err, ErrPlanLimitReached) {
+		t.Fatalf("Went over the members limit: %s", err)
+	}
+	TEAM_MAX_VAULTS = 1
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	if _, err := team.CreateVault(ctx, owner, "extra", getDummyVaultKeyPair(privKeys, owner.Id)); !util.CheckErr(err, ErrPlanLimitReached) {
+		t.Fatalf("Went over the vaults limit: %s", err)
+	}
+	TEAM_MAX_SECRETS = 2
+	sl := []*Secret{
+		&Secret{Data: signAndPack(vm.priv, a32b)},
+		&Secret{Data: signAndPack(vm.priv, a32b)},
+		&Secret{Data: signAndPack(vm.priv, a32b)},
+	}
+	if err := vm.v.AddSecretList(ctx, sl); !util.CheckErr(err, ErrPlanLimitReached) {
+		t.Fatalf("Went over the secrets limit: %s", err)
+	}
+	if err := vm.v.AddSecretList(ctx, sl[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.AddSecret(ctx, &Secret{Data: signAndPack(vm.priv, a32b)}); !util.CheckErr(err, ErrPlanLimitReached) {
+		t.Fatalf("Went over the secrets limit: %s", err)
+	}
+	nt, err := FindTeam(ctx, team.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nt.Size != len(sl[0].Data)+len(sl[1].Data) {
+		t.Fatalf("Unexpected team size %d", nt.Size)
+	}
+	TEAM_MAX_SECRET_BYTES = int64(nt.Size) + 1
+	if err = vm.v.UpdateSecret(ctx, &Secret{Id: sl[0].Id, Data: signAndPack(vm.priv, a32b)}); !util.CheckErr(err, ErrPlanLimitReached) {
+		t.Fatalf("Went over the secret bytes limit: %s", err)
+	}
+	if _, err = vm.v.DeleteSecret(ctx, sl[0].Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = vm.v.PurgeSecret(ctx, sl[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	if nt, err = FindTeam(ctx, team.Id); err != nil || nt.Size != len(sl[1].Data) {
+		t.Fatalf("Size was not updated after purging a secret: %v %s", nt, err)
+	}
+}
+
+func TestPlanLimitNotice(t *testing.T) {
+	ctx := getCtx()
+	owner := getDummyUser()
+	team := createTeamMock(owner)
+	defer func() { TEAM_MAX_VAULTS = 0 }()
+	TEAM_MAX_VAULTS = 1
+	privKeys := getUserPrivateKeys(owner.PublicKey, owner.Key)
+	_, err := team.CreateVault(ctx, owner, "extra", getDummyVaultKeyPair(privKeys, owner.Id))
+	if limit := ReachedPlanLimit(err); limit != "team_vaults" {
+		t.Fatalf("Expected the vaults limit and got '%s' for %s", limit, err)
+	}
+	for i, expected := range []bool{true, false} {
+		notify, err := team.MarkPlanLimitNotified(ctx, "team_vaults")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notify != expected {
+			t.Fatalf("Expected notify %t on attempt %d", expected, i)
+		}
+	}
+	if notify, err := team.MarkPlanLimitNotified(ctx, "team_secrets"); err != nil || !notify {
+		t.Fatalf("Another limit was not notified: %s", err)
+	}
+	if err := team.ClearPlanLimitNotice(ctx, "team_vaults"); err != nil {
+		t.Fatal(err)
+	}
+	if notify, err := team.MarkPlanLimitNotified(ctx, "team_vaults"); err != nil || !notify {
+		t.Fatalf("A cleared limit was not notified again: %s", err)
+	}
+}
+
+func TestDemoteUserKeepsVaultPermissions(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(owner, team)
+	adminOnly := createVaultMock(owner, team)
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.SetUserPermission(ctx, owner, member.Id, VAULT_PERMISSION_READ); err != nil {
+		t.Fatal(err)
+	}
+	vkp := VaultKeyPair{Keys: map[string][]byte{adminOnly.v.Id: sealVaultKey(adminOnly.v, adminOnly.priv)}}
+	if err := team.PromoteUser(ctx, owner, member, vkp); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.CheckPermission(ctx, member, VAULT_PERMISSION_MANAGE); err != nil {
+		t.Fatalf("Admins have to manage every vault: %s", err)
+	}
+	if err := team.DemoteUser(ctx, owner, member); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{vm.v.Id: VAULT_PERMISSION_READ, adminOnly.v.Id: VAULT_PERMISSION_WRITE}
+	for vid, permission := range expected {
+		v, err := team.GetVaultForUser(ctx, vid, member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vf, err := v.GetVaultFullForUser(ctx, member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vf.Permission != permission {
+			t.Fatalf("Expected permission %s in vault %s and got %s", permission, vid, vf.Permission)
+		}
+	}
+}
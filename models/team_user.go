@@ -10,7 +10,7 @@ type teamUser struct {
 	Team           string `scaneo:"pk" json:"-"`
 	User           string `scaneo:"pk" json:"user"`
 	Admin          bool   `json:"admin"`
-	AccessRequired bool   `json:"-"`
+	AccessRequired bool   `json:"access_required"`
 }
 
 func (tu *teamUser) insert(tx *sql.Tx) error {
@@ -11,7 +11,7 @@ type TeamUserFull struct {
 	Team           string `scaneo:"pk" json:"-"`
 	User           string `scaneo:"pk" json:"id"`
 	Admin          bool   `json:"admin"`
-	AccessRequired bool   `json:"-"`
+	AccessRequired bool   `json:"access_required"`
 	FullName       string `json:"fullname"`
 	PublicKey      []byte `json:"public_key"`
 }
@@ -8,13 +8,28 @@ import (
 	"github.com/keydotcat/keycatd/util"
 )
 
-const TOKEN_VERIFICATION = 0
+const (
+	TOKEN_VERIFICATION          = 0
+	TOKEN_PASSWORD_RESET        = 1
+	TOKEN_LOGIN_CHALLENGE       = 2
+	TOKEN_WEBAUTHN_REGISTRATION = 3
+	TOKEN_UNLOCK                = 4
+)
+
+var (
+	PASSWORD_RESET_TOKEN_TTL  = 24 * time.Hour
+	UNLOCK_TOKEN_TTL          = 24 * time.Hour
+	LOGIN_CHALLENGE_TOKEN_TTL = 5 * time.Minute
+	// Wrong second factors allowed before the login challenge is dropped and the user has to log in again
+	LOGIN_CHALLENGE_ATTEMPTS = 3
+)
 
 type Token struct {
 	Id        string    `scaneo:"pk" json:"id"`
 	Type      int       `json:"-"`
 	User      string    `json:"-"`
 	Extra     string    `json:"extra,omitempty"`
+	Attempts  int       `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -58,7 +73,9 @@ func (u *Token) validate() error {
 	if len(u.Id) < 6 {
 		errs.SetFieldError("id", "too short")
 	}
-	if u.Type != TOKEN_VERIFICATION {
+	switch u.Type {
+	case TOKEN_VERIFICATION, TOKEN_PASSWORD_RESET, TOKEN_LOGIN_CHALLENGE, TOKEN_WEBAUTHN_REGISTRATION, TOKEN_UNLOCK:
+	default:
 		errs.SetFieldError("type", "invalid")
 	}
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
@@ -109,3 +126,122 @@ func (t *Token) ConfirmEmail(ctx context.Context) (u *User, err error) {
 		return u.update(tx)
 	})
 }
+
+func (t *Token) expired() bool {
+	switch t.Type {
+	case TOKEN_PASSWORD_RESET:
+		return time.Now().UTC().After(t.CreatedAt.Add(PASSWORD_RESET_TOKEN_TTL))
+	case TOKEN_UNLOCK:
+		return time.Now().UTC().After(t.CreatedAt.Add(UNLOCK_TOKEN_TTL))
+	case TOKEN_LOGIN_CHALLENGE, TOKEN_WEBAUTHN_REGISTRATION:
+		return time.Now().UTC().After(t.CreatedAt.Add(LOGIN_CHALLENGE_TOKEN_TTL))
+	}
+	return false
+}
+
+// Sets a new password and key pack. The access tokens of the user are revoked since whoever had the old
+// password could have created them.
+func (t *Token) ResetPassword(ctx context.Context, password string, keyPack []byte) (u *User, lost []*LostVaultKey, err error) {
+	if t.Type != TOKEN_PASSWORD_RESET || t.expired() {
+		return nil, nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	pub, priv, err := expandUserKeyPack(keyPack)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, lost, doTx(ctx, func(tx *sql.Tx) error {
+		u, err = findUser(tx, t.User)
+		if err != nil {
+			return err
+		}
+		if err = treatUpdateErr(t.dbDelete(tx)); err != nil {
+			return err
+		}
+		if err = u.setPassword(password); err != nil {
+			return err
+		}
+		u.PublicKey = pub
+		u.Key = priv
+		if err = u.update(tx); err != nil {
+			return err
+		}
+		if err = u.deleteAccessTokens(tx); err != nil {
+			return err
+		}
+		lost, err = markVaultKeysLostForUser(tx, u.Id)
+		return err
+	})
+}
+
+func (u *User) NewLoginChallenge(ctx context.Context, extra string) (t *Token, err error) {
+	t = &Token{Type: TOKEN_LOGIN_CHALLENGE, User: u.Id, Extra: extra}
+	return t, doTx(ctx, func(tx *sql.Tx) error {
+		return t.insert(tx)
+	})
+}
+
+func (t *Token) CompleteLoginChallengeWithTOTP(ctx context.Context, code string) (*User, *Token, error) {
+	return t.completeLoginChallenge(ctx, func(tx *sql.Tx, u *User) error {
+		return u.checkTOTP(tx, code)
+	})
+}
+
+// Wrong second factors count towards the account lockout like wrong passwords do and the challenge is
+// dropped after LOGIN_CHALLENGE_ATTEMPTS of them. The unlock token is only returned by the attempt that
+// locks the account so it can be mailed to the user.
+func (t *Token) completeLoginChallenge(ctx context.Context, verify func(*sql.Tx, *User) error) (u *User, unlock *Token, err error) {
+	if t.Type != TOKEN_LOGIN_CHALLENGE || t.expired() {
+		return nil, nil, util.NewErrorFrom(ErrUnauthorized)
+	}
+	var verr error
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		u, err = findUser(tx, t.User)
+		if err != nil {
+			return err
+		}
+		if u.IsLocked() {
+			return util.NewErrorFrom(ErrAccountLocked)
+		}
+		if verr = verify(tx, u); verr != nil {
+			return verr
+		}
+		if err = u.resetFailedAttempts(tx); err != nil {
+			return err
+		}
+		return treatUpdateErr(t.dbDelete(tx))
+	})
+	if verr == nil || !util.CheckErr(verr, ErrUnauthorized) {
+		return u, nil, err
+	}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.registerFailedChallenge(tx); err != nil {
+			return err
+		}
+		unlock, err = u.registerFailedAttempt(tx)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if unlock != nil {
+		return nil, unlock, util.NewErrorFrom(ErrAccountLocked)
+	}
+	return nil, nil, verr
+}
+
+func (t *Token) registerFailedChallenge(tx *sql.Tx) error {
+	r := tx.QueryRow(`UPDATE "token" SET "attempts" = "attempts" + 1 WHERE "id" = $1 RETURNING "attempts"`, t.Id)
+	if err := r.Scan(&t.Attempts); isNotExistsErr(err) {
+		//A concurrent attempt already dropped the challenge
+		return nil
+	} else if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	if t.Attempts < LOGIN_CHALLENGE_ATTEMPTS {
+		return nil
+	}
+	if _, err := t.dbDelete(tx); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
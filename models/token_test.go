@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -11,7 +12,7 @@ func TestConfirmEmail(t *testing.T) {
 	uid := "u_" + util.GenerateRandomToken(10)
 	_, priv, pack := generateNewKeys()
 	vkp := getDummyVaultKeyPair(priv, uid)
-	u, tok, err := NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, pack, vkp)
+	u, tok, _, err := NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, pack, vkp)
 	if err != nil {
 		panic(err)
 	}
@@ -44,3 +45,83 @@ func TestConfirmEmail(t *testing.T) {
 	}
 
 }
+
+func TestResetPassword(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	member := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, member.Email); err != nil {
+		t.Fatal(err)
+	}
+	vm := getFirstVault(owner, team)
+	if err := vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := member.GetPasswordResetToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok2, err := member.GetPasswordResetToken(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Id != tok2.Id {
+		t.Errorf("Expected to reuse the password reset token")
+	}
+	if _, err = tok.ConfirmEmail(ctx); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Unexpected error: %s vs %s", ErrDoesntExist, err)
+	}
+	_, bearer, err := member.NewAccessToken(ctx, "ci", []string{team.Id}, false, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, pack := generateNewKeys()
+	u, lost, err := tok.ResetPassword(ctx, "newpass", pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FindAccessToken(ctx, bearer); err == nil {
+		t.Fatal("Access token survived the password reset")
+	}
+	if err = u.CheckPassword("newpass"); err != nil {
+		t.Errorf("Password didn't change")
+	}
+	if len(lost) != 2 {
+		t.Fatalf("Expected 2 lost vault keys and got %d", len(lost))
+	}
+	for _, lvk := range lost {
+		switch lvk.Team {
+		case team.Id:
+			if !lvk.Recoverable {
+				t.Errorf("Vault %s should be recoverable by the team owner", lvk.Vault)
+			}
+		default:
+			if lvk.Recoverable {
+				t.Errorf("Vault %s in the primary team should not be recoverable", lvk.Vault)
+			}
+		}
+	}
+	vfs, err := team.GetVaultsFullForUser(ctx, owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vfs[0].UsersKeyLost) != 1 || vfs[0].UsersKeyLost[0] != member.Id {
+		t.Fatalf("Expected %s to be flagged as having lost the vault key: %v", member.Id, vfs[0].UsersKeyLost)
+	}
+	if vfs, err = team.GetVaultsFullForUser(ctx, member); err != nil {
+		t.Fatal(err)
+	} else if len(vfs) != 0 {
+		t.Fatalf("Expected no readable vaults and got %d", len(vfs))
+	}
+	if err = vm.v.AddUsers(ctx, map[string][]byte{member.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	if vfs, err = team.GetVaultsFullForUser(ctx, member); err != nil {
+		t.Fatal(err)
+	} else if len(vfs) != 1 {
+		t.Fatalf("Expected the vault key to be re-shared")
+	}
+	if _, _, err = tok.ResetPassword(ctx, "newpass", pack); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Unexpected error: %s vs %s", ErrDoesntExist, err)
+	}
+}
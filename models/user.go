@@ -30,14 +30,19 @@ type User struct {
 	FailedAttempts   int         `json:"failed_attempts"`
 	PublicKey        []byte      `json:"public_key"`
 	Key              []byte      `json:"-"`
+	TotpSecret       []byte      `json:"-"`
+	TotpEnabled      bool        `json:"totp_enabled"`
+	TotpLastCounter  int64       `json:"-"`
 	CreatedAt        time.Time   `json:"created_at"`
 	UpdatedAt        time.Time   `json:"updated_at"`
 }
 
-func NewUser(ctx context.Context, id, fullname, email, password string, keyPack []byte, signedVaultKeys VaultKeyPair) (*User, *Token, error) {
+// Creates the user with its own team and joins the teams that invited its email. Teams at their members
+// limit are returned and keep the invite pending so an admin can add the user once there is room.
+func NewUser(ctx context.Context, id, fullname, email, password string, keyPack []byte, signedVaultKeys VaultKeyPair) (*User, *Token, []*Team, error) {
 	pub, priv, err := expandUserKeyPack(keyPack)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	u := &User{
 		Id:               id,
@@ -49,13 +54,14 @@ func NewUser(ctx context.Context, id, fullname, email, password string, keyPack
 	}
 	vaultKeys, err := signedVaultKeys.verifyAndUnpack(u.PublicKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	t := &Token{Type: TOKEN_VERIFICATION, User: u.Id}
 	if err := u.setPassword(password); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
-	return u, t, doTx(ctx, func(tx *sql.Tx) error {
+	var fullTeams []*Team
+	err = doTx(ctx, func(tx *sql.Tx) error {
 		if err := u.insert(tx); err != nil {
 			return err
 		}
@@ -75,7 +81,11 @@ func NewUser(ctx context.Context, id, fullname, email, password string, keyPack
 			if err != nil {
 				return err
 			}
-			if err := team.addUserNoAdminCheck(tx, u); err != nil {
+			//Full teams keep the invite around so their admins can see it did not go through
+			if err := team.addUserNoAdminCheck(tx, u); util.CheckErr(err, ErrPlanLimitReached) {
+				fullTeams = append(fullTeams, team)
+				continue
+			} else if err != nil {
 				return err
 			}
 			if _, err := i.dbDelete(tx); err != nil {
@@ -84,6 +94,7 @@ func NewUser(ctx context.Context, id, fullname, email, password string, keyPack
 		}
 		return err
 	})
+	return u, t, fullTeams, err
 }
 
 func (u *User) ChangePassword(ctx context.Context, password string, keyPack []byte) error {
@@ -241,6 +252,40 @@ func (u *User) GetVerificationToken(ctx context.Context) (t *Token, err error) {
 	return t, nil
 }
 
+func (u *User) SecondFactorMethods(ctx context.Context) (methods []string, err error) {
+	methods = []string{}
+	if u.TotpEnabled {
+		methods = append(methods, "totp")
+	}
+	wcs, err := u.GetWebauthnCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(wcs) > 0 {
+		methods = append(methods, "webauthn")
+	}
+	return methods, nil
+}
+
+func (u *User) GetPasswordResetToken(ctx context.Context) (t *Token, err error) {
+	return t, doTx(ctx, func(tx *sql.Tx) error {
+		for _, token := range findTokensForUser(tx, u.Id) {
+			if token.Type != TOKEN_PASSWORD_RESET {
+				continue
+			}
+			if !token.expired() {
+				t = token
+				return nil
+			}
+			if err := treatUpdateErr(token.dbDelete(tx)); err != nil {
+				return err
+			}
+		}
+		t = &Token{Type: TOKEN_PASSWORD_RESET, User: u.Id}
+		return t.insert(tx)
+	})
+}
+
 func (u *User) GetTeam(ctx context.Context, tid string) (t *Team, err error) {
 	return t, doTx(ctx, func(tx *sql.Tx) error {
 		t, err = u.getTeam(tx, tid)
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Deletes the account after checking the password. The primary team and the teams nobody else
+// belongs to go away with the user. Teams that still have other members would be wiped by the
+// cascade on the owner so their ownership has to be transferred first. In that case those teams
+// are returned along with ErrOwnsSharedTeams. Wrong passwords count towards the lockout like
+// they do on login and the unlock token is returned by the attempt that locks the account.
+func (u *User) Delete(ctx context.Context, password string) (shared []*Team, unlock *Token, err error) {
+	if unlock, err = u.CheckLoginPassword(ctx, password); err != nil {
+		return nil, unlock, err
+	}
+	return shared, nil, doTx(ctx, func(tx *sql.Tx) error {
+		owned, err := u.getOwnedTeams(tx)
+		if err != nil {
+			return err
+		}
+		shared = []*Team{}
+		orphaned := []*Team{}
+		for _, t := range owned {
+			others, err := t.countOtherUsers(tx, u.Id)
+			if err != nil {
+				return err
+			}
+			if t.Primary || others == 0 {
+				orphaned = append(orphaned, t)
+			} else {
+				shared = append(shared, t)
+			}
+		}
+		if len(shared) > 0 {
+			return util.NewErrorFrom(ErrOwnsSharedTeams)
+		}
+		for _, t := range orphaned {
+			if err := t.delete(tx); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM "vault_user" WHERE "user" = $1`, u.Id); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if _, err := tx.Exec(`DELETE FROM "team_user" WHERE "user" = $1`, u.Id); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		return treatUpdateErr(u.dbDelete(tx))
+	})
+}
+
+func (u *User) getOwnedTeams(tx *sql.Tx) ([]*Team, error) {
+	rows, err := tx.Query(`SELECT `+selectTeamFields+` FROM "team" WHERE "owner" = $1`, u.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	teams, err := scanTeams(rows)
+	isErrOrPanic(err)
+	return teams, util.NewErrorFrom(err)
+}
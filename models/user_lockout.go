@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+var (
+	LOCKOUT_ATTEMPTS = 5
+	LOCKOUT_DURATION = 15 * time.Minute
+)
+
+func (u *User) IsLocked() bool {
+	return u.LockedAt.Valid && time.Now().UTC().Before(u.LockedAt.Time.Add(LOCKOUT_DURATION))
+}
+
+// Checks the password of a login attempt. Failed attempts are counted and once LOCKOUT_ATTEMPTS is
+// reached the account is locked for LOCKOUT_DURATION. The unlock token is only returned by the
+// attempt that locks the account so it can be mailed to the user.
+func (u *User) CheckLoginPassword(ctx context.Context, pass string) (unlock *Token, err error) {
+	if u.IsLocked() {
+		return nil, util.NewErrorFrom(ErrAccountLocked)
+	}
+	if err = u.CheckPassword(pass); err == nil {
+		return nil, doTx(ctx, func(tx *sql.Tx) error {
+			return u.resetFailedAttempts(tx)
+		})
+	}
+	if LOCKOUT_ATTEMPTS < 1 {
+		return nil, err
+	}
+	err = doTx(ctx, func(tx *sql.Tx) error {
+		unlock, err = u.registerFailedAttempt(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unlock != nil {
+		return unlock, util.NewErrorFrom(ErrAccountLocked)
+	}
+	return nil, util.NewErrorFrom(ErrUnauthorized)
+}
+
+// Counts a failed password or second factor attempt. Returns the unlock token when the attempt locks the account.
+func (u *User) registerFailedAttempt(tx *sql.Tx) (*Token, error) {
+	if LOCKOUT_ATTEMPTS < 1 {
+		return nil, nil
+	}
+	r := tx.QueryRow(`UPDATE "user" SET "failed_attempts" = "failed_attempts" + 1 WHERE "id" = $1 RETURNING "failed_attempts"`, u.Id)
+	if err := r.Scan(&u.FailedAttempts); isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	if u.FailedAttempts < LOCKOUT_ATTEMPTS {
+		return nil, nil
+	}
+	u.FailedAttempts = 0
+	u.LockedAt.Valid = true
+	u.LockedAt.Time = time.Now().UTC()
+	res, err := tx.Exec(`UPDATE "user" SET "failed_attempts" = 0, "locked_at" = $2 WHERE "id" = $1`, u.Id, u.LockedAt)
+	if err := treatUpdateErr(res, err); err != nil {
+		return nil, err
+	}
+	return u.getUnlockToken(tx)
+}
+
+// A concurrent attempt may have locked the account after the password was checked
+func (u *User) resetFailedAttempts(tx *sql.Tx) error {
+	res, err := tx.Exec(`UPDATE "user" SET "failed_attempts" = 0, "locked_at" = NULL WHERE "id" = $1 AND ("locked_at" IS NULL OR "locked_at" < $2)`, u.Id, time.Now().UTC().Add(-LOCKOUT_DURATION))
+	if err := treatUpdateErr(res, err); err != nil {
+		if util.CheckErr(err, ErrDoesntExist) {
+			return util.NewErrorFrom(ErrAccountLocked)
+		}
+		return err
+	}
+	u.FailedAttempts = 0
+	u.LockedAt.Valid = false
+	return u.deleteUnlockTokens(tx)
+}
+
+func (u *User) RegisterSignIn(ctx context.Context) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		r := tx.QueryRow(`UPDATE "user" SET "sign_in_count" = "sign_in_count" + 1 WHERE "id" = $1 RETURNING "sign_in_count"`, u.Id)
+		err := r.Scan(&u.SignInCount)
+		if isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		}
+		isErrOrPanic(err)
+		return util.NewErrorFrom(err)
+	})
+}
+
+func (u *User) GetUnlockToken(ctx context.Context) (t *Token, err error) {
+	if !u.IsLocked() {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	return t, doTx(ctx, func(tx *sql.Tx) error {
+		t, err = u.getUnlockToken(tx)
+		return err
+	})
+}
+
+func (u *User) getUnlockToken(tx *sql.Tx) (*Token, error) {
+	for _, token := range findTokensForUser(tx, u.Id) {
+		if token.Type != TOKEN_UNLOCK {
+			continue
+		}
+		if !token.expired() {
+			return token, nil
+		}
+		if err := treatUpdateErr(token.dbDelete(tx)); err != nil {
+			return nil, err
+		}
+	}
+	t := &Token{Type: TOKEN_UNLOCK, User: u.Id}
+	return t, t.insert(tx)
+}
+
+// Unlock links are only good once. Signing in after the lock runs out drops them as well.
+func (u *User) deleteUnlockTokens(tx *sql.Tx) error {
+	if _, err := tx.Exec(`DELETE FROM "token" WHERE "user" = $1 AND "type" = $2`, u.Id, TOKEN_UNLOCK); isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+func (t *Token) UnlockAccount(ctx context.Context) (u *User, err error) {
+	if t.Type != TOKEN_UNLOCK || t.expired() {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	return u, doTx(ctx, func(tx *sql.Tx) error {
+		u, err = findUser(tx, t.User)
+		if err != nil {
+			return err
+		}
+		if err = treatUpdateErr(t.dbDelete(tx)); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE "user" SET "failed_attempts" = 0, "locked_at" = NULL WHERE "id" = $1`, u.Id)
+		if err := treatUpdateErr(res, err); err != nil {
+			return err
+		}
+		u.FailedAttempts = 0
+		u.LockedAt.Valid = false
+		return nil
+	})
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -14,7 +15,7 @@ func getDummyUser() *User {
 	uid := "u_" + util.GenerateRandomToken(10)
 	_, priv, fullpack := generateNewKeys()
 	vkp := getDummyVaultKeyPair(priv, uid)
-	u, _, err := NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, fullpack, vkp)
+	u, _, _, err := NewUser(ctx, uid, "uid fullname", uid+"@nowhere.net", uid, fullpack, vkp)
 	if err != nil {
 		panic(err)
 	}
@@ -26,7 +27,7 @@ func TestCreateUser(t *testing.T) {
 	uid := util.GenerateRandomToken(5)
 	_, priv, fullpack := generateNewKeys()
 	vkp := getDummyVaultKeyPair(priv, uid)
-	u, tok, err := NewUser(ctx, uid, uid+" name", uid+"@asdas.com", uid, fullpack, vkp)
+	u, tok, _, err := NewUser(ctx, uid, uid+" name", uid+"@asdas.com", uid, fullpack, vkp)
 	if err != nil {
 		fmt.Println(util.GetStack(err))
 		t.Fatal(err)
@@ -40,7 +41,7 @@ func TestCreateUser(t *testing.T) {
 	}
 	_, priv, fullpack = generateNewKeys()
 	vkp = getDummyVaultKeyPair(priv, uid)
-	u, tok, err = NewUser(ctx, uid, uid+" name", uid+"@asdas.com", uid, fullpack, vkp)
+	u, tok, _, err = NewUser(ctx, uid, uid+" name", uid+"@asdas.com", uid, fullpack, vkp)
 	if err != nil && !util.CheckFieldErr(err, "user_id", "duplicate") {
 		fmt.Println(util.GetStack(err))
 		t.Fatal(err)
@@ -70,8 +71,8 @@ func TestCreateUser(t *testing.T) {
 		t.Fatalf("Expected to have 1 vaults and got %d", len(vaults))
 	}
 	vault := vaults[0]
-	if vault.Id != DEFAULT_VAULT_NAME {
-		t.Errorf("Team name mismatch expected %s and got %s", DEFAULT_VAULT_NAME, vault.Id)
+	if vault.Name != DEFAULT_VAULT_NAME {
+		t.Errorf("Vault name mismatch expected %s and got %s", DEFAULT_VAULT_NAME, vault.Name)
 	}
 	nu, err := FindUser(ctx, u.Id)
 	if err != nil {
@@ -94,3 +95,209 @@ func TestCreateUser(t *testing.T) {
 		t.Errorf("Mismatch in user IDs. Got %s and expected %s", nu.Id, u.Id)
 	}
 }
+
+func TestCreateUserInvitedToFullTeam(t *testing.T) {
+	ctx := getCtx()
+	owner, team := getDummyOwnerWithTeam()
+	uid := util.GenerateRandomToken(5)
+	email := uid + "@asdas.com"
+	if _, err := team.AddOrInviteUserByEmail(ctx, owner, email); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { TEAM_MAX_MEMBERS = 0 }()
+	TEAM_MAX_MEMBERS = 1
+	_, priv, fullpack := generateNewKeys()
+	vkp := getDummyVaultKeyPair(priv, uid)
+	u, _, fullTeams, err := NewUser(ctx, uid, uid+" name", email, uid, fullpack, vkp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fullTeams) != 1 || fullTeams[0].Id != team.Id {
+		t.Fatalf("The full team was not reported: %v", fullTeams)
+	}
+	if teams, err := u.GetTeams(ctx); err != nil || len(teams) != 1 {
+		t.Fatalf("Joined a full team: %v %s", teams, err)
+	}
+	if invs, err := FindInvitesForEmail(ctx, email); err != nil || len(invs) != 1 {
+		t.Fatalf("The invite to the full team is not pending anymore: %v %s", invs, err)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	if methods, err := u.SecondFactorMethods(ctx); err != nil || len(methods) != 0 {
+		t.Fatalf("New users should not have a second factor: %v %s", methods, err)
+	}
+	secret, err := u.GenerateTOTPSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code := util.TOTPCode(secret, now.Add(-util.TOTP_PERIOD*time.Second))
+	if err = u.EnableTOTP(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = u.GenerateTOTPSecret(ctx); !util.CheckErr(err, ErrAlreadyExists) {
+		t.Fatalf("Unexpected error: %s vs %s", ErrAlreadyExists, err)
+	}
+	tok, err := u.NewLoginChallenge(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = tok.CompleteLoginChallengeWithTOTP(ctx, code); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Replayed code was accepted: %s", err)
+	}
+	u2, _, err := tok.CompleteLoginChallengeWithTOTP(ctx, util.TOTPCode(secret, now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u2.Id != u.Id || !u2.TotpEnabled {
+		t.Fatalf("Unexpected user after the login challenge: %v", u2)
+	}
+	if _, err = FindToken(ctx, tok.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Login challenge was not consumed: %s", err)
+	}
+	if err = u2.DisableTOTP(ctx, util.TOTPCode(secret, now.Add(util.TOTP_PERIOD*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	u3, err := FindUser(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u3.TotpEnabled || len(u3.TotpSecret) > 0 {
+		t.Fatalf("TOTP was not disabled")
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	for i := 1; i < LOCKOUT_ATTEMPTS; i++ {
+		unlock, err := u.CheckLoginPassword(ctx, "nope")
+		if unlock != nil || !util.CheckErr(err, ErrUnauthorized) {
+			t.Fatalf("Unexpected error on attempt %d: %s", i, err)
+		}
+	}
+	unlock, err := u.CheckLoginPassword(ctx, "nope")
+	if unlock == nil || !util.CheckErr(err, ErrAccountLocked) {
+		t.Fatalf("Expected the account to be locked and got %s", err)
+	}
+	if _, err = u.CheckLoginPassword(ctx, u.Id); !util.CheckErr(err, ErrAccountLocked) {
+		t.Fatalf("Locked account accepted a valid password: %s", err)
+	}
+	stale := *unlock
+	stale.CreatedAt = stale.CreatedAt.Add(-UNLOCK_TOKEN_TTL - time.Minute)
+	if _, err = stale.UnlockAccount(ctx); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expired unlock token was accepted: %s", err)
+	}
+	u2, err := unlock.UnlockAccount(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u2.IsLocked() || u2.FailedAttempts != 0 {
+		t.Fatalf("Account is still locked after unlocking it")
+	}
+	if _, err = FindToken(ctx, unlock.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Unlock token was not consumed: %s", err)
+	}
+	if _, err = u2.CheckLoginPassword(ctx, u.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = u2.RegisterSignIn(ctx); err != nil {
+		t.Fatal(err)
+	}
+	u3, err := FindUser(ctx, u.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u3.SignInCount != u.SignInCount+1 || u3.LockedAt.Valid {
+		t.Fatalf("Unexpected user state after login: %#v", u3)
+	}
+}
+
+func TestLoginChallengeAttempts(t *testing.T) {
+	ctx := getCtx()
+	u := getDummyUser()
+	secret, err := u.GenerateTOTPSecret(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = u.EnableTOTP(ctx, util.TOTPCode(secret, time.Now().Add(-util.TOTP_PERIOD*time.Second))); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := u.NewLoginChallenge(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < LOGIN_CHALLENGE_ATTEMPTS; i++ {
+		if _, _, err = tok.CompleteLoginChallengeWithTOTP(ctx, "000000"); !util.CheckErr(err, ErrUnauthorized) {
+			t.Fatalf("Unexpected error on attempt %d: %s", i, err)
+		}
+	}
+	if _, err = FindToken(ctx, tok.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Login challenge survived too many wrong codes: %s", err)
+	}
+	if tok, err = u.NewLoginChallenge(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	var unlock *Token
+	for i := LOGIN_CHALLENGE_ATTEMPTS; i < LOCKOUT_ATTEMPTS && unlock == nil; i++ {
+		_, unlock, err = tok.CompleteLoginChallengeWithTOTP(ctx, "000000")
+	}
+	if unlock == nil || !util.CheckErr(err, ErrAccountLocked) {
+		t.Fatalf("Wrong codes did not lock the account: %s", err)
+	}
+	if _, _, err = tok.CompleteLoginChallengeWithTOTP(ctx, util.TOTPCode(secret, time.Now())); !util.CheckErr(err, ErrAccountLocked) {
+		t.Fatalf("Locked account completed the login challenge: %s", err)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	ctx := getCtx()
+	owner, primary := getDummyOwnerWithTeam()
+	lonely := createTeamMock(owner)
+	shared := createTeamMock(owner)
+	if _, err := shared.AddOrInviteUserByEmail(ctx, owner, getDummyUser().Email); err != nil {
+		t.Fatal(err)
+	}
+	otherOwner, otherTeam := getDummyOwnerWithTeam()
+	if _, err := otherTeam.AddOrInviteUserByEmail(ctx, otherOwner, owner.Email); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := owner.Delete(ctx, "nope"); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Deleted a user with the wrong password: %s", err)
+	}
+	if owner.FailedAttempts != 1 {
+		t.Fatalf("Wrong password was not counted towards the lockout: %d", owner.FailedAttempts)
+	}
+	blocking, _, err := owner.Delete(ctx, owner.Id)
+	if !util.CheckErr(err, ErrOwnsSharedTeams) {
+		t.Fatalf("Expected %s and got %s", ErrOwnsSharedTeams, err)
+	}
+	if len(blocking) != 1 || blocking[0].Id != shared.Id {
+		t.Fatalf("Expected only the shared team to block the deletion and got %#v", blocking)
+	}
+	if _, err = owner.GetTeam(ctx, lonely.Id); err != nil {
+		t.Fatalf("Team was deleted even if the user is still there: %s", err)
+	}
+	if _, err = mdb.Exec(`DELETE FROM "team" WHERE "id" = $1`, shared.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = owner.Delete(ctx, owner.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FindUser(ctx, owner.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("User still exists: %s", err)
+	}
+	for _, tid := range []string{primary.Id, lonely.Id} {
+		var n int
+		if err = mdb.QueryRow(`SELECT COUNT(*) FROM "team" WHERE "id" = $1`, tid).Scan(&n); err != nil || n != 0 {
+			t.Fatalf("Team %s was not deleted with its owner (%d, %v)", tid, n, err)
+		}
+	}
+	var members int
+	if err = mdb.QueryRow(`SELECT COUNT(*) FROM "team_user" WHERE "team" = $1`, otherTeam.Id).Scan(&members); err != nil || members != 1 {
+		t.Fatalf("Expected only the owner to remain in the other team and got %d (%v)", members, err)
+	}
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+func (u *User) GenerateTOTPSecret(ctx context.Context) ([]byte, error) {
+	if u.TotpEnabled {
+		return nil, util.NewErrorFrom(ErrAlreadyExists)
+	}
+	u.TotpSecret = util.GenerateTOTPSecret()
+	u.TotpLastCounter = 0
+	return u.TotpSecret, doTx(ctx, func(tx *sql.Tx) error {
+		return u.update(tx)
+	})
+}
+
+func (u *User) EnableTOTP(ctx context.Context, code string) error {
+	if u.TotpEnabled {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	if len(u.TotpSecret) == 0 {
+		return util.NewErrorFrom(ErrDoesntExist)
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := u.useTOTPCode(code); err != nil {
+			return err
+		}
+		u.TotpEnabled = true
+		return u.update(tx)
+	})
+}
+
+func (u *User) DisableTOTP(ctx context.Context, code string) error {
+	if !u.TotpEnabled {
+		return util.NewErrorFrom(ErrDoesntExist)
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := u.useTOTPCode(code); err != nil {
+			return err
+		}
+		u.TotpEnabled = false
+		u.TotpSecret = nil
+		u.TotpLastCounter = 0
+		return u.update(tx)
+	})
+}
+
+func (u *User) checkTOTP(tx *sql.Tx, code string) error {
+	if !u.TotpEnabled {
+		return util.NewErrorFrom(ErrUnauthorized)
+	}
+	if err := u.useTOTPCode(code); err != nil {
+		return err
+	}
+	return u.update(tx)
+}
+
+// Each code can only be used once so a sniffed code cannot be replayed within its time window
+func (u *User) useTOTPCode(code string) error {
+	counter, ok := util.CheckTOTPCode(u.TotpSecret, code, time.Now())
+	if !ok || int64(counter) <= u.TotpLastCounter {
+		return util.NewErrorFrom(ErrUnauthorized)
+	}
+	u.TotpLastCounter = int64(counter)
+	return nil
+}
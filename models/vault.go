@@ -8,22 +8,30 @@ import (
 	"github.com/keydotcat/keycatd/util"
 )
 
+// Leaves room for names encrypted and encoded by the clients
+const MAX_VAULT_NAME_LENGTH = 1024
+
+// The name is only for display and clients can store it encrypted so it does not leak anything.
+// KeepVersions and KeepVersionsDays tighten the server secret version retention for the vault.
 type Vault struct {
-	Id        string    `scaneo:"pk" json:"id"`
-	Team      string    `scaneo:"pk" json:"-"`
-	Version   uint32    `json:"version"`
-	PublicKey []byte    `json:"public_key"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Id               string    `scaneo:"pk" json:"id"`
+	Team             string    `scaneo:"pk" json:"-"`
+	Name             string    `json:"name"`
+	Version          uint32    `json:"version"`
+	PublicKey        []byte    `json:"public_key"`
+	KeepVersions     int       `json:"keep_versions"`
+	KeepVersionsDays int       `json:"keep_versions_days"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
-func createVault(tx *sql.Tx, id, team string, vkp VaultKeyPair) (*Vault, error) {
-	v := &Vault{Id: id, Team: team, Version: 1, PublicKey: vkp.PublicKey}
+func createVault(tx *sql.Tx, team, name string, vkp VaultKeyPair) (*Vault, error) {
+	v := &Vault{Id: util.GenerateRandomToken(10), Team: team, Name: name, Version: 1, PublicKey: vkp.PublicKey}
 	if err := v.insert(tx); err != nil {
 		return nil, err
 	}
 	for u, k := range vkp.Keys {
-		if err := v.addUser(tx, u, k); err != nil {
+		if err := v.addUser(tx, u, k, VAULT_PERMISSION_MANAGE); err != nil {
 			return nil, err
 		}
 	}
@@ -76,6 +84,11 @@ func (v Vault) validate() error {
 	if len(v.Team) == 0 {
 		errs.SetFieldError("vault_team", "missing")
 	}
+	if len(v.Name) == 0 {
+		errs.SetFieldError("vault_name", "missing")
+	} else if len(v.Name) > MAX_VAULT_NAME_LENGTH {
+		errs.SetFieldError("vault_name", "too long")
+	}
 	if len(v.PublicKey) != publicKeyPackSize {
 		errs.SetFieldError("vault_public_key", "invalid")
 	}
@@ -85,6 +98,30 @@ func (v Vault) validate() error {
 	return errs.SetErrorOrCamo(ErrAlreadyExists)
 }
 
+// Changes the display name of the vault. Only team admins can do it.
+func (v *Vault) Rename(ctx context.Context, admin *User, name string) error {
+	//validate camos its errors as ErrAlreadyExists for vault creation so the name is checked here
+	errs := util.NewErrorFields().(*util.Error)
+	if len(name) == 0 {
+		errs.SetFieldError("vault_name", "missing")
+	} else if len(name) > MAX_VAULT_NAME_LENGTH {
+		errs.SetFieldError("vault_name", "too long")
+	}
+	if err := errs.SetErrorOrCamo(ErrInvalidAttributes); err != nil {
+		return err
+	}
+	return doTx(ctx, func(tx *sql.Tx) error {
+		t := &Team{Id: v.Team}
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		v.Name = name
+		v.UpdatedAt = time.Now().UTC()
+		res, err := tx.Exec(`UPDATE "vault" SET "name" = $1, "updated_at" = $2 WHERE "team" = $3 AND "id" = $4`, v.Name, v.UpdatedAt, v.Team, v.Id)
+		return treatUpdateErr(res, err)
+	})
+}
+
 func (v Vault) AddUsers(ctx context.Context, userKeys map[string][]byte) error {
 	for _, k := range userKeys {
 		if _, err := verifyAndUnpack(v.PublicKey, k); err != nil {
@@ -110,7 +147,7 @@ func (v Vault) AddUsers(ctx context.Context, userKeys map[string][]byte) error {
 			}
 		}
 		for u, k := range userKeys {
-			if err := v.addUser(tx, u, k); err != nil {
+			if err := v.addOrReshareUser(tx, u, k); err != nil {
 				if IsDuplicateErr(err) {
 					return util.NewErrorFrom(ErrAlreadyExists)
 				}
@@ -121,6 +158,28 @@ func (v Vault) AddUsers(ctx context.Context, userKeys map[string][]byte) error {
 	})
 }
 
+func (v Vault) addOrReshareUser(tx *sql.Tx, username string, key []byte) error {
+	vu := &vaultUser{Team: v.Team, Vault: v.Id, User: username}
+	err := vu.dbFind(tx)
+	switch {
+	case isNotExistsErr(err):
+		if err := v.addUser(tx, username, key, VAULT_PERMISSION_WRITE); err != nil {
+			return err
+		}
+		return v.fulfillAccessRequest(tx, username)
+	case isErrOrPanic(err):
+		return util.NewErrorFrom(err)
+	case !vu.KeyLost:
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	vu.Key = key
+	vu.KeyLost = false
+	if err := vu.update(tx); err != nil {
+		return err
+	}
+	return v.fulfillAccessRequest(tx, username)
+}
+
 func (v Vault) GetUserIds(ctx context.Context) (uids []string, err error) {
 	return uids, doTx(ctx, func(tx *sql.Tx) error {
 		uids, err = v.getUserIds(tx)
@@ -129,7 +188,15 @@ func (v Vault) GetUserIds(ctx context.Context) (uids []string, err error) {
 }
 
 func (v Vault) getUserIds(tx *sql.Tx) ([]string, error) {
-	rows, err := tx.Query(`SELECT "user" FROM "vault_user" WHERE "vault_user"."vault" = $1 AND "vault_user"."team" = $2`, v.Id, v.Team)
+	return v.queryUserIds(tx, `SELECT "user" FROM "vault_user" WHERE "vault_user"."vault" = $1 AND "vault_user"."team" = $2`)
+}
+
+func (v Vault) getUserIdsWithLostKeys(tx *sql.Tx) ([]string, error) {
+	return v.queryUserIds(tx, `SELECT "user" FROM "vault_user" WHERE "vault_user"."vault" = $1 AND "vault_user"."team" = $2 AND "vault_user"."key_lost" = true`)
+}
+
+func (v Vault) queryUserIds(tx *sql.Tx, query string) ([]string, error) {
+	rows, err := tx.Query(query, v.Id, v.Team)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
@@ -148,14 +215,75 @@ func (v Vault) getUserIds(tx *sql.Tx) ([]string, error) {
 	return users, nil
 }
 
-func (v Vault) addUser(tx *sql.Tx, username string, key []byte) error {
+func (v Vault) addUser(tx *sql.Tx, username string, key []byte, permission string) error {
 	if err := v.update(tx); err != nil {
 		return err
 	}
-	vu := &vaultUser{Team: v.Team, Vault: v.Id, User: username, Key: key}
+	vu := &vaultUser{Team: v.Team, Vault: v.Id, User: username, Key: key, Permission: permission}
 	return vu.insert(tx)
 }
 
+// Checks that the user can do what the permission allows in the vault. Team admins can do anything.
+func (v Vault) CheckPermission(ctx context.Context, u *User, permission string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		return v.checkPermission(tx, u, permission)
+	})
+}
+
+func (v Vault) checkPermission(tx *sql.Tx, u *User, permission string) error {
+	t := &Team{Id: v.Team}
+	tu, err := t.getUserAffiliation(tx, u.Id)
+	if err != nil {
+		return err
+	}
+	if tu == nil {
+		return util.NewErrorFrom(ErrNotInTeam)
+	}
+	if tu.Admin {
+		return nil
+	}
+	vu := &vaultUser{Team: v.Team, Vault: v.Id, User: u.Id}
+	err = vu.dbFind(tx)
+	switch {
+	case isNotExistsErr(err):
+		return util.NewErrorFrom(ErrUnauthorized)
+	case isErrOrPanic(err):
+		return util.NewErrorFrom(err)
+	case !vu.allows(permission):
+		return util.NewErrorFrom(ErrUnauthorized)
+	}
+	return nil
+}
+
+// Changes what a user can do in the vault. Only users that can manage the vault can do it and
+// team admins always keep the manage permission.
+func (v Vault) SetUserPermission(ctx context.Context, manager *User, uid, permission string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := v.checkPermission(tx, manager, VAULT_PERMISSION_MANAGE); err != nil {
+			return err
+		}
+		t := &Team{Id: v.Team}
+		tu, err := t.getUserAffiliation(tx, uid)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		if tu.Admin && permission != VAULT_PERMISSION_MANAGE {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		vu := &vaultUser{Team: v.Team, Vault: v.Id, User: uid}
+		if err := vu.dbFind(tx); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		vu.Permission = permission
+		return vu.update(tx)
+	})
+}
+
 func (v Vault) RemoveUser(ctx context.Context, username string) error {
 	return doTx(ctx, func(tx *sql.Tx) error {
 		t := &Team{Id: v.Team}
@@ -195,11 +323,21 @@ func (v *Vault) addSecret(tx *sql.Tx, s *Secret) error {
 	if _, err := verifyAndUnpack(v.PublicKey, s.Data); err != nil {
 		return err
 	}
+	t, err := v.lockTeam(tx)
+	if err != nil {
+		return err
+	}
+	if err := t.checkSecretsLimit(tx, 1, int64(len(s.Data))); err != nil {
+		return err
+	}
 	if err := v.update(tx); err != nil {
 		return err
 	}
 	s.VaultVersion = v.Version
-	return s.insert(tx)
+	if err := s.insert(tx); err != nil {
+		return err
+	}
+	return t.addSize(tx, int64(len(s.Data)))
 }
 
 func (v *Vault) AddSecretList(ctx context.Context, sl []*Secret) error {
@@ -213,6 +351,13 @@ func (v *Vault) AddSecretList(ctx context.Context, sl []*Secret) error {
 	var err error
 	for retry := 0; retry < 3; retry++ {
 		err = doTx(ctx, func(tx *sql.Tx) error {
+			t, err := v.lockTeam(tx)
+			if err != nil {
+				return err
+			}
+			if err := t.checkSecretsLimit(tx, len(sl), secretsSize(sl)); err != nil {
+				return err
+			}
 			for _, s := range sl {
 				if err := v.update(tx); err != nil {
 					return err
@@ -222,7 +367,7 @@ func (v *Vault) AddSecretList(ctx context.Context, sl []*Secret) error {
 					return err
 				}
 			}
-			return nil
+			return t.addSize(tx, secretsSize(sl))
 		})
 		if err == ErrAlreadyExists {
 			continue
@@ -232,48 +377,70 @@ func (v *Vault) AddSecretList(ctx context.Context, sl []*Secret) error {
 	return err
 }
 
+// Stores s as the newest version of the secret. If s.Version is set it has to be the version being
+// replaced, otherwise ErrVersionConflict is returned so concurrent edits do not overwrite each other.
 func (v *Vault) UpdateSecret(ctx context.Context, s *Secret) error {
 	_, err := verifyAndUnpack(v.PublicKey, s.Data)
 	if err != nil {
 		return err
 	}
 	return doTx(ctx, func(tx *sql.Tx) error {
-		os, err := v.getSecret(tx, s.Id)
-		if err != nil {
-			return err
-		}
-		if err := v.update(tx); err != nil {
-			return err
-		}
-		s.Team = os.Team
-		s.Vault = os.Vault
-		s.Version = os.Version + 1
-		s.VaultVersion = v.Version
-		return s.update(tx)
-	})
-}
-
-func (v *Vault) DeleteSecret(ctx context.Context, sid string) error {
-	return doTx(ctx, func(tx *sql.Tx) error {
-		return v.deleteSecret(tx, sid)
+		return v.updateSecret(tx, s)
 	})
 }
 
-func (v *Vault) deleteSecret(tx *sql.Tx, sid string) error {
+func (v *Vault) updateSecret(tx *sql.Tx, s *Secret) error {
+	t, err := v.lockTeam(tx)
+	if err != nil {
+		return err
+	}
+	os, err := v.getSecret(tx, s.Id)
+	if err != nil {
+		return err
+	}
+	if s.Version != 0 && s.Version != os.Version {
+		return util.NewErrorFrom(ErrVersionConflict)
+	}
+	if err := t.checkSecretsLimit(tx, 0, int64(len(s.Data))); err != nil {
+		return err
+	}
 	if err := v.update(tx); err != nil {
 		return err
 	}
-	res, err := tx.Exec(`DELETE FROM "secret" WHERE "secret"."id" = $1`, sid)
-	return treatUpdateErr(res, err)
+	s.Team = os.Team
+	s.Vault = os.Vault
+	s.Version = os.Version + 1
+	s.VaultVersion = v.Version
+	if err := s.update(tx); err != nil {
+		return err
+	}
+	freed, err := v.pruneSecretVersions(tx, s.Id)
+	if err != nil {
+		return err
+	}
+	return t.addSize(tx, int64(len(s.Data))-freed)
 }
 
-func (v Vault) GetSecrets(ctx context.Context) ([]*Secret, error) {
-	db := GetDB(ctx)
+func (v Vault) GetSecrets(ctx context.Context) (secrets []*Secret, err error) {
+	return secrets, doTx(ctx, func(tx *sql.Tx) error {
+		secrets, err = v.getSecrets(tx)
+		return err
+	})
+}
+
+func (v Vault) getSecrets(tx *sql.Tx) ([]*Secret, error) {
+	return v.getLatestSecrets(tx, `AND "secret"."deleted_at" IS NULL`)
+}
+
+// Returns the newest version of the secrets matching the filter. Every version of a secret in the trash
+// shares the same deleted_at so filtering on it is safe before picking the newest version. Extra
+// arguments for the filter start at $3.
+func (v Vault) getLatestSecrets(tx *sql.Tx, filter string, args ...interface{}) ([]*Secret, error) {
 	query := `
 		SELECT DISTINCT ON ("secret"."team", "secret"."vault", "secret"."id") ` + selectSecretFullFields + ` 
-		FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 
+		FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 ` + filter + `
 		ORDER BY "secret"."team", "secret"."vault", "secret"."id", "secret"."version" DESC`
-	rows, err := db.Query(query, v.Team, v.Id)
+	rows, err := tx.Query(query, append([]interface{}{v.Team, v.Id}, args...)...)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
@@ -284,9 +451,37 @@ func (v Vault) GetSecrets(ctx context.Context) ([]*Secret, error) {
 	return secrets, nil
 }
 
+// Returns the secrets that changed after the given vault version, including the ones that were moved to
+// the trash, restored or moved to another vault since then. Trashed and moved secrets come with deleted_at
+// set so clients can drop them. Moved secrets only come as a tombstone without data.
+// The newest version of a secret always has the highest vault version of all its versions, so filtering
+// before picking it is safe. Secrets purged from the trash are gone for good, so ErrResyncRequired is
+// returned when the version is older than the newest purged change and clients have to download the whole
+// vault again.
+func (v Vault) GetSecretsSince(ctx context.Context, version uint32) (secrets []*Secret, err error) {
+	return secrets, doTx(ctx, func(tx *sql.Tx) error {
+		var purged uint32
+		r := tx.QueryRow(`SELECT "purged_version" FROM "vault" WHERE "team" = $1 AND "id" = $2`, v.Team, v.Id)
+		if err := r.Scan(&purged); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if version < purged {
+			return util.NewErrorFrom(ErrResyncRequired)
+		}
+		if secrets, err = v.getLatestSecrets(tx, `AND "secret"."vault_version" > $3`, version); err != nil {
+			return err
+		}
+		tombstones, err := v.getTombstonesSince(tx, version)
+		secrets = append(secrets, tombstones...)
+		return err
+	})
+}
+
 func (v Vault) GetSecretsAllVersions(ctx context.Context) ([]*Secret, error) {
 	db := GetDB(ctx)
-	query := `SELECT` + selectSecretFullFields + ` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2`
+	query := `SELECT` + selectSecretFullFields + ` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 AND "secret"."deleted_at" IS NULL`
 	rows, err := db.Query(query, v.Team, v.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
@@ -306,8 +501,12 @@ func (v Vault) GetSecret(ctx context.Context, sid string) (s *Secret, err error)
 }
 
 func (v Vault) getSecret(tx *sql.Tx, sid string) (*Secret, error) {
+	return v.getLatestSecret(tx, sid, false)
+}
+
+func (v Vault) getLatestSecret(tx *sql.Tx, sid string, trashed bool) (*Secret, error) {
 	s := &Secret{Id: sid}
-	r := tx.QueryRow(`SELECT `+selectSecretFields+` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 AND "secret"."id" = $3 ORDER BY "secret"."version" DESC LIMIT 1`, v.Team, v.Id, sid)
+	r := tx.QueryRow(`SELECT `+selectSecretFields+` FROM "secret" WHERE "secret"."team" = $1 AND "secret"."vault" = $2 AND "secret"."id" = $3 AND ("secret"."deleted_at" IS NOT NULL) = $4 ORDER BY "secret"."version" DESC LIMIT 1`, v.Team, v.Id, sid, trashed)
 	err := s.dbScanRow(r)
 	if isNotExistsErr(err) {
 		return nil, util.NewErrorFrom(ErrDoesntExist)
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Pending requests from team members to get a key for a vault. The access_required flag of the
+// team user is kept set while the user has any request pending.
+type VaultAccessRequest struct {
+	Team      string    `scaneo:"pk" json:"-"`
+	Vault     string    `scaneo:"pk" json:"vault"`
+	User      string    `scaneo:"pk" json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ar *VaultAccessRequest) insert(tx *sql.Tx) error {
+	ar.CreatedAt = time.Now().UTC()
+	_, err := ar.dbInsert(tx)
+	switch {
+	case IsDuplicateErr(err):
+		return util.NewErrorFrom(ErrAlreadyExists)
+	case isErrOrPanic(err):
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
+
+// Asks the admins of the team for a key to a vault the user does not have access to
+func (t *Team) RequestVaultAccess(ctx context.Context, u *User, vid string) (ar *VaultAccessRequest, err error) {
+	return ar, doTx(ctx, func(tx *sql.Tx) error {
+		tu, err := t.getUserAffiliation(tx, u.Id)
+		if err != nil {
+			return err
+		}
+		if tu == nil {
+			return util.NewErrorFrom(ErrNotInTeam)
+		}
+		v := &Vault{Team: t.Id, Id: vid}
+		if err := v.dbFind(tx); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		//Users that lost their key can ask for it again
+		vu := &vaultUser{Team: t.Id, Vault: vid, User: u.Id}
+		err = vu.dbFind(tx)
+		switch {
+		case isNotExistsErr(err):
+		case isErrOrPanic(err):
+			return util.NewErrorFrom(err)
+		case !vu.KeyLost:
+			return util.NewErrorFrom(ErrAlreadyExists)
+		}
+		ar = &VaultAccessRequest{Team: t.Id, Vault: vid, User: u.Id}
+		if err := ar.insert(tx); err != nil {
+			return err
+		}
+		return t.refreshAccessRequired(tx, u.Id)
+	})
+}
+
+// Drops the pending request of a user for a vault. Only admins can deny a request.
+func (t *Team) DenyVaultAccess(ctx context.Context, admin *User, vid, uid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		ar := &VaultAccessRequest{Team: t.Id, Vault: vid, User: uid}
+		if err := treatUpdateErr(ar.dbDelete(tx)); err != nil {
+			return err
+		}
+		return t.refreshAccessRequired(tx, uid)
+	})
+}
+
+func (t *Team) GetVaultAccessRequests(ctx context.Context) (ars []*VaultAccessRequest, err error) {
+	return ars, doTx(ctx, func(tx *sql.Tx) error {
+		ars, err = t.getVaultAccessRequests(tx)
+		return err
+	})
+}
+
+func (t *Team) getVaultAccessRequests(tx *sql.Tx) ([]*VaultAccessRequest, error) {
+	rows, err := tx.Query(`SELECT `+selectVaultAccessRequestFields+` FROM "vault_access_request" WHERE "team" = $1 ORDER BY "created_at"`, t.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	ars, err := scanVaultAccessRequests(rows)
+	isErrOrPanic(err)
+	return ars, util.NewErrorFrom(err)
+}
+
+// Granting a key to a user fulfills any request the user had for the vault
+func (v Vault) fulfillAccessRequest(tx *sql.Tx, uid string) error {
+	_, err := tx.Exec(`DELETE FROM "vault_access_request" WHERE "team" = $1 AND "vault" = $2 AND "user" = $3`, v.Team, v.Id, uid)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	t := &Team{Id: v.Team}
+	return t.refreshAccessRequired(tx, uid)
+}
+
+func (t *Team) refreshAccessRequired(tx *sql.Tx, uid string) error {
+	_, err := tx.Exec(`UPDATE "team_user" SET "access_required" = EXISTS (SELECT 1 FROM "vault_access_request" WHERE "team" = $1 AND "user" = $2) WHERE "team" = $1 AND "user" = $2`, t.Id, uid)
+	if isErrOrPanic(err) {
+		return util.NewErrorFrom(err)
+	}
+	return nil
+}
@@ -9,12 +9,14 @@ import (
 
 type VaultFull struct {
 	Vault
-	Key   []byte   `json:"key"`
-	Users []string `json:"users"`
+	Key          []byte   `json:"key"`
+	Permission   string   `json:"permission"`
+	Users        []string `json:"users"`
+	UsersKeyLost []string `json:"users_key_lost,omitempty"`
 }
 
 func (s *VaultFull) dbScanRow(r *sql.Row) error {
-	return r.Scan(&s.Id, &s.Team, &s.Version, &s.PublicKey, &s.CreatedAt, &s.UpdatedAt, &s.Key)
+	return r.Scan(&s.Id, &s.Team, &s.Name, &s.Version, &s.PublicKey, &s.KeepVersions, &s.KeepVersionsDays, &s.CreatedAt, &s.UpdatedAt, &s.Key, &s.Permission)
 }
 
 func scanVaultsFull(rs *sql.Rows) ([]*VaultFull, error) {
@@ -25,11 +27,15 @@ func scanVaultsFull(rs *sql.Rows) ([]*VaultFull, error) {
 		if err = rs.Scan(
 			&s.Id,
 			&s.Team,
+			&s.Name,
 			&s.Version,
 			&s.PublicKey,
+			&s.KeepVersions,
+			&s.KeepVersionsDays,
 			&s.CreatedAt,
 			&s.UpdatedAt,
 			&s.Key,
+			&s.Permission,
 		); err != nil {
 			return nil, err
 		}
@@ -49,7 +55,7 @@ func (t *Team) GetVaultsFullForUser(ctx context.Context, u *User) (vf []*VaultFu
 }
 
 func (t *Team) getVaultsFullForUser(tx *sql.Tx, u *User) ([]*VaultFull, error) {
-	rows, err := tx.Query(`SELECT `+selectVaultFullFields+`, "vault_user"."key" FROM "vault", "vault_user" WHERE  "vault"."team" = $1 AND "vault"."team" = "vault_user"."team" AND "vault"."id" = "vault_user"."vault" AND "vault_user"."user" = $2`, t.Id, u.Id)
+	rows, err := tx.Query(`SELECT `+selectVaultFullFields+`, "vault_user"."key", "vault_user"."permission" FROM "vault", "vault_user" WHERE  "vault"."team" = $1 AND "vault"."team" = "vault_user"."team" AND "vault"."id" = "vault_user"."vault" AND "vault_user"."user" = $2 AND "vault_user"."key_lost" = false`, t.Id, u.Id)
 	if isErrOrPanic(err) {
 		return nil, util.NewErrorFrom(err)
 	}
@@ -63,6 +69,9 @@ func (t *Team) getVaultsFullForUser(tx *sql.Tx, u *User) ([]*VaultFull, error) {
 			return nil, err
 		}
 		v.Users = uids
+		if v.UsersKeyLost, err = v.Vault.getUserIdsWithLostKeys(tx); err != nil {
+			return nil, err
+		}
 	}
 	return vaults, nil
 }
@@ -70,7 +79,7 @@ func (t *Team) getVaultsFullForUser(tx *sql.Tx, u *User) ([]*VaultFull, error) {
 func (v *Vault) GetVaultFullForUser(ctx context.Context, u *User) (vf *VaultFull, err error) {
 	vf = &VaultFull{}
 	return vf, doTx(ctx, func(tx *sql.Tx) error {
-		r := tx.QueryRow(`SELECT `+selectVaultFullFields+`, "vault_user"."key" FROM "vault", "vault_user" WHERE  "vault"."team" = $1 AND "vault"."team" = "vault_user"."team" AND "vault"."id" = "vault_user"."vault" AND "vault"."id" = $2 AND "vault_user"."user" = $3`, v.Team, v.Id, u.Id)
+		r := tx.QueryRow(`SELECT `+selectVaultFullFields+`, "vault_user"."key", "vault_user"."permission" FROM "vault", "vault_user" WHERE  "vault"."team" = $1 AND "vault"."team" = "vault_user"."team" AND "vault"."id" = "vault_user"."vault" AND "vault"."id" = $2 AND "vault_user"."user" = $3 AND "vault_user"."key_lost" = false`, v.Team, v.Id, u.Id)
 		err := vf.dbScanRow(r)
 		if isErrOrPanic(err) {
 			if isNotExistsErr(err) {
@@ -83,6 +92,7 @@ func (v *Vault) GetVaultFullForUser(ctx context.Context, u *User) (vf *VaultFull
 			return err
 		}
 		vf.Users = uids
-		return nil
+		vf.UsersKeyLost, err = v.getUserIdsWithLostKeys(tx)
+		return err
 	})
 }
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type LostVaultKey struct {
+	Team        string `json:"team"`
+	Vault       string `json:"vault"`
+	Recoverable bool   `json:"recoverable"`
+}
+
+// The vault keys of a user are sealed to its public key. When the user keys are replaced
+// without knowing the old ones those vault keys become unreadable and have to be re-shared
+// by another member of the vault.
+func markVaultKeysLostForUser(tx *sql.Tx, uid string) ([]*LostVaultKey, error) {
+	rows, err := tx.Query(`UPDATE "vault_user" SET "key_lost" = true, "updated_at" = $1 WHERE "user" = $2 RETURNING "team", "vault"`, time.Now().UTC(), uid)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	lost := []*LostVaultKey{}
+	for rows.Next() {
+		lvk := &LostVaultKey{}
+		if err = rows.Scan(&lvk.Team, &lvk.Vault); isErrOrPanic(err) {
+			return nil, util.NewErrorFrom(err)
+		}
+		lost = append(lost, lvk)
+	}
+	if err = rows.Err(); isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	for _, lvk := range lost {
+		var others int
+		r := tx.QueryRow(`SELECT COUNT(*) FROM "vault_user" WHERE "team" = $1 AND "vault" = $2 AND "user" != $3 AND "key_lost" = false`, lvk.Team, lvk.Vault, uid)
+		if err = r.Scan(&others); isErrOrPanic(err) {
+			return nil, util.NewErrorFrom(err)
+		}
+		lvk.Recoverable = others > 0
+	}
+	return lost, nil
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+// Replaces the vault keypair so members that were removed cannot read anything stored from now on.
+// The new public key has to be signed by the admin rotating it, there has to be a new key for every
+// member of the vault and every secret, including the ones in the trash, has to come re-encrypted with
+// the new key. The re-encrypted secrets are stored as a new version of each secret. Older versions are
+// encrypted with the retired key that nobody gets anymore, so they are purged. Returns how many were.
+func (v *Vault) RotateKeys(ctx context.Context, admin *User, signedVaultKeys VaultKeyPair, secretData map[string][]byte) (secrets []*Secret, purged int64, err error) {
+	vaultKeys, err := signedVaultKeys.verifyAndUnpack(admin.PublicKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, data := range secretData {
+		if _, err := verifyAndUnpack(vaultKeys.PublicKey, data); err != nil {
+			return nil, 0, err
+		}
+	}
+	return secrets, purged, doTx(ctx, func(tx *sql.Tx) error {
+		t, err := v.lockTeam(tx)
+		if err != nil {
+			return err
+		}
+		//Lock the vault so no secret can be added with the old key while rotating
+		r := tx.QueryRow(`SELECT `+selectVaultFields+` FROM "vault" WHERE "team" = $1 AND "id" = $2 FOR UPDATE`, v.Team, v.Id)
+		if err := v.dbScanRow(r); isNotExistsErr(err) {
+			return util.NewErrorFrom(ErrDoesntExist)
+		} else if isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		if err := t.checkAdmin(tx, admin); err != nil {
+			return err
+		}
+		uids, err := v.getUserIds(tx)
+		if err != nil {
+			return err
+		}
+		if err := vaultKeys.checkKeyIdsMatch(uids); err != nil {
+			return err
+		}
+		current, err := v.getLatestSecrets(tx, "")
+		if err != nil {
+			return err
+		}
+		if len(current) != len(secretData) {
+			return util.NewErrorFrom(ErrSecretsMismatch)
+		}
+		for _, s := range current {
+			if _, ok := secretData[s.Id]; !ok {
+				return util.NewErrorFrom(ErrSecretsMismatch)
+			}
+		}
+		v.PublicKey = vaultKeys.PublicKey
+		v.UpdatedAt = time.Now().UTC()
+		if err := v.validate(); err != nil {
+			return err
+		}
+		r = tx.QueryRow(`UPDATE "vault" SET "public_key" = $1, "version" = "version" + 1, "updated_at" = $2 WHERE "team" = $3 AND "id" = $4 RETURNING "version"`, v.PublicKey, v.UpdatedAt, v.Team, v.Id)
+		if err := r.Scan(&v.Version); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		for uid, key := range vaultKeys.Keys {
+			vu := &vaultUser{Team: v.Team, Vault: v.Id, User: uid}
+			if err := vu.dbFind(tx); isNotExistsErr(err) {
+				return util.NewErrorFrom(ErrDoesntExist)
+			} else if isErrOrPanic(err) {
+				return util.NewErrorFrom(err)
+			}
+			vu.Key = key
+			vu.KeyLost = false
+			if err := vu.update(tx); err != nil {
+				return err
+			}
+		}
+		for _, s := range current {
+			s.Data = secretData[s.Id]
+			s.Version++
+			s.VaultVersion = v.Version
+			if err := s.update(tx); err != nil {
+				return err
+			}
+		}
+		secrets = current
+		var freed int64
+		r = tx.QueryRow(`
+			WITH "purged" AS (DELETE FROM "secret" WHERE "team" = $1 AND "vault" = $2 AND "vault_version" < $3 RETURNING OCTET_LENGTH("data") AS "size")
+			SELECT COUNT(*), COALESCE(SUM("size"), 0) FROM "purged"`, v.Team, v.Id, v.Version)
+		if err := r.Scan(&purged, &freed); isErrOrPanic(err) {
+			return util.NewErrorFrom(err)
+		}
+		//Rotation is never blocked by the limits so removed members can always be locked out
+		return t.addSize(tx, secretsSize(current)-freed)
+	})
+}
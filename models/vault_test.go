@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"github.com/keydotcat/keycatd/util"
 )
@@ -67,7 +68,7 @@ func TestAddModifyAndDeleteSecret(t *testing.T) {
 	if !found {
 		t.Error("Could not find stored secret")
 	}
-	if err := vm.v.DeleteSecret(ctx, s.Id); err != nil {
+	if _, err := vm.v.DeleteSecret(ctx, s.Id, 0); err != nil {
 		t.Fatal(err)
 	}
 	if err := vm.v.UpdateSecret(ctx, s); !util.CheckErr(err, ErrDoesntExist) {
@@ -98,3 +99,390 @@ func TestAddSecretList(t *testing.T) {
 		t.Fatalf("Mismatch in the vault (%d) and secret vault (%d) version", vm.v.Version, sl[1].VaultVersion)
 	}
 }
+
+func TestRotateVaultKeys(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	ownerPrivKeys := getUserPrivateKeys(o.PublicKey, o.Key)
+	vkp := getDummyVaultKeyPair(ownerPrivKeys, o.Id)
+	if _, _, err := vm.v.RotateKeys(ctx, o, vkp, map[string][]byte{}); !util.CheckErr(err, ErrSecretsMismatch) {
+		t.Fatalf("Expected %s and got %s", ErrSecretsMismatch, err)
+	}
+	if _, _, err := vm.v.RotateKeys(ctx, o, getDummyVaultKeyPair(ownerPrivKeys, o.Id, "nobody"), nil); !util.CheckErr(err, ErrInvalidKeys) {
+		t.Fatalf("Expected %s and got %s", ErrInvalidKeys, err)
+	}
+	unpacked, err := vkp.verifyAndUnpack(o.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPriv := unsealVaultKey(&Vault{PublicKey: unpacked.PublicKey}, vkp.Keys[o.Id])
+	version := vm.v.Version
+	secrets, purged, err := vm.v.RotateKeys(ctx, o, vkp, map[string][]byte{s.Id: signAndPack(newPriv, a32b)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.v.Version != version+1 {
+		t.Fatalf("Vault version didn't increase")
+	}
+	if len(secrets) != 1 || secrets[0].Version != 2 || secrets[0].VaultVersion != vm.v.Version {
+		t.Fatalf("Unexpected rotated secrets %#v", secrets)
+	}
+	if purged != 1 {
+		t.Fatalf("Expected the old version to be purged and got %d", purged)
+	}
+	versions, err := vm.v.GetSecretVersions(ctx, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].Version != 2 {
+		t.Fatalf("Versions encrypted with the old key were kept %#v", versions)
+	}
+	vf, err := vm.v.GetVaultFullForUser(ctx, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(unsealVaultKey(&vf.Vault, vf.Key)) != string(newPriv) {
+		t.Fatal("Vault key was not rotated")
+	}
+}
+
+func TestVaultPermissions(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := getFirstVault(o, team)
+	auditor := getDummyUser()
+	if _, err := team.AddOrInviteUserByEmail(ctx, o, auditor.Email); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.AddUsers(ctx, map[string][]byte{auditor.Id: sealVaultKey(vm.v, vm.priv)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.CheckPermission(ctx, auditor, VAULT_PERMISSION_WRITE); err != nil {
+		t.Fatalf("New vault users should be able to write: %s", err)
+	}
+	if err := vm.v.SetUserPermission(ctx, auditor, auditor.Id, VAULT_PERMISSION_MANAGE); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A writer changed permissions: %s", err)
+	}
+	if err := vm.v.SetUserPermission(ctx, o, auditor.Id, "admin"); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Set an invalid permission: %s", err)
+	}
+	if err := vm.v.SetUserPermission(ctx, o, o.Id, VAULT_PERMISSION_READ); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("Downgraded a team admin: %s", err)
+	}
+	if err := vm.v.SetUserPermission(ctx, o, auditor.Id, VAULT_PERMISSION_READ); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.CheckPermission(ctx, auditor, VAULT_PERMISSION_READ); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.CheckPermission(ctx, auditor, VAULT_PERMISSION_WRITE); !util.CheckErr(err, ErrUnauthorized) {
+		t.Fatalf("A reader can write: %s", err)
+	}
+	if err := vm.v.CheckPermission(ctx, o, VAULT_PERMISSION_MANAGE); err != nil {
+		t.Fatal(err)
+	}
+	vf, err := vm.v.GetVaultFullForUser(ctx, auditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vf.Permission != VAULT_PERMISSION_READ {
+		t.Fatalf("Expected permission %s and got %s", VAULT_PERMISSION_READ, vf.Permission)
+	}
+}
+
+func TestSecretVersionsAndRestore(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	first := signAndPack(vm.priv, a32b)
+	s := &Secret{Data: first}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := vm.v.GetSecretVersions(ctx, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 2 || versions[1].Version != 1 {
+		t.Fatalf("Unexpected versions %#v", versions)
+	}
+	if _, err = vm.v.GetSecretVersions(ctx, "nonexistent"); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	if _, err = vm.v.RestoreSecretVersion(ctx, s.Id, 5); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	restored, err := vm.v.RestoreSecretVersion(ctx, s.Id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 3 || string(restored.Data) != string(first) {
+		t.Fatalf("Unexpected restored secret %#v", restored)
+	}
+	vkp := getDummyVaultKeyPair(getUserPrivateKeys(o.PublicKey, o.Key), o.Id)
+	unpacked, err := vkp.verifyAndUnpack(o.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPriv := unsealVaultKey(&Vault{PublicKey: unpacked.PublicKey}, vkp.Keys[o.Id])
+	if _, _, err = vm.v.RotateKeys(ctx, o, vkp, map[string][]byte{s.Id: signAndPack(newPriv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vm.v.RestoreSecretVersion(ctx, s.Id, 1); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Restored a version encrypted with the old vault key: %s", err)
+	}
+}
+
+func TestTrashRestoreAndPurgeSecret(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	tomb, err := vm.v.DeleteSecret(ctx, s.Id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tomb.DeletedAt.Valid || tomb.VaultVersion != vm.v.Version {
+		t.Fatalf("Unexpected tombstone %#v", tomb)
+	}
+	if _, err = vm.v.DeleteSecret(ctx, s.Id, 0); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	secrets, err := vm.v.GetSecrets(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 0 {
+		t.Fatalf("Deleted secret is still listed: %d", len(secrets))
+	}
+	if secrets, err = team.GetSecretsForUser(ctx, o); err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range secrets {
+		if ts.Id == s.Id {
+			t.Fatal("Deleted secret is still listed for the team")
+		}
+	}
+	trash, err := vm.v.GetTrash(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].Id != s.Id || !trash[0].DeletedAt.Valid {
+		t.Fatalf("Unexpected trash %#v", trash)
+	}
+	restored, err := vm.v.RestoreSecret(ctx, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.DeletedAt.Valid || restored.VaultVersion != vm.v.Version || string(restored.Data) != string(s.Data) {
+		t.Fatalf("Unexpected restored secret %#v", restored)
+	}
+	if _, err = vm.v.RestoreSecret(ctx, s.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Expected %s and got %s", ErrDoesntExist, err)
+	}
+	if err = vm.v.PurgeSecret(ctx, s.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Purged a secret that was not in the trash: %s", err)
+	}
+	if _, err = vm.v.DeleteSecret(ctx, s.Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = PurgeDeletedSecrets(ctx, time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if trash, err = vm.v.GetTrash(ctx); err != nil || len(trash) != 1 {
+		t.Fatalf("Purged a secret before the retention expired: %d %s", len(trash), err)
+	}
+	if _, err = PurgeDeletedSecrets(ctx, time.Now().UTC().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vm.v.GetSecretVersions(ctx, s.Id); !util.CheckErr(err, ErrDoesntExist) {
+		t.Fatalf("Secret was not purged: %s", err)
+	}
+}
+
+func TestGetSecretsSince(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	sl := []*Secret{
+		&Secret{Data: signAndPack(vm.priv, a32b)},
+		&Secret{Data: signAndPack(vm.priv, a32b)},
+		&Secret{Data: signAndPack(vm.priv, a32b)},
+	}
+	if err := vm.v.AddSecretList(ctx, sl); err != nil {
+		t.Fatal(err)
+	}
+	since := vm.v.Version
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: sl[0].Id, Data: signAndPack(vm.priv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.v.DeleteSecret(ctx, sl[1].Id, 0); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := vm.v.GetSecretsSince(ctx, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 2 {
+		t.Fatalf("Expected 2 changed secrets and got %d", len(changed))
+	}
+	for _, s := range changed {
+		switch s.Id {
+		case sl[0].Id:
+			if s.Version != 2 || s.DeletedAt.Valid {
+				t.Fatalf("Unexpected updated secret %#v", s)
+			}
+		case sl[1].Id:
+			if !s.DeletedAt.Valid {
+				t.Fatal("Deleted secret came without a tombstone")
+			}
+		default:
+			t.Fatalf("Unchanged secret %s was returned", s.Id)
+		}
+	}
+	if changed, err = vm.v.GetSecretsSince(ctx, vm.v.Version); err != nil || len(changed) != 0 {
+		t.Fatalf("Expected no changes and got %d (%v)", len(changed), err)
+	}
+	if changed, err = vm.v.GetSecretsSince(ctx, 0); err != nil || len(changed) != len(sl) {
+		t.Fatalf("Expected every secret and got %d (%v)", len(changed), err)
+	}
+	if err = vm.v.PurgeSecret(ctx, sl[1].Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = vm.v.GetSecretsSince(ctx, since); !util.CheckErr(err, ErrResyncRequired) {
+		t.Fatalf("Expected a resync after the purge and got %v", err)
+	}
+	if changed, err = vm.v.GetSecretsSince(ctx, vm.v.Version); err != nil || len(changed) != 0 {
+		t.Fatalf("Expected no changes after the purge and got %d (%v)", len(changed), err)
+	}
+}
+
+func TestSecretVersionConflict(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	target := createVaultMock(o, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b), Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b), Version: 1}); !util.CheckErr(err, ErrVersionConflict) {
+		t.Fatalf("Overwrote a newer version: %s", err)
+	}
+	if _, err := vm.v.DeleteSecret(ctx, s.Id, 1); !util.CheckErr(err, ErrVersionConflict) {
+		t.Fatalf("Deleted a newer version: %s", err)
+	}
+	moved := &Secret{Id: s.Id, Data: signAndPack(target.priv, a32b), Version: 1}
+	if err := MoveSecretToVault(ctx, moved, vm.v, target.v); !util.CheckErr(err, ErrVersionConflict) {
+		t.Fatalf("Moved a newer version: %s", err)
+	}
+	moved = &Secret{Id: s.Id, Data: signAndPack(target.priv, a32b), Version: 2}
+	if err := MoveSecretToVault(ctx, moved, vm.v, target.v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSecretVersionRetention(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vm.v.SetVersionRetention(ctx, getDummyUser(), 2, 0); !util.CheckErr(err, ErrNotInTeam) {
+		t.Fatalf("Expected %s and got %s", ErrNotInTeam, err)
+	}
+	if err := vm.v.SetVersionRetention(ctx, o, -1, 0); !util.CheckErr(err, ErrInvalidAttributes) {
+		t.Fatalf("Expected %s and got %s", ErrInvalidAttributes, err)
+	}
+	if err := vm.v.SetVersionRetention(ctx, o, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := vm.v.GetSecretVersions(ctx, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 4 || versions[1].Version != 3 {
+		t.Fatalf("Unexpected versions %#v", versions)
+	}
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err = vm.v.GetSecretVersions(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 5 || versions[1].Version != 4 {
+		t.Fatalf("Unexpected versions %#v", versions)
+	}
+	if got := stricterRetention(3, 0); got != 3 {
+		t.Fatalf("Expected the server retention and got %d", got)
+	}
+	if got := stricterRetention(3, 5); got != 3 {
+		t.Fatalf("A vault loosened the server retention to %d", got)
+	}
+	if got := stricterRetention(0, 5); got != 5 {
+		t.Fatalf("Expected the vault retention and got %d", got)
+	}
+}
+
+func TestSecretVersionRetentionDays(t *testing.T) {
+	ctx := getCtx()
+	o, team := getDummyOwnerWithTeam()
+	vm := createVaultMock(o, team)
+	s := &Secret{Data: signAndPack(vm.priv, a32b)}
+	if err := vm.v.AddSecret(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	backdate := func(version uint32, days int) {
+		at := time.Now().UTC().Add(-time.Duration(days) * 24 * time.Hour)
+		if _, err := mdb.Exec(`UPDATE "secret" SET "created_at" = $1 WHERE "team" = $2 AND "vault" = $3 AND "id" = $4 AND "version" = $5`, at, vm.v.Team, vm.v.Id, s.Id, version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backdate(1, 10)
+	backdate(2, 5)
+	if err := vm.v.SetVersionRetention(ctx, o, 0, 7); err != nil {
+		t.Fatal(err)
+	}
+	versions, err := vm.v.GetSecretVersions(ctx, s.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("A version superseded 5 days ago was pruned: %#v", versions)
+	}
+	//An old version overwritten today has to be kept
+	backdate(2, 8)
+	if err := vm.v.UpdateSecret(ctx, &Secret{Id: s.Id, Data: signAndPack(vm.priv, a32b)}); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err = vm.v.GetSecretVersions(ctx, s.Id); err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].Version != 3 || versions[1].Version != 2 {
+		t.Fatalf("Unexpected versions %#v", versions)
+	}
+}
@@ -7,13 +7,28 @@ import (
 	"github.com/keydotcat/keycatd/util"
 )
 
+// Permissions a user can have on a vault. Each one includes the ones before it.
+const (
+	VAULT_PERMISSION_READ   = "read"
+	VAULT_PERMISSION_WRITE  = "write"
+	VAULT_PERMISSION_MANAGE = "manage"
+)
+
+var vaultPermissionLevel = map[string]int{
+	VAULT_PERMISSION_READ:   1,
+	VAULT_PERMISSION_WRITE:  2,
+	VAULT_PERMISSION_MANAGE: 3,
+}
+
 type vaultUser struct {
-	Team      string `scaneo:"pk"`
-	Vault     string `scaneo:"pk"`
-	User      string `scaneo:"pk"`
-	Key       []byte
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	Team       string `scaneo:"pk"`
+	Vault      string `scaneo:"pk"`
+	User       string `scaneo:"pk"`
+	Key        []byte
+	KeyLost    bool
+	Permission string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
 }
 
 func (tu *vaultUser) insert(tx *sql.Tx) error {
@@ -33,6 +48,15 @@ func (tu *vaultUser) insert(tx *sql.Tx) error {
 	return nil
 }
 
+func (tu *vaultUser) update(tx *sql.Tx) error {
+	if err := tu.validate(); err != nil {
+		return err
+	}
+	tu.UpdatedAt = time.Now().UTC()
+	res, err := tu.dbUpdate(tx)
+	return treatUpdateErr(res, err)
+}
+
 func (v vaultUser) validate() error {
 	errs := util.NewErrorFields().(*util.Error)
 	if len(v.Team) == 0 {
@@ -47,5 +71,12 @@ func (v vaultUser) validate() error {
 	if len(v.Key) != privateKeyPackSize {
 		errs.SetFieldError("vaultuser_key", "invalid")
 	}
+	if _, ok := vaultPermissionLevel[v.Permission]; !ok {
+		errs.SetFieldError("vaultuser_permission", "invalid")
+	}
 	return errs.SetErrorOrCamo(ErrInvalidAttributes)
 }
+
+func (v vaultUser) allows(permission string) bool {
+	return vaultPermissionLevel[v.Permission] >= vaultPermissionLevel[permission]
+}
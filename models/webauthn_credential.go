@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"time"
+
+	"github.com/keydotcat/keycatd/util"
+)
+
+type WebauthnCredential struct {
+	Id        string    `scaneo:"pk" json:"id"`
+	User      string    `json:"-"`
+	Name      string    `json:"name"`
+	PublicKey []byte    `json:"-"`
+	SignCount int64     `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (wc *WebauthnCredential) insert(tx *sql.Tx) error {
+	if err := wc.validate(); err != nil {
+		return err
+	}
+	wc.CreatedAt = time.Now().UTC()
+	wc.UpdatedAt = wc.CreatedAt
+	_, err := wc.dbInsert(tx)
+	if IsDuplicateErr(err) {
+		return util.NewErrorFrom(ErrAlreadyExists)
+	}
+	isErrOrPanic(err)
+	return util.NewErrorFrom(err)
+}
+
+func (wc *WebauthnCredential) update(tx *sql.Tx) error {
+	if err := wc.validate(); err != nil {
+		return err
+	}
+	wc.UpdatedAt = time.Now().UTC()
+	res, err := wc.dbUpdate(tx)
+	return treatUpdateErr(res, err)
+}
+
+func (wc *WebauthnCredential) validate() error {
+	errs := util.NewErrorFields().(*util.Error)
+	if len(wc.Id) == 0 {
+		errs.SetFieldError("webauthn_id", "missing")
+	}
+	if !reValidUsername.MatchString(wc.User) {
+		errs.SetFieldError("webauthn_user", "invalid")
+	}
+	if len(wc.Name) == 0 {
+		errs.SetFieldError("webauthn_name", "invalid")
+	}
+	if len(wc.PublicKey) == 0 {
+		errs.SetFieldError("webauthn_public_key", "invalid")
+	}
+	return errs.SetErrorOrCamo(ErrInvalidAttributes)
+}
+
+func (wc *WebauthnCredential) RawId() []byte {
+	raw, _ := base64.RawURLEncoding.DecodeString(wc.Id)
+	return raw
+}
+
+func (u *User) GetWebauthnCredentials(ctx context.Context) (wcs []*WebauthnCredential, err error) {
+	return wcs, doTx(ctx, func(tx *sql.Tx) error {
+		wcs, err = u.getWebauthnCredentials(tx)
+		return err
+	})
+}
+
+func (u *User) getWebauthnCredentials(tx *sql.Tx) ([]*WebauthnCredential, error) {
+	rows, err := tx.Query(`SELECT `+selectWebauthnCredentialFields+` FROM "webauthn_credential" WHERE "user" = $1 ORDER BY "created_at"`, u.Id)
+	if isErrOrPanic(err) {
+		return nil, util.NewErrorFrom(err)
+	}
+	wcs, err := scanWebauthnCredentials(rows)
+	isErrOrPanic(err)
+	return wcs, util.NewErrorFrom(err)
+}
+
+func (u *User) DeleteWebauthnCredential(ctx context.Context, cid string) error {
+	return doTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.Exec(`DELETE FROM "webauthn_credential" WHERE "id" = $1 AND "user" = $2`, cid, u.Id)
+		return treatUpdateErr(res, err)
+	})
+}
+
+// The id of the registration token is used as the webauthn challenge
+func (u *User) NewWebauthnRegistration(ctx context.Context) (t *Token, err error) {
+	t = &Token{Type: TOKEN_WEBAUTHN_REGISTRATION, User: u.Id}
+	return t, doTx(ctx, func(tx *sql.Tx) error {
+		return t.insert(tx)
+	})
+}
+
+func (t *Token) FinishWebauthnRegistration(ctx context.Context, u *User, rp util.WebauthnRelyingParty, name string, clientDataJSON, attestationObject []byte) (wc *WebauthnCredential, err error) {
+	if t.Type != TOKEN_WEBAUTHN_REGISTRATION || t.User != u.Id || t.expired() {
+		return nil, util.NewErrorFrom(ErrDoesntExist)
+	}
+	credId, pubKey, signCount, err := rp.VerifyRegistration([]byte(t.Id), clientDataJSON, attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	wc = &WebauthnCredential{
+		Id:        base64.RawURLEncoding.EncodeToString(credId),
+		User:      u.Id,
+		Name:      name,
+		PublicKey: pubKey,
+		SignCount: int64(signCount),
+	}
+	return wc, doTx(ctx, func(tx *sql.Tx) error {
+		if err := treatUpdateErr(t.dbDelete(tx)); err != nil {
+			return err
+		}
+		return wc.insert(tx)
+	})
+}
+
+func (t *Token) CompleteLoginChallengeWithWebauthn(ctx context.Context, rp util.WebauthnRelyingParty, cid string, clientDataJSON, authData, signature []byte) (*User, *Token, error) {
+	return t.completeLoginChallenge(ctx, func(tx *sql.Tx, u *User) error {
+		wc := &WebauthnCredential{Id: cid}
+		err := wc.dbFind(tx)
+		switch {
+		case isNotExistsErr(err):
+			return util.NewErrorFrom(ErrUnauthorized)
+		case isErrOrPanic(err):
+			return util.NewErrorFrom(err)
+		case wc.User != u.Id:
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		signCount, err := rp.VerifyAssertion([]byte(t.Id), wc.PublicKey, clientDataJSON, authData, signature)
+		if err != nil {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		//A counter that does not increase means the authenticator may have been cloned
+		if (signCount != 0 || wc.SignCount != 0) && int64(signCount) <= wc.SignCount {
+			return util.NewErrorFrom(ErrUnauthorized)
+		}
+		wc.SignCount = int64(signCount)
+		return wc.update(tx)
+	})
+}
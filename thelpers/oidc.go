@@ -0,0 +1,140 @@
+package thelpers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type stubIdPGrant struct {
+	subject     string
+	email       string
+	nonce       string
+	redirectUrl string
+}
+
+// Minimal OpenID Connect provider that issues RS256 id tokens for the subjects tests log in as
+type StubIdP struct {
+	*httptest.Server
+	ClientId     string
+	ClientSecret string
+	// Overrides the aud claim and the lifetime of the issued id tokens
+	Audience string
+	TTL      time.Duration
+	key      *rsa.PrivateKey
+	lock     sync.Mutex
+	grants   map[string]stubIdPGrant
+}
+
+func NewStubIdP(clientId, clientSecret string) *StubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	idp := &StubIdP{ClientId: clientId, ClientSecret: clientSecret, Audience: clientId, TTL: time.Hour, key: key, grants: map[string]stubIdPGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/jwks", idp.jwks)
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	return idp
+}
+
+func (idp *StubIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+func (idp *StubIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := idp.key.PublicKey
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "stub",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// Plays the part of the user signing in at the authorization endpoint. Returns the code and
+// state the IdP would send back to the redirect url
+func (idp *StubIdP) Authorize(authUrl, subject, email string) (code, state string, err error) {
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		return "", "", err
+	}
+	q := u.Query()
+	if u.Scheme+"://"+u.Host+u.Path != idp.URL+"/authorize" {
+		return "", "", fmt.Errorf("Unexpected authorization endpoint %s", authUrl)
+	}
+	if q.Get("response_type") != "code" || q.Get("client_id") != idp.ClientId || len(q.Get("redirect_uri")) == 0 {
+		return "", "", fmt.Errorf("Invalid authorization request %s", authUrl)
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	code = base64.RawURLEncoding.EncodeToString(buf)
+	idp.lock.Lock()
+	idp.grants[code] = stubIdPGrant{subject, email, q.Get("nonce"), q.Get("redirect_uri")}
+	idp.lock.Unlock()
+	return code, q.Get("state"), nil
+}
+
+func (idp *StubIdP) token(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != idp.ClientId || secret != idp.ClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	idp.lock.Lock()
+	grant, ok := idp.grants[r.PostFormValue("code")]
+	delete(idp.grants, r.PostFormValue("code"))
+	idp.lock.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != grant.redirectUrl {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            idp.URL,
+		"sub":            grant.subject,
+		"aud":            idp.Audience,
+		"iat":            now.Unix(),
+		"exp":            now.Add(idp.TTL).Unix(),
+		"nonce":          grant.nonce,
+		"email":          grant.email,
+		"email_verified": true,
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "stub",
+		"token_type":   "Bearer",
+		"id_token":     idp.SignIdToken(claims),
+	})
+}
+
+func (idp *StubIdP) SignIdToken(claims map[string]interface{}) string {
+	hdr, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "stub", "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(hdr) + "." + base64.RawURLEncoding.EncodeToString(body)
+	h := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
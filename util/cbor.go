@@ -0,0 +1,155 @@
+package util
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+var ErrInvalidCBOR = errors.New("Invalid CBOR data")
+
+// Minimal CBOR (RFC 7049) decoder. It only understands what authenticators send: integers,
+// byte and text strings, arrays, maps and simple values. Integers are decoded as int64,
+// maps as map[interface{}]interface{}.
+func DecodeCBOR(data []byte) (interface{}, int, error) {
+	d := cborDecoder{data, 0}
+	v, err := d.decode(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+const cborMaxDepth = 16
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) read(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, NewErrorFrom(ErrInvalidCBOR)
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+func (d *cborDecoder) readArgument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		b, err := d.read(1)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(b[0]), nil
+	case info == 25:
+		b, err := d.read(2)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case info == 26:
+		b, err := d.read(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	case info == 27:
+		b, err := d.read(8)
+		if err != nil {
+			return 0, err
+		}
+		return binary.BigEndian.Uint64(b), nil
+	}
+	//Indefinite lengths are not allowed in webauthn's canonical CBOR
+	return 0, NewErrorFrom(ErrInvalidCBOR)
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, NewErrorFrom(ErrInvalidCBOR)
+	}
+	h, err := d.read(1)
+	if err != nil {
+		return nil, err
+	}
+	major, info := h[0]>>5, h[0]&0x1f
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		}
+		return nil, NewErrorFrom(ErrInvalidCBOR)
+	}
+	arg, err := d.readArgument(info)
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, NewErrorFrom(ErrInvalidCBOR)
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, NewErrorFrom(ErrInvalidCBOR)
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case 3:
+		b, err := d.read(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 4:
+		if arg > uint64(len(d.data)) {
+			return nil, NewErrorFrom(ErrInvalidCBOR)
+		}
+		l := make([]interface{}, arg)
+		for i := range l {
+			if l[i], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return l, nil
+	case 5:
+		if arg > uint64(len(d.data)) {
+			return nil, NewErrorFrom(ErrInvalidCBOR)
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, NewErrorFrom(ErrInvalidCBOR)
+			}
+			if m[k], err = d.decode(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case 6:
+		//Tags are ignored and the tagged value returned
+		return d.decode(depth + 1)
+	}
+	return nil, NewErrorFrom(ErrInvalidCBOR)
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func decodeHexCBOR(t *testing.T, h string) interface{} {
+	data, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, n, err := DecodeCBOR(data)
+	if err != nil {
+		t.Fatalf("Could not decode %s: %s", h, err)
+	}
+	if n != len(data) {
+		t.Fatalf("Decoded %d bytes out of %d for %s", n, len(data), h)
+	}
+	return v
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	//Examples from RFC 7049 appendix A
+	ints := map[string]int64{
+		"00":                 0,
+		"17":                 23,
+		"1818":               24,
+		"1903e8":             1000,
+		"1a000f4240":         1000000,
+		"1b000000e8d4a51000": 1000000000000,
+		"20":                 -1,
+		"3863":               -100,
+		"3903e7":             -1000,
+	}
+	for h, expected := range ints {
+		if v := decodeHexCBOR(t, h); v != expected {
+			t.Errorf("Unexpected value for %s: %v vs %d", h, v, expected)
+		}
+	}
+	if v := decodeHexCBOR(t, "4401020304"); !bytes.Equal(v.([]byte), []byte{1, 2, 3, 4}) {
+		t.Errorf("Unexpected byte string %v", v)
+	}
+	if v := decodeHexCBOR(t, "6449455446"); v != "IETF" {
+		t.Errorf("Unexpected text string %v", v)
+	}
+	if v := decodeHexCBOR(t, "f5"); v != true {
+		t.Errorf("Unexpected simple value %v", v)
+	}
+	l := decodeHexCBOR(t, "8301820203820405").([]interface{})
+	if len(l) != 3 || l[0] != int64(1) || l[2].([]interface{})[1] != int64(5) {
+		t.Errorf("Unexpected array %v", l)
+	}
+	m := decodeHexCBOR(t, "a201020304").(map[interface{}]interface{})
+	if m[int64(1)] != int64(2) || m[int64(3)] != int64(4) {
+		t.Errorf("Unexpected map %v", m)
+	}
+	m = decodeHexCBOR(t, "a26161016162820203").(map[interface{}]interface{})
+	if m["a"] != int64(1) || len(m["b"].([]interface{})) != 2 {
+		t.Errorf("Unexpected map %v", m)
+	}
+	for _, h := range []string{"", "18", "5f", "44010203", "9f01ff", "a1800102"} {
+		data, _ := hex.DecodeString(h)
+		if _, _, err := DecodeCBOR(data); err == nil {
+			t.Errorf("Expected an error when decoding %s", h)
+		}
+	}
+}
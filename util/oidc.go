@@ -0,0 +1,307 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInvalidOIDCResponse = errors.New("Invalid OIDC response")
+
+const oidcClockSkew = time.Minute
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type oidcJwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Claims of a verified id token that keycatd cares about
+type OIDCClaims struct {
+	Issuer        string `json:"iss"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+type oidcIdToken struct {
+	OIDCClaims
+	Audience  oidcAudience `json:"aud"`
+	Azp       string       `json:"azp"`
+	Nonce     string       `json:"nonce"`
+	ExpiresAt int64        `json:"exp"`
+	IssuedAt  int64        `json:"iat"`
+}
+
+// The aud claim can either be a string or a list of strings
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+// Relying party for an OpenID Connect provider using the authorization code flow. The provider
+// configuration and signing keys are discovered on first use and the keys are reloaded when an
+// id token is signed with an unknown key.
+type OIDCProvider struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	client       *http.Client
+	lock         sync.Mutex
+	discovery    *oidcDiscovery
+	keys         map[string]crypto.PublicKey
+}
+
+func NewOIDCProvider(issuer, clientId, clientSecret, redirectUrl string) *OIDCProvider {
+	return &OIDCProvider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		RedirectUrl:  redirectUrl,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *OIDCProvider) getJSON(u string, obj interface{}) error {
+	resp, err := p.client.Get(u)
+	if err != nil {
+		return NewErrorf("Could not reach the OIDC provider: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return NewErrorf("OIDC provider replied %s for %s", resp.Status, u)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(obj); err != nil {
+		return NewErrorf("Could not parse the OIDC provider reply from %s: %s", u, err)
+	}
+	return nil
+}
+
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	d := &oidcDiscovery{}
+	if err := p.getJSON(p.Issuer+"/.well-known/openid-configuration", d); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return nil, NewErrorf("OIDC provider reports issuer %s instead of %s", d.Issuer, p.Issuer)
+	}
+	if len(d.AuthorizationEndpoint) == 0 || len(d.TokenEndpoint) == 0 || len(d.JwksUri) == 0 {
+		return nil, NewErrorf("OIDC provider %s does not support the authorization code flow", p.Issuer)
+	}
+	p.discovery = d
+	return d, nil
+}
+
+func (p *OIDCProvider) getKey(kid string) (crypto.PublicKey, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	jwks := struct {
+		Keys []oidcJwk `json:"keys"`
+	}{}
+	if err := p.getJSON(d.JwksUri, &jwks); err != nil {
+		return nil, err
+	}
+	p.keys = map[string]crypto.PublicKey{}
+	for _, jwk := range jwks.Keys {
+		if key := jwk.publicKey(); key != nil {
+			p.keys[jwk.Kid] = key
+		}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+}
+
+func (jwk oidcJwk) publicKey() crypto.PublicKey {
+	dec := base64.RawURLEncoding.DecodeString
+	switch jwk.Kty {
+	case "RSA":
+		n, errN := dec(jwk.N)
+		e, errE := dec(jwk.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			return nil
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	case "EC":
+		x, errX := dec(jwk.X)
+		y, errY := dec(jwk.Y)
+		if errX != nil || errY != nil || jwk.Crv != "P-256" {
+			return nil
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil
+		}
+		return key
+	}
+	return nil
+}
+
+// Url to send the user to. The state and nonce have to be checked when the user comes back.
+func (p *OIDCProvider) AuthorizationUrl(state, nonce string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientId)
+	q.Set("redirect_uri", p.RedirectUrl)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchanges the authorization code and returns the claims of the verified id token
+func (p *OIDCProvider) Exchange(code, nonce string) (*OIDCClaims, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectUrl)
+	req, err := http.NewRequest("POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, NewErrorFrom(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, NewErrorf("Could not reach the OIDC provider: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	tokens := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	return p.VerifyIdToken(tokens.IdToken, nonce)
+}
+
+func (p *OIDCProvider) VerifyIdToken(raw, nonce string) (*OIDCClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	hdrData, errH := base64.RawURLEncoding.DecodeString(parts[0])
+	body, errB := base64.RawURLEncoding.DecodeString(parts[1])
+	sig, errS := base64.RawURLEncoding.DecodeString(parts[2])
+	if errH != nil || errB != nil || errS != nil {
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	hdr := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(hdrData, &hdr); err != nil {
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	key, err := p.getKey(hdr.Kid)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if hdr.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], sig) != nil {
+			return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+		}
+	case *ecdsa.PublicKey:
+		if hdr.Alg != "ES256" || len(sig) != 64 || !ecdsa.Verify(k, h[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+		}
+	default:
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	tok := &oidcIdToken{}
+	if err := json.Unmarshal(body, tok); err != nil {
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimRight(tok.Issuer, "/") != p.Issuer, len(tok.Subject) == 0:
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	case !tok.Audience.contains(p.ClientId), len(tok.Audience) > 1 && tok.Azp != p.ClientId:
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	case now.After(time.Unix(tok.ExpiresAt, 0).Add(oidcClockSkew)), now.Add(oidcClockSkew).Before(time.Unix(tok.IssuedAt, 0)):
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	case subtle.ConstantTimeCompare([]byte(tok.Nonce), []byte(nonce)) != 1:
+		return nil, NewErrorFrom(ErrInvalidOIDCResponse)
+	}
+	//Some providers add a trailing slash to the issuer. Always hand out the configured one so identities match.
+	tok.Issuer = p.Issuer
+	return &tok.OIDCClaims, nil
+}
+
+func (a oidcAudience) contains(clientId string) bool {
+	for _, aud := range a {
+		if aud == clientId {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/keydotcat/keycatd/thelpers"
+)
+
+func TestOIDCAuthorizationCodeFlow(t *testing.T) {
+	idp := thelpers.NewStubIdP("keycatd", "s3cr3t")
+	defer idp.Close()
+	p := NewOIDCProvider(idp.URL+"/", "keycatd", "s3cr3t", "https://key.cat/oidc")
+	authUrl, err := p.AuthorizationUrl("somestate", "somenonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := idp.Authorize(authUrl, "subject1", "sub@key.cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "somestate" {
+		t.Fatalf("State was not forwarded. Got %s", state)
+	}
+	if _, err = p.Exchange(code, "othernonce"); !CheckErr(err, ErrInvalidOIDCResponse) {
+		t.Fatalf("Accepted an id token with a different nonce: %s", err)
+	}
+	code, _, _ = idp.Authorize(authUrl, "subject1", "sub@key.cat")
+	claims, err := p.Exchange(code, "somenonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject1" || claims.Email != "sub@key.cat" || !claims.EmailVerified || claims.Issuer != idp.URL {
+		t.Fatalf("Unexpected claims %#v", claims)
+	}
+	if _, err = p.Exchange(code, "somenonce"); !CheckErr(err, ErrInvalidOIDCResponse) {
+		t.Fatalf("Could reuse an authorization code: %s", err)
+	}
+	bad := NewOIDCProvider(idp.URL, "keycatd", "wrong", "https://key.cat/oidc")
+	code, _, _ = idp.Authorize(authUrl, "subject1", "sub@key.cat")
+	if _, err = bad.Exchange(code, "somenonce"); !CheckErr(err, ErrInvalidOIDCResponse) {
+		t.Fatalf("Exchanged a code with the wrong client secret: %s", err)
+	}
+}
+
+func TestOIDCVerifyIdToken(t *testing.T) {
+	idp := thelpers.NewStubIdP("keycatd", "s3cr3t")
+	defer idp.Close()
+	p := NewOIDCProvider(idp.URL, "keycatd", "s3cr3t", "https://key.cat/oidc")
+	now := time.Now()
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{"iss": idp.URL, "sub": "s", "aud": "keycatd", "nonce": "n", "iat": now.Unix(), "exp": now.Add(time.Minute).Unix()}
+	}
+	if _, err := p.VerifyIdToken(idp.SignIdToken(claims()), "n"); err != nil {
+		t.Fatal(err)
+	}
+	slashed := claims()
+	slashed["iss"] = idp.URL + "/"
+	got, err := p.VerifyIdToken(idp.SignIdToken(slashed), "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Issuer != p.Issuer {
+		t.Fatalf("Issuer with a trailing slash was not normalized: %s vs %s", got.Issuer, p.Issuer)
+	}
+	bad := map[string]func(map[string]interface{}){
+		"other issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.cat" },
+		"other audience": func(c map[string]interface{}) { c["aud"] = []string{"other"} },
+		"foreign azp":    func(c map[string]interface{}) { c["aud"] = []string{"keycatd", "other"}; c["azp"] = "other" },
+		"expired":        func(c map[string]interface{}) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"no subject":     func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, tamper := range bad {
+		c := claims()
+		tamper(c)
+		if _, err := p.VerifyIdToken(idp.SignIdToken(c), "n"); !CheckErr(err, ErrInvalidOIDCResponse) {
+			t.Errorf("Accepted an id token with %s: %s", name, err)
+		}
+	}
+	c := claims()
+	c["aud"] = []string{"keycatd", "other"}
+	c["azp"] = "keycatd"
+	if _, err := p.VerifyIdToken(idp.SignIdToken(c), "n"); err != nil {
+		t.Errorf("Rejected an id token with several audiences: %s", err)
+	}
+	raw := idp.SignIdToken(claims())
+	if _, err := p.VerifyIdToken(raw[:len(raw)-4]+"AAAA", "n"); !CheckErr(err, ErrInvalidOIDCResponse) {
+		t.Errorf("Accepted a tampered signature: %s", err)
+	}
+}
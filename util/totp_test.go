@@ -0,0 +1,48 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for ts, expected := range vectors {
+		code := hotp(key, TOTPCounter(time.Unix(ts, 0)), 8)
+		if code != expected {
+			t.Errorf("Unexpected code for %d: %s vs %s", ts, code, expected)
+		}
+	}
+}
+
+func TestCheckTOTPCode(t *testing.T) {
+	key := GenerateTOTPSecret()
+	now := time.Unix(1234567890, 0)
+	code := TOTPCode(key, now)
+	if len(code) != TOTP_DIGITS {
+		t.Fatalf("Unexpected code length %d", len(code))
+	}
+	for _, drift := range []time.Duration{-TOTP_PERIOD * time.Second, 0, TOTP_PERIOD * time.Second} {
+		counter, ok := CheckTOTPCode(key, code, now.Add(drift))
+		if !ok {
+			t.Fatalf("Code was not accepted with a drift of %s", drift)
+		}
+		if counter != TOTPCounter(now) {
+			t.Errorf("Unexpected counter %d vs %d", counter, TOTPCounter(now))
+		}
+	}
+	if _, ok := CheckTOTPCode(key, code, now.Add(3*TOTP_PERIOD*time.Second)); ok {
+		t.Errorf("Code was accepted way after it expired")
+	}
+	if _, ok := CheckTOTPCode(GenerateTOTPSecret(), code, now); ok {
+		t.Errorf("Code was accepted for a different secret")
+	}
+}
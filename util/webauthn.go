@@ -0,0 +1,199 @@
+package util
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+var ErrInvalidWebauthnResponse = errors.New("Invalid webauthn response")
+
+const (
+	WEBAUTHN_ALG_ES256 = -7
+	WEBAUTHN_ALG_EDDSA = -8
+
+	webauthnFlagUserPresent  = 0x01
+	webauthnFlagAttestedData = 0x40
+)
+
+// Relying party as seen by the browser. The id is the domain keycatd is served from and the
+// origin is the scheme and host the web client runs in.
+type WebauthnRelyingParty struct {
+	Id     string `json:"id"`
+	Name   string `json:"name"`
+	Origin string `json:"-"`
+}
+
+func NewWebauthnRelyingParty(name, rootUrl string) (WebauthnRelyingParty, error) {
+	u, err := url.Parse(rootUrl)
+	if err != nil {
+		return WebauthnRelyingParty{}, NewErrorFrom(err)
+	}
+	if len(u.Hostname()) == 0 {
+		return WebauthnRelyingParty{}, NewErrorf("Url %s has no host to use as webauthn relying party", rootUrl)
+	}
+	return WebauthnRelyingParty{u.Hostname(), name, u.Scheme + "://" + u.Host}, nil
+}
+
+type webauthnClientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp WebauthnRelyingParty) checkClientData(raw []byte, ceremony string, challenge []byte) error {
+	cd := webauthnClientData{}
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	if cd.Type != ceremony || cd.Origin != rp.Origin {
+		return NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	if strings.TrimRight(cd.Challenge, "=") != base64.RawURLEncoding.EncodeToString(challenge) {
+		return NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	return nil
+}
+
+type webauthnAuthData struct {
+	rpIdHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialId []byte
+	publicKey    []byte
+}
+
+func (rp WebauthnRelyingParty) parseAuthData(data []byte) (*webauthnAuthData, error) {
+	if len(data) < 37 {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	ad := &webauthnAuthData{
+		rpIdHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rpIdHash := sha256.Sum256([]byte(rp.Id))
+	if !bytes.Equal(ad.rpIdHash, rpIdHash[:]) || ad.flags&webauthnFlagUserPresent == 0 {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	if ad.flags&webauthnFlagAttestedData == 0 {
+		return ad, nil
+	}
+	//16 bytes of AAGUID followed by the credential id length
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	ad.credentialId = rest[:idLen]
+	rest = rest[idLen:]
+	_, n, err := DecodeCBOR(rest)
+	if err != nil {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	ad.publicKey = rest[:n]
+	return ad, nil
+}
+
+// VerifyWebauthnRegistration checks the response of navigator.credentials.create(). Only
+// attestation "none" is requested so the attestation statement itself is not verified.
+// It returns the credential id, its COSE encoded public key and the signature counter.
+func (rp WebauthnRelyingParty) VerifyRegistration(challenge, clientDataJSON, attestationObject []byte) ([]byte, []byte, uint32, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, nil, 0, err
+	}
+	att, _, err := DecodeCBOR(attestationObject)
+	if err != nil {
+		return nil, nil, 0, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	attMap, ok := att.(map[interface{}]interface{})
+	if !ok {
+		return nil, nil, 0, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	authData, ok := attMap["authData"].([]byte)
+	if !ok {
+		return nil, nil, 0, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	ad, err := rp.parseAuthData(authData)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(ad.credentialId) == 0 {
+		return nil, nil, 0, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	if _, err := parseCOSEKey(ad.publicKey); err != nil {
+		return nil, nil, 0, err
+	}
+	return ad.credentialId, ad.publicKey, ad.signCount, nil
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get() against the stored
+// COSE public key and returns the new signature counter.
+func (rp WebauthnRelyingParty) VerifyAssertion(challenge, publicKey, clientDataJSON, authData, signature []byte) (uint32, error) {
+	if err := rp.checkClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	ad, err := rp.parseAuthData(authData)
+	if err != nil {
+		return 0, err
+	}
+	verify, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	cdHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), cdHash[:]...)
+	if !verify(signed, signature) {
+		return 0, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	return ad.signCount, nil
+}
+
+func parseCOSEKey(data []byte) (func(msg, sig []byte) bool, error) {
+	k, _, err := DecodeCBOR(data)
+	if err != nil {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	key, ok := k.(map[interface{}]interface{})
+	if !ok {
+		return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+	}
+	x, _ := key[int64(-2)].([]byte)
+	switch key[int64(3)] {
+	case int64(WEBAUTHN_ALG_ES256):
+		y, _ := key[int64(-3)].([]byte)
+		if key[int64(1)] != int64(2) || key[int64(-1)] != int64(1) || len(x) != 32 || len(y) != 32 {
+			return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+		}
+		return func(msg, sig []byte) bool {
+			h := sha256.Sum256(msg)
+			return ecdsa.VerifyASN1(pub, h[:], sig)
+		}, nil
+	case int64(WEBAUTHN_ALG_EDDSA):
+		if key[int64(1)] != int64(1) || key[int64(-1)] != int64(6) || len(x) != ed25519.PublicKeySize {
+			return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+		}
+		return func(msg, sig []byte) bool {
+			return ed25519.Verify(ed25519.PublicKey(x), msg, sig)
+		}, nil
+	}
+	return nil, NewErrorFrom(ErrInvalidWebauthnResponse)
+}
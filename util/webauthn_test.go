@@ -0,0 +1,119 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+type softAuthenticator struct {
+	rp    WebauthnRelyingParty
+	id    []byte
+	key   *ecdsa.PrivateKey
+	count uint32
+}
+
+func newSoftAuthenticator(rp WebauthnRelyingParty) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return &softAuthenticator{rp, GenerateRandomByteArray(16), key, 0}
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	k := []byte{0xa5, 0x01, 0x02, 0x03, 0x26, 0x20, 0x01, 0x21, 0x58, 0x20}
+	k = append(k, x...)
+	k = append(k, 0x22, 0x58, 0x20)
+	return append(k, y...)
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIdHash := sha256.Sum256([]byte(a.rp.Id))
+	a.count++
+	ad := append([]byte{}, rpIdHash[:]...)
+	ad = append(ad, webauthnFlagUserPresent, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(ad[33:], a.count)
+	if attested {
+		ad[32] |= webauthnFlagAttestedData
+		ad = append(ad, make([]byte, 16)...)
+		ad = append(ad, byte(len(a.id)>>8), byte(len(a.id)))
+		ad = append(ad, a.id...)
+		ad = append(ad, a.coseKey()...)
+	}
+	return ad
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	cd, err := json.Marshal(webauthnClientData{ceremony, base64.RawURLEncoding.EncodeToString(challenge), a.rp.Origin})
+	if err != nil {
+		panic(err)
+	}
+	return cd
+}
+
+func (a *softAuthenticator) create(challenge []byte) ([]byte, []byte) {
+	ad := a.authData(true)
+	att := []byte{0xa3, 0x63, 'f', 'm', 't', 0x64, 'n', 'o', 'n', 'e', 0x67, 'a', 't', 't', 'S', 't', 'm', 't', 0xa0, 0x68, 'a', 'u', 't', 'h', 'D', 'a', 't', 'a', 0x59, byte(len(ad) >> 8), byte(len(ad))}
+	return a.clientData("webauthn.create", challenge), append(att, ad...)
+}
+
+func (a *softAuthenticator) get(challenge []byte) ([]byte, []byte, []byte) {
+	cd := a.clientData("webauthn.get", challenge)
+	ad := a.authData(false)
+	cdHash := sha256.Sum256(cd)
+	h := sha256.Sum256(append(append([]byte{}, ad...), cdHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, h[:])
+	if err != nil {
+		panic(err)
+	}
+	return cd, ad, sig
+}
+
+func TestWebauthnRegistrationAndAssertion(t *testing.T) {
+	rp, err := NewWebauthnRelyingParty("Key.cat", "https://key.cat:8443/some/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rp.Id != "key.cat" || rp.Origin != "https://key.cat:8443" {
+		t.Fatalf("Unexpected relying party %#v", rp)
+	}
+	a := newSoftAuthenticator(rp)
+	challenge := GenerateRandomByteArray(32)
+	cd, att := a.create(challenge)
+	if _, _, _, err = rp.VerifyRegistration(GenerateRandomByteArray(32), cd, att); !CheckErr(err, ErrInvalidWebauthnResponse) {
+		t.Fatalf("Accepted a registration for a different challenge: %s", err)
+	}
+	credId, pubKey, count, err := rp.VerifyRegistration(challenge, cd, att)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(credId) != string(a.id) || count != 1 {
+		t.Fatalf("Unexpected credential %x with count %d", credId, count)
+	}
+	cd, ad, sig := a.get(challenge)
+	if count, err = rp.VerifyAssertion(challenge, pubKey, cd, ad, sig); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Unexpected sign count %d", count)
+	}
+	sig[len(sig)-1] ^= 0xff
+	if _, err = rp.VerifyAssertion(challenge, pubKey, cd, ad, sig); !CheckErr(err, ErrInvalidWebauthnResponse) {
+		t.Fatalf("Accepted an invalid signature: %s", err)
+	}
+	other, _ := NewWebauthnRelyingParty("Key.cat", "https://evil.cat")
+	cd, ad, sig = newSoftAuthenticator(other).get(challenge)
+	if _, err = rp.VerifyAssertion(challenge, pubKey, cd, ad, sig); !CheckErr(err, ErrInvalidWebauthnResponse) {
+		t.Fatalf("Accepted an assertion for a different origin: %s", err)
+	}
+}